@@ -2,20 +2,34 @@ package application
 
 import (
 	"context"
+	"first-little-server/order"
+	"first-little-server/webhook"
 	"fmt"
 	"net/http"
+	"sync"
 	"time"
 )
 
 type App struct {
 	router http.Handler
 	ds     *Datastore
+	hub    *order.Hub
+	// ids is shared by the handlers, so that the sequence of a millisecond is never handed out twice.
+	ids    *order.Snowflake
 	config Config
 }
 
+// subscriberBuffer bounds the events queued for a single event stream.
+const subscriberBuffer = 64
+
 func NewApp(ctx context.Context, config Config) *App {
 	app := &App{
-		ds:     NewDatastore(ctx, config),
+		ds: NewDatastore(ctx, config),
+		hub: &order.Hub{
+			BufferSize:       config.Events.BufferSize,
+			SubscriberBuffer: subscriberBuffer,
+		},
+		ids:    &order.Snowflake{NodeID: config.NodeID},
 		config: config,
 	}
 
@@ -30,10 +44,17 @@ func (app *App) Start(ctx context.Context) error {
 		Handler: app.router,
 	}
 
+	// Event streams never go idle, they must end for the shutdown to complete.
+	server.RegisterOnShutdown(app.hub.Close)
+
 	if err := app.ds.Ping(ctx); err != nil {
 		return err
 	}
 
+	if err := app.checkMigrations(ctx); err != nil {
+		return err
+	}
+
 	// Wrap defer call in anonymous function because defer keyword does not work when returning an error.
 	defer func() {
 		if err := app.ds.Close(ctx); err != nil {
@@ -41,6 +62,16 @@ func (app *App) Start(ctx context.Context) error {
 		}
 	}()
 
+	// Registered after the datastore close, so that the background tasks stop before the datastore closes.
+	stopPurge := app.startPurge(ctx)
+	defer stopPurge()
+
+	stopRelay := app.startRelay(ctx)
+	defer stopRelay()
+
+	stopWebhooks := app.startWebhooks(ctx)
+	defer stopWebhooks()
+
 	fmt.Println("Starting server")
 
 	channel := make(chan error, 1)
@@ -65,3 +96,210 @@ func (app *App) Start(ctx context.Context) error {
 		return server.Shutdown(shutdownCtx)
 	}
 }
+
+// checkMigrations fails when the configuration requires an up-to-date schema and migrations are pending.
+func (app *App) checkMigrations(ctx context.Context) error {
+	migrator := app.ds.GetMigrator()
+	if !app.config.RequireMigrated || migrator == nil {
+		return nil
+	}
+
+	pending, err := migrator.Pending(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to check migrations: %w", err)
+	}
+
+	if pending > 0 {
+		return fmt.Errorf("schema is behind by %d migration(s), run the migrate up command", pending)
+	}
+
+	return nil
+}
+
+// startPurge periodically purges the orders soft deleted for longer than the retention period,
+// the expired idempotency keys and the outbox events dispatched for longer than their retention period.
+func (app *App) startPurge(ctx context.Context) func() {
+	return startBackground(ctx, func(ctx context.Context) {
+		ticker := time.NewTicker(app.config.PurgeInterval)
+		defer ticker.Stop()
+
+		for {
+			app.purgeDeleted(ctx)
+			app.purgeIdempotencyKeys(ctx)
+			app.purgeOutbox(ctx)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	})
+}
+
+// startRelay dispatches the events of the postgres outbox to the webhooks.
+func (app *App) startRelay(ctx context.Context) func() {
+	relay := app.ds.GetOutboxRelay(&webhook.Dispatcher{Repo: app.ds.GetWebhookRepo()})
+	if relay == nil {
+		return func() {}
+	}
+
+	return startBackground(ctx, func(ctx context.Context) {
+		relay.Run(ctx, app.config.Outbox.PollInterval)
+	})
+}
+
+// startWebhooks runs the delivery worker of the webhooks. Without the postgres outbox, the events
+// of the handlers are dispatched to the webhooks from the hub instead.
+func (app *App) startWebhooks(ctx context.Context) func() {
+	repo := app.ds.GetWebhookRepo()
+	if repo == nil {
+		return func() {}
+	}
+
+	worker := &webhook.Worker{
+		Repo:        repo,
+		Client:      webhook.NewClient(app.config.Webhook.Timeout, webhook.AddressPolicy{Allowed: app.config.Webhook.AllowedNets}),
+		BatchSize:   app.config.Webhook.BatchSize,
+		Lease:       app.config.Webhook.Lease,
+		MinBackoff:  app.config.Webhook.MinBackoff,
+		MaxBackoff:  app.config.Webhook.MaxBackoff,
+		MaxAttempts: app.config.Webhook.MaxAttempts,
+	}
+
+	stopWorker := startBackground(ctx, func(ctx context.Context) {
+		worker.Run(ctx, app.config.Webhook.PollInterval)
+	})
+
+	// The outbox relay dispatches the events when postgres is active.
+	if app.ds.GetOutboxRelay(nil) != nil {
+		return stopWorker
+	}
+
+	stopForward := startBackground(ctx, func(ctx context.Context) {
+		forwardEvents(ctx, app.hub, &webhook.Dispatcher{Repo: repo})
+	})
+
+	return func() {
+		stopForward()
+		stopWorker()
+	}
+}
+
+// forwardEvents publishes the events of the hub to sink until ctx is done.
+// A subscription closed for falling behind resumes from the hub buffer.
+func forwardEvents(ctx context.Context, hub *order.Hub, sink order.EventSink) {
+	var lastID *uint64
+
+	for {
+		replay, missed, events, cancel := hub.Subscribe(order.EventFilter{}, lastID)
+		if missed {
+			fmt.Println("webhook events were dropped, the hub buffer is too small")
+		}
+
+		for _, event := range replay {
+			publishEvent(ctx, sink, event.Event)
+			lastID = &event.ID
+		}
+
+	receive:
+		for {
+			select {
+			case <-ctx.Done():
+				cancel()
+				return
+			case event, ok := <-events:
+				if !ok {
+					break receive
+				}
+				publishEvent(ctx, sink, event.Event)
+				lastID = &event.ID
+			}
+		}
+		cancel()
+
+		// The channel also closes with the hub, the wait keeps a closed hub from spinning the loop.
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func publishEvent(ctx context.Context, sink order.EventSink, event order.DomainEvent) {
+	if err := sink.Publish(ctx, event); err != nil && ctx.Err() == nil {
+		fmt.Println("failed to dispatch event to webhooks:", err)
+	}
+}
+
+// startBackground runs task in a goroutine until ctx is done.
+// The returned function stops the task and waits for it to return.
+func startBackground(ctx context.Context, task func(ctx context.Context)) func() {
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		task(ctx)
+	}()
+
+	return func() {
+		cancel()
+		wg.Wait()
+	}
+}
+
+func (app *App) purgeDeleted(ctx context.Context) {
+	if app.config.DeletedRetention <= 0 {
+		return
+	}
+
+	deletedBefore := time.Now().Add(-app.config.DeletedRetention)
+
+	purged, err := app.ds.GetActiveRepo().Purge(ctx, deletedBefore)
+	if err != nil && ctx.Err() == nil {
+		fmt.Println("failed to purge deleted orders:", err)
+	}
+
+	if purged > 0 {
+		fmt.Printf("purged %d deleted order(s)\n", purged)
+	}
+}
+
+func (app *App) purgeOutbox(ctx context.Context) {
+	if app.config.Outbox.Retention <= 0 {
+		return
+	}
+
+	relay := app.ds.GetOutboxRelay(nil)
+	if relay == nil {
+		return
+	}
+
+	purged, err := relay.Purge(ctx, time.Now().Add(-app.config.Outbox.Retention))
+	if err != nil && ctx.Err() == nil {
+		fmt.Println("failed to purge outbox:", err)
+	}
+
+	if purged > 0 {
+		fmt.Printf("purged %d dispatched outbox event(s)\n", purged)
+	}
+}
+
+func (app *App) purgeIdempotencyKeys(ctx context.Context) {
+	store := app.ds.GetIdempotencyStore()
+	if store == nil {
+		return
+	}
+
+	purged, err := store.Purge(ctx, time.Now())
+	if err != nil && ctx.Err() == nil {
+		fmt.Println("failed to purge idempotency keys:", err)
+	}
+
+	if purged > 0 {
+		fmt.Printf("purged %d idempotency key(s)\n", purged)
+	}
+}
@@ -2,11 +2,15 @@ package application
 
 import (
 	"encoding/json"
+	"first-little-server/order"
 	"fmt"
 	"github.com/joho/godotenv"
 	"log"
+	"net/netip"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 )
 
 type EnvDatabase string
@@ -14,13 +18,77 @@ type EnvDatabase string
 const (
 	PostgresEnv EnvDatabase = "postgres"
 	ReddisEnv   EnvDatabase = "reddis"
+	MemoryEnv   EnvDatabase = "memory"
+	// CachedPostgresEnv stores orders in postgres and caches them in reddis.
+	CachedPostgresEnv EnvDatabase = "postgres+reddis"
 )
 
 type Config struct {
-	Database        EnvDatabase
-	RedisAddress    string
-	PostgresAddress string
-	ServerPort      uint16
+	Database     EnvDatabase
+	RedisAddress string
+	// RedisStream is the stream receiving the order events of the redis database, none when empty.
+	RedisStream       string
+	RedisStreamMaxLen int64
+	PostgresAddress   string
+	ServerPort        uint16
+	// States are the status transitions of the orders.
+	States *order.StateMachine
+	// NodeID tells apart the order ids generated by the servers sharing a database, each needs its own.
+	NodeID       int64
+	PostgresPool PostgresPoolConfig
+	// RequireMigrated refuses to start the server while postgres migrations are pending.
+	RequireMigrated bool
+	CacheOrderTTL   time.Duration
+	CachePageTTL    time.Duration
+	// DeletedRetention is how long soft deleted orders are kept before being purged, zero keeps them forever.
+	DeletedRetention time.Duration
+	PurgeInterval    time.Duration
+	// IdempotencyTTL is how long the Idempotency-Key of an order creation is remembered.
+	IdempotencyTTL time.Duration
+	Outbox         OutboxConfig
+	Events         EventsConfig
+	Webhook        WebhookConfig
+}
+
+// WebhookConfig tunes the delivery worker of the webhooks.
+type WebhookConfig struct {
+	PollInterval time.Duration
+	BatchSize    int
+	// Lease hides a delivery from other workers while it is attempted, it must exceed Timeout.
+	Lease       time.Duration
+	Timeout     time.Duration
+	MinBackoff  time.Duration
+	MaxBackoff  time.Duration
+	MaxAttempts int
+	// AllowedNets are the non public networks the webhooks may call, none by default.
+	AllowedNets []netip.Prefix
+}
+
+// EventsConfig tunes the server-sent event streams of the orders.
+type EventsConfig struct {
+	// BufferSize bounds the events kept for Last-Event-ID resumes.
+	BufferSize int
+	Heartbeat  time.Duration
+}
+
+// OutboxConfig tunes the relay of the postgres outbox.
+type OutboxConfig struct {
+	PollInterval time.Duration
+	BatchSize    uint
+	MinBackoff   time.Duration
+	MaxBackoff   time.Duration
+	// Lease hides the events claimed by a relay from the others while they are published.
+	Lease time.Duration
+	// Retention is how long dispatched events are kept before being purged, zero keeps them forever.
+	Retention time.Duration
+}
+
+// PostgresPoolConfig sizes the postgres connection pool.
+type PostgresPoolConfig struct {
+	MaxConns        int32
+	MinConns        int32
+	MaxConnIdleTime time.Duration
+	MaxConnLifetime time.Duration
 }
 
 func LoadConfig() Config {
@@ -30,10 +98,45 @@ func LoadConfig() Config {
 	}
 
 	conf := Config{
-		Database:        ReddisEnv,
-		RedisAddress:    "localhost:6379",
-		PostgresAddress: "localhost:5432",
-		ServerPort:      3000,
+		Database:          ReddisEnv,
+		RedisAddress:      "localhost:6379",
+		RedisStream:       "orders:events",
+		RedisStreamMaxLen: 100000,
+		PostgresAddress:   "localhost:5432",
+		ServerPort:        3000,
+		States:            order.DefaultStateMachine(),
+		PostgresPool: PostgresPoolConfig{
+			MaxConns:        10,
+			MinConns:        0,
+			MaxConnIdleTime: 30 * time.Minute,
+			MaxConnLifetime: time.Hour,
+		},
+		CacheOrderTTL:    10 * time.Minute,
+		CachePageTTL:     30 * time.Second,
+		DeletedRetention: 30 * 24 * time.Hour,
+		PurgeInterval:    time.Hour,
+		IdempotencyTTL:   24 * time.Hour,
+		Outbox: OutboxConfig{
+			PollInterval: time.Second,
+			BatchSize:    100,
+			MinBackoff:   time.Second,
+			MaxBackoff:   5 * time.Minute,
+			Lease:        time.Minute,
+			Retention:    7 * 24 * time.Hour,
+		},
+		Events: EventsConfig{
+			BufferSize: 1000,
+			Heartbeat:  15 * time.Second,
+		},
+		Webhook: WebhookConfig{
+			PollInterval: time.Second,
+			BatchSize:    50,
+			Lease:        time.Minute,
+			Timeout:      10 * time.Second,
+			MinBackoff:   5 * time.Second,
+			MaxBackoff:   time.Hour,
+			MaxAttempts:  10,
+		},
 	}
 
 	if databaseEnv, exist := os.LookupEnv("GOSERVER_DATABASE"); exist {
@@ -44,7 +147,69 @@ func LoadConfig() Config {
 		conf.RedisAddress = redisAddr
 	}
 
+	if redisStream, exist := os.LookupEnv("GOSERVER_REDDIS_STREAM"); exist {
+		conf.RedisStream = redisStream
+	}
+
+	if streamMaxLen, exist := os.LookupEnv("GOSERVER_REDDIS_STREAM_MAX_LEN"); exist {
+		if streamMaxLen, err := strconv.ParseInt(streamMaxLen, 10, 64); err == nil && streamMaxLen >= 0 {
+			conf.RedisStreamMaxLen = streamMaxLen
+		}
+	}
+
 	setPostgresAddressFromEnvVariables(&conf)
+	setStatesFromEnvVariables(&conf)
+	setPostgresPoolFromEnvVariables(&conf)
+	setOutboxFromEnvVariables(&conf)
+	setWebhookFromEnvVariables(&conf)
+
+	if bufferSize, exist := os.LookupEnv("GOSERVER_EVENTS_BUFFER_SIZE"); exist {
+		if bufferSize, err := strconv.Atoi(bufferSize); err == nil && bufferSize > 0 {
+			conf.Events.BufferSize = bufferSize
+		}
+	}
+
+	if heartbeat, exist := os.LookupEnv("GOSERVER_EVENTS_HEARTBEAT"); exist {
+		if heartbeat, err := time.ParseDuration(heartbeat); err == nil && heartbeat > 0 {
+			conf.Events.Heartbeat = heartbeat
+		}
+	}
+
+	if requireMigrated, exist := os.LookupEnv("GOSERVER_REQUIRE_MIGRATED"); exist {
+		if requireMigrated, err := strconv.ParseBool(requireMigrated); err == nil {
+			conf.RequireMigrated = requireMigrated
+		}
+	}
+
+	if orderTTL, exist := os.LookupEnv("GOSERVER_CACHE_ORDER_TTL"); exist {
+		if orderTTL, err := time.ParseDuration(orderTTL); err == nil {
+			conf.CacheOrderTTL = orderTTL
+		}
+	}
+
+	if pageTTL, exist := os.LookupEnv("GOSERVER_CACHE_PAGE_TTL"); exist {
+		if pageTTL, err := time.ParseDuration(pageTTL); err == nil {
+			conf.CachePageTTL = pageTTL
+		}
+	}
+
+	if retention, exist := os.LookupEnv("GOSERVER_DELETED_RETENTION"); exist {
+		if retention, err := time.ParseDuration(retention); err == nil {
+			conf.DeletedRetention = retention
+		}
+	}
+
+	if purgeInterval, exist := os.LookupEnv("GOSERVER_PURGE_INTERVAL"); exist {
+		if purgeInterval, err := time.ParseDuration(purgeInterval); err == nil && purgeInterval > 0 {
+			conf.PurgeInterval = purgeInterval
+		}
+	}
+
+	if idempotencyTTL, exist := os.LookupEnv("GOSERVER_IDEMPOTENCY_TTL"); exist {
+		if idempotencyTTL, err := time.ParseDuration(idempotencyTTL); err == nil && idempotencyTTL > 0 {
+			conf.IdempotencyTTL = idempotencyTTL
+		}
+	}
 
 	if serverPort, exist := os.LookupEnv("GOSERVER_SERVER_PORT"); exist {
 		if serverPort, err := strconv.ParseInt(serverPort, 10, 16); err == nil {
@@ -52,6 +217,14 @@ func LoadConfig() Config {
 		}
 	}
 
+	if nodeID, exist := os.LookupEnv("GOSERVER_NODE_ID"); exist {
+		if nodeID, err := strconv.ParseInt(nodeID, 10, 64); err == nil && nodeID >= 0 && nodeID <= order.MaxNodeID {
+			conf.NodeID = nodeID
+		} else {
+			log.Fatalf("GOSERVER_NODE_ID must be between 0 and %d", order.MaxNodeID)
+		}
+	}
+
 	return conf
 }
 
@@ -90,3 +263,139 @@ func setPostgresAddressFromEnvVariables(conf *Config) {
 		"/" + postgresCredentials.DatabaseName +
 		"?user=" + postgresCredentials.Username + "&password=" + postgresCredentials.Password
 }
+
+// setStatesFromEnvVariables loads the transition table of the orders from the JSON file named by
+// GOSERVER_ORDER_STATES. The server refuses to start with an invalid table.
+func setStatesFromEnvVariables(conf *Config) {
+	statesFileName, exist := os.LookupEnv("GOSERVER_ORDER_STATES")
+	if !exist || statesFileName == "" {
+		return
+	}
+
+	file, err := os.ReadFile(statesFileName)
+	if err != nil {
+		log.Fatalf("error reading order states file: %v", err)
+	}
+
+	var states order.StateMachine
+	if err := json.Unmarshal(file, &states); err != nil {
+		log.Fatalf("error unmarshalling order states: %v", err)
+	}
+
+	if err := states.Validate(); err != nil {
+		log.Fatalf("invalid order states: %v", err)
+	}
+
+	conf.States = &states
+}
+
+func setPostgresPoolFromEnvVariables(conf *Config) {
+	const decimal = 10
+	const bitSize = 32
+
+	if maxConns, exist := os.LookupEnv("GOSERVER_POSTGRES_MAX_CONNS"); exist {
+		if maxConns, err := strconv.ParseInt(maxConns, decimal, bitSize); err == nil {
+			conf.PostgresPool.MaxConns = int32(maxConns)
+		}
+	}
+
+	if minConns, exist := os.LookupEnv("GOSERVER_POSTGRES_MIN_CONNS"); exist {
+		if minConns, err := strconv.ParseInt(minConns, decimal, bitSize); err == nil {
+			conf.PostgresPool.MinConns = int32(minConns)
+		}
+	}
+
+	if idleTime, exist := os.LookupEnv("GOSERVER_POSTGRES_MAX_CONN_IDLE_TIME"); exist {
+		if idleTime, err := time.ParseDuration(idleTime); err == nil {
+			conf.PostgresPool.MaxConnIdleTime = idleTime
+		}
+	}
+
+	if lifetime, exist := os.LookupEnv("GOSERVER_POSTGRES_MAX_CONN_LIFETIME"); exist {
+		if lifetime, err := time.ParseDuration(lifetime); err == nil {
+			conf.PostgresPool.MaxConnLifetime = lifetime
+		}
+	}
+}
+
+func setOutboxFromEnvVariables(conf *Config) {
+	if pollInterval, exist := os.LookupEnv("GOSERVER_OUTBOX_POLL_INTERVAL"); exist {
+		if pollInterval, err := time.ParseDuration(pollInterval); err == nil && pollInterval > 0 {
+			conf.Outbox.PollInterval = pollInterval
+		}
+	}
+
+	if batchSize, exist := os.LookupEnv("GOSERVER_OUTBOX_BATCH_SIZE"); exist {
+		if batchSize, err := strconv.ParseUint(batchSize, 10, 32); err == nil && batchSize > 0 {
+			conf.Outbox.BatchSize = uint(batchSize)
+		}
+	}
+
+	if minBackoff, exist := os.LookupEnv("GOSERVER_OUTBOX_MIN_BACKOFF"); exist {
+		if minBackoff, err := time.ParseDuration(minBackoff); err == nil && minBackoff > 0 {
+			conf.Outbox.MinBackoff = minBackoff
+		}
+	}
+
+	if maxBackoff, exist := os.LookupEnv("GOSERVER_OUTBOX_MAX_BACKOFF"); exist {
+		if maxBackoff, err := time.ParseDuration(maxBackoff); err == nil && maxBackoff > 0 {
+			conf.Outbox.MaxBackoff = maxBackoff
+		}
+	}
+
+	if lease, exist := os.LookupEnv("GOSERVER_OUTBOX_LEASE"); exist {
+		if lease, err := time.ParseDuration(lease); err == nil && lease > 0 {
+			conf.Outbox.Lease = lease
+		}
+	}
+
+	if retention, exist := os.LookupEnv("GOSERVER_OUTBOX_RETENTION"); exist {
+		if retention, err := time.ParseDuration(retention); err == nil && retention >= 0 {
+			conf.Outbox.Retention = retention
+		}
+	}
+}
+
+func setWebhookFromEnvVariables(conf *Config) {
+	durations := []struct {
+		name  string
+		field *time.Duration
+	}{
+		{"GOSERVER_WEBHOOK_POLL_INTERVAL", &conf.Webhook.PollInterval},
+		{"GOSERVER_WEBHOOK_LEASE", &conf.Webhook.Lease},
+		{"GOSERVER_WEBHOOK_TIMEOUT", &conf.Webhook.Timeout},
+		{"GOSERVER_WEBHOOK_MIN_BACKOFF", &conf.Webhook.MinBackoff},
+		{"GOSERVER_WEBHOOK_MAX_BACKOFF", &conf.Webhook.MaxBackoff},
+	}
+
+	for _, duration := range durations {
+		if value, exist := os.LookupEnv(duration.name); exist {
+			if value, err := time.ParseDuration(value); err == nil && value > 0 {
+				*duration.field = value
+			}
+		}
+	}
+
+	if batchSize, exist := os.LookupEnv("GOSERVER_WEBHOOK_BATCH_SIZE"); exist {
+		if batchSize, err := strconv.Atoi(batchSize); err == nil && batchSize > 0 {
+			conf.Webhook.BatchSize = batchSize
+		}
+	}
+
+	if maxAttempts, exist := os.LookupEnv("GOSERVER_WEBHOOK_MAX_ATTEMPTS"); exist {
+		if maxAttempts, err := strconv.Atoi(maxAttempts); err == nil && maxAttempts > 0 {
+			conf.Webhook.MaxAttempts = maxAttempts
+		}
+	}
+
+	// A comma separated list of CIDR prefixes, the server refuses to start with an invalid one.
+	if allowedNets, exist := os.LookupEnv("GOSERVER_WEBHOOK_ALLOWED_NETS"); exist && allowedNets != "" {
+		for _, allowedNet := range strings.Split(allowedNets, ",") {
+			prefix, err := netip.ParsePrefix(strings.TrimSpace(allowedNet))
+			if err != nil {
+				log.Fatalf("GOSERVER_WEBHOOK_ALLOWED_NETS: %v", err)
+			}
+			conf.Webhook.AllowedNets = append(conf.Webhook.AllowedNets, prefix)
+		}
+	}
+}
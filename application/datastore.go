@@ -2,17 +2,32 @@ package application
 
 import (
 	"context"
+	"first-little-server/catalog"
+	"first-little-server/customer"
+	"first-little-server/migration"
 	"first-little-server/order"
+	"first-little-server/webhook"
 	"fmt"
-	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/redis/go-redis/v9"
 	"log"
+	"time"
 )
 
 type Datastore struct {
-	rdb    *redis.Client
-	pgb    *pgx.Conn
-	config Config
+	rdb   *redis.Client
+	pgb   *pgxpool.Pool
+	mem   *order.MemoryRepo
+	cache *order.CachedRepo
+	// webhooks keeps the webhooks of the memory database.
+	webhooks *webhook.MemoryRepo
+	// idempotency keeps the idempotency keys of the memory database.
+	idempotency *order.MemoryIdempotencyStore
+	// customers keeps the customers of the memory database.
+	customers *customer.MemoryRepo
+	// catalogItems keeps the catalog of the memory database.
+	catalogItems *catalog.MemoryRepo
+	config       Config
 }
 
 func NewDatastore(ctx context.Context, config Config) *Datastore {
@@ -28,8 +43,9 @@ func NewDatastore(ctx context.Context, config Config) *Datastore {
 func (ds *Datastore) setInnerDatabase(ctx context.Context) {
 	switch ds.config.Database {
 	case PostgresEnv:
-		postgres, err := pgx.Connect(ctx, ds.config.PostgresAddress)
+		postgres, err := newPostgresPool(ctx, ds.config)
 		if err != nil {
+			fmt.Println("failed to create postgres pool:", err)
 			postgres = nil
 		}
 		ds.pgb = postgres
@@ -39,20 +55,59 @@ func (ds *Datastore) setInnerDatabase(ctx context.Context) {
 			Addr: ds.config.RedisAddress,
 		})
 		ds.pgb = nil
+	case MemoryEnv:
+		ds.mem = &order.MemoryRepo{States: ds.config.States}
+		ds.webhooks = &webhook.MemoryRepo{}
+		ds.idempotency = &order.MemoryIdempotencyStore{}
+		ds.customers = &customer.MemoryRepo{}
+		ds.catalogItems = &catalog.MemoryRepo{}
+	case CachedPostgresEnv:
+		postgres, err := newPostgresPool(ctx, ds.config)
+		if err != nil {
+			fmt.Println("failed to create postgres pool:", err)
+			return
+		}
+		ds.pgb = postgres
+		ds.rdb = redis.NewClient(&redis.Options{
+			Addr: ds.config.RedisAddress,
+		})
+		ds.cache = &order.CachedRepo{
+			Repo:     &order.PostgresRepo{Client: ds.pgb, States: ds.config.States},
+			Client:   ds.rdb,
+			OrderTTL: ds.config.CacheOrderTTL,
+			PageTTL:  ds.config.CachePageTTL,
+		}
 	default:
 		log.Fatalf("database %s is not supported", ds.config.Database)
 	}
 }
 
-// Ping the inner database to verify connexion.
+func newPostgresPool(ctx context.Context, config Config) (*pgxpool.Pool, error) {
+	poolConfig, err := pgxpool.ParseConfig(config.PostgresAddress)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse postgres address: %w", err)
+	}
+
+	poolConfig.MaxConns = config.PostgresPool.MaxConns
+	poolConfig.MinConns = config.PostgresPool.MinConns
+	poolConfig.MaxConnIdleTime = config.PostgresPool.MaxConnIdleTime
+	poolConfig.MaxConnLifetime = config.PostgresPool.MaxConnLifetime
+
+	return pgxpool.NewWithConfig(ctx, poolConfig)
+}
+
+// Ping the inner databases to verify connexion.
 func (ds *Datastore) Ping(ctx context.Context) error {
+	if ds.pgb == nil && ds.rdb == nil && ds.mem == nil {
+		return fmt.Errorf("database %s not supported", ds.config.Database)
+	}
+
 	if ds.pgb != nil {
 		err := ds.pgb.Ping(ctx)
 
 		if err != nil {
 			return fmt.Errorf("error when pinging postgres: %w", err)
 		}
-		return nil
 	}
 
 	if ds.rdb != nil {
@@ -61,34 +116,187 @@ func (ds *Datastore) Ping(ctx context.Context) error {
 		if err != nil {
 			return fmt.Errorf("error when pinging reddis: %w", err)
 		}
-		return nil
 	}
 
-	return fmt.Errorf("database %s not supported", ds.config.Database)
+	// The in-memory repository lives in the process, it is always reachable.
+	return nil
 }
 
-// Close the inner database.
+// Close the inner databases.
 func (ds *Datastore) Close(ctx context.Context) error {
+	if ds.pgb == nil && ds.rdb == nil && ds.mem == nil {
+		return fmt.Errorf("database %s not supported", ds.config.Database)
+	}
+
 	if ds.pgb != nil {
-		if err := ds.pgb.Close(ctx); err != nil {
-			return fmt.Errorf("failed to close postgres: %w", err)
-		}
-		return nil
+		// Close waits for every acquired connection to be released.
+		ds.pgb.Close()
 	}
 
 	if ds.rdb != nil {
 		if err := ds.rdb.Close(); err != nil {
 			return fmt.Errorf("failed to close reddis: %w", err)
 		}
-		return nil
 	}
 
-	return fmt.Errorf("database %s not supported", ds.config.Database)
+	return nil
 }
 
 // GetActiveRepo returns the current active repository
 // If no current repository is active, returns null.
 func (ds *Datastore) GetActiveRepo() order.Repository {
+	if ds.cache != nil {
+		return ds.cache
+	}
+
+	if ds.pgb != nil {
+		return &order.PostgresRepo{
+			Client: ds.pgb,
+			States: ds.config.States,
+		}
+	}
+
+	if ds.rdb != nil {
+		return &order.RedisRepo{
+			Client:       ds.rdb,
+			Stream:       ds.config.RedisStream,
+			StreamMaxLen: ds.config.RedisStreamMaxLen,
+			States:       ds.config.States,
+		}
+	}
+
+	if ds.mem != nil {
+		return ds.mem
+	}
+
+	return nil
+}
+
+// GetMigrator returns the schema migrator of the postgres database.
+// If postgres is not the active database, returns null.
+func (ds *Datastore) GetMigrator() *migration.Migrator {
+	if ds.pgb != nil {
+		return &migration.Migrator{
+			Client: ds.pgb,
+		}
+	}
+
+	return nil
+}
+
+// GetOutboxRelay returns the relay dispatching the postgres outbox to sink.
+// If postgres is not the active database, returns null.
+func (ds *Datastore) GetOutboxRelay(sink order.EventSink) *order.OutboxRelay {
+	if ds.pgb != nil {
+		return &order.OutboxRelay{
+			Client:     ds.pgb,
+			Sink:       sink,
+			BatchSize:  ds.config.Outbox.BatchSize,
+			MinBackoff: ds.config.Outbox.MinBackoff,
+			MaxBackoff: ds.config.Outbox.MaxBackoff,
+			Lease:      ds.config.Outbox.Lease,
+		}
+	}
+
+	return nil
+}
+
+// GetIdempotencyStore returns the store of the idempotency keys of the active database.
+// If no current repository is active, returns null.
+func (ds *Datastore) GetIdempotencyStore() order.IdempotencyStore {
+	if ds.pgb != nil {
+		return &order.PostgresIdempotencyStore{
+			Client: ds.pgb,
+		}
+	}
+
+	if ds.rdb != nil {
+		return &order.RedisIdempotencyStore{
+			Client: ds.rdb,
+		}
+	}
+
+	if ds.idempotency != nil {
+		return ds.idempotency
+	}
+
+	return nil
+}
+
+// GetWebhookRepo returns the webhook repository of the active database.
+// If no current repository is active, returns null.
+func (ds *Datastore) GetWebhookRepo() webhook.Repository {
+	if ds.pgb != nil {
+		return &webhook.PostgresRepo{
+			Client: ds.pgb,
+		}
+	}
+
+	if ds.rdb != nil {
+		return &webhook.RedisRepo{
+			Client: ds.rdb,
+		}
+	}
+
+	if ds.webhooks != nil {
+		return ds.webhooks
+	}
+
+	return nil
+}
+
+// GetCustomerRepo returns the customer repository of the active database.
+// If no current repository is active, returns null.
+func (ds *Datastore) GetCustomerRepo() customer.Repository {
+	if ds.pgb != nil {
+		return &customer.PostgresRepo{
+			Client: ds.pgb,
+		}
+	}
+
+	if ds.rdb != nil {
+		return &customer.RedisRepo{
+			Client: ds.rdb,
+		}
+	}
+
+	if ds.customers != nil {
+		return ds.customers
+	}
+
+	return nil
+}
+
+// GetCatalogRepo returns the catalog repository of the active database.
+// If no current repository is active, returns null.
+func (ds *Datastore) GetCatalogRepo() catalog.Repository {
+	if ds.pgb != nil {
+		return &catalog.PostgresRepo{
+			Client: ds.pgb,
+		}
+	}
+
+	if ds.rdb != nil {
+		return &catalog.RedisRepo{
+			Client: ds.rdb,
+		}
+	}
+
+	if ds.catalogItems != nil {
+		return ds.catalogItems
+	}
+
+	return nil
+}
+
+// GetInventory returns the inventory of the active database, which its order repository reserves from.
+// The cache serves it from the repository it wraps, as GetActiveRepo does the orders.
+// If no current repository is active, returns null.
+func (ds *Datastore) GetInventory() order.Inventory {
+	if ds.cache != nil {
+		return ds.cache
+	}
+
 	if ds.pgb != nil {
 		return &order.PostgresRepo{
 			Client: ds.pgb,
@@ -101,5 +309,60 @@ func (ds *Datastore) GetActiveRepo() order.Repository {
 		}
 	}
 
+	if ds.mem != nil {
+		return ds.mem
+	}
+
 	return nil
 }
+
+// PostgresPoolStats describes the current state of the postgres connection pool.
+type PostgresPoolStats struct {
+	MaxConns             int32         `json:"max_conns"`
+	TotalConns           int32         `json:"total_conns"`
+	AcquiredConns        int32         `json:"acquired_conns"`
+	IdleConns            int32         `json:"idle_conns"`
+	ConstructingConns    int32         `json:"constructing_conns"`
+	AcquireCount         int64         `json:"acquire_count"`
+	AcquireDuration      time.Duration `json:"acquire_duration_ns"`
+	EmptyAcquireCount    int64         `json:"empty_acquire_count"`
+	CanceledAcquireCount int64         `json:"canceled_acquire_count"`
+	NewConnsCount        int64         `json:"new_conns_count"`
+	MaxLifetimeDestroyed int64         `json:"max_lifetime_destroy_count"`
+	MaxIdleDestroyed     int64         `json:"max_idle_destroy_count"`
+}
+
+// PostgresPoolStats returns the statistics of the postgres connection pool.
+// The boolean is false when postgres is not the active database.
+func (ds *Datastore) PostgresPoolStats() (PostgresPoolStats, bool) {
+	if ds.pgb == nil {
+		return PostgresPoolStats{}, false
+	}
+
+	stat := ds.pgb.Stat()
+
+	return PostgresPoolStats{
+		MaxConns:             stat.MaxConns(),
+		TotalConns:           stat.TotalConns(),
+		AcquiredConns:        stat.AcquiredConns(),
+		IdleConns:            stat.IdleConns(),
+		ConstructingConns:    stat.ConstructingConns(),
+		AcquireCount:         stat.AcquireCount(),
+		AcquireDuration:      stat.AcquireDuration(),
+		EmptyAcquireCount:    stat.EmptyAcquireCount(),
+		CanceledAcquireCount: stat.CanceledAcquireCount(),
+		NewConnsCount:        stat.NewConnsCount(),
+		MaxLifetimeDestroyed: stat.MaxLifetimeDestroyCount(),
+		MaxIdleDestroyed:     stat.MaxIdleDestroyCount(),
+	}, true
+}
+
+// CacheStats returns the hit and miss counters of the order cache.
+// The boolean is false when the cache is not enabled.
+func (ds *Datastore) CacheStats() (order.CacheStats, bool) {
+	if ds.cache == nil {
+		return order.CacheStats{}, false
+	}
+
+	return ds.cache.Stats(), true
+}
@@ -1,7 +1,12 @@
 package application
 
 import (
+	"encoding/json"
+	"first-little-server/catalog"
+	"first-little-server/customer"
 	"first-little-server/order"
+	"first-little-server/webhook"
+	"fmt"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"net/http"
@@ -16,19 +21,125 @@ func (app *App) LoadRoutes() {
 		w.WriteHeader(http.StatusOK)
 	})
 
+	router.Get("/stats/postgres", app.postgresPoolStats)
+	router.Get("/stats/cache", app.cacheStats)
+
 	router.Route("/orders", app.LoadOrderRoutes)
+	router.Route("/webhooks", app.LoadWebhookRoutes)
+	router.Route("/customers", app.LoadCustomerRoutes)
+	router.Route("/catalog", app.LoadCatalogRoutes)
+	router.Route("/inventory", app.LoadInventoryRoutes)
 
 	app.router = router
 }
 
 func (app *App) LoadOrderRoutes(router chi.Router) {
 	orderHandler := &order.Handler{
-		Repo: app.ds.GetActiveRepo(),
+		Repo:           app.ds.GetActiveRepo(),
+		IDs:            app.ids,
+		States:         app.config.States,
+		Hub:            app.hub,
+		Heartbeat:      app.config.Events.Heartbeat,
+		Idempotency:    app.ds.GetIdempotencyStore(),
+		IdempotencyTTL: app.config.IdempotencyTTL,
+		Customers:      app.ds.GetCustomerRepo(),
+		Catalog:        catalog.Prices{Repo: app.ds.GetCatalogRepo()},
 	}
 
+	router.Use(order.ActorMiddleware)
+
 	router.Post("/", orderHandler.Create)
 	router.Get("/", orderHandler.List)
+	router.Get("/events", orderHandler.Events)
 	router.Get("/{id}", orderHandler.GetByID)
 	router.Put("/{id}", orderHandler.UpdateByID)
+	router.Post("/{id}/cancel", orderHandler.CancelByID)
+	router.Post("/{id}/items", orderHandler.AddLineItem)
+	router.Patch("/{id}/items/{itemID}", orderHandler.UpdateLineItem)
+	router.Delete("/{id}/items/{itemID}", orderHandler.RemoveLineItem)
 	router.Delete("/{id}", orderHandler.DeleteByID)
+	router.Post("/{id}/restore", orderHandler.RestoreByID)
+	router.Get("/{id}/history", orderHandler.History)
+	router.Get("/{id}/events", orderHandler.OrderEvents)
+}
+
+func (app *App) LoadWebhookRoutes(router chi.Router) {
+	webhookHandler := &webhook.Handler{
+		Repo:      app.ds.GetWebhookRepo(),
+		Addresses: webhook.AddressPolicy{Allowed: app.config.Webhook.AllowedNets},
+	}
+
+	router.Post("/", webhookHandler.Create)
+	router.Get("/", webhookHandler.List)
+	router.Get("/{id}", webhookHandler.GetByID)
+	router.Delete("/{id}", webhookHandler.DeleteByID)
+	router.Get("/{id}/deliveries", webhookHandler.Deliveries)
+	router.Get("/{id}/deliveries/{deliveryID}/attempts", webhookHandler.Attempts)
+}
+
+func (app *App) LoadCustomerRoutes(router chi.Router) {
+	customerHandler := &customer.Handler{
+		Repo:   app.ds.GetCustomerRepo(),
+		Orders: app.ds.GetActiveRepo(),
+	}
+
+	router.Post("/", customerHandler.Create)
+	router.Get("/", customerHandler.List)
+	router.Get("/{id}", customerHandler.GetByID)
+	router.Put("/{id}", customerHandler.UpdateByID)
+	router.Delete("/{id}", customerHandler.DeleteByID)
+	router.Get("/{id}/orders", customerHandler.ListOrders)
+}
+
+// LoadCatalogRoutes serves the administration of the catalog the orders are priced from.
+func (app *App) LoadCatalogRoutes(router chi.Router) {
+	catalogHandler := &catalog.Handler{
+		Repo: app.ds.GetCatalogRepo(),
+	}
+
+	router.Post("/", catalogHandler.Create)
+	router.Get("/", catalogHandler.List)
+	router.Get("/{id}", catalogHandler.GetByID)
+	router.Put("/{id}", catalogHandler.UpdateByID)
+	router.Delete("/{id}", catalogHandler.DeleteByID)
+}
+
+// LoadInventoryRoutes serves the stock the orders reserve their line items from.
+func (app *App) LoadInventoryRoutes(router chi.Router) {
+	inventoryHandler := &order.InventoryHandler{
+		Inventory: app.ds.GetInventory(),
+	}
+
+	router.Get("/{itemID}", inventoryHandler.GetByID)
+	router.Put("/{itemID}", inventoryHandler.UpdateByID)
+}
+
+// postgresPoolStats exposes the connection pool statistics used to size the pool.
+func (app *App) postgresPoolStats(w http.ResponseWriter, r *http.Request) {
+	stats, ok := app.ds.PostgresPoolStats()
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(stats); err != nil {
+		fmt.Println("failed to marshal:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// cacheStats exposes the hit and miss counters of the order cache.
+func (app *App) cacheStats(w http.ResponseWriter, r *http.Request) {
+	stats, ok := app.ds.CacheStats()
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(stats); err != nil {
+		fmt.Println("failed to marshal:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
 }
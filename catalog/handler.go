@@ -0,0 +1,239 @@
+package catalog
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"first-little-server/order"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+type Repository interface {
+	// Insert returns ErrAlreadyExists when an item has the same id.
+	Insert(ctx context.Context, item Item) error
+	// FindByID returns ErrNotExist when no item has the id.
+	FindByID(ctx context.Context, id uuid.UUID) (Item, error)
+	// FindByIDs returns the items with the given ids, active or not, leaving out the unknown ids.
+	FindByIDs(ctx context.Context, ids []uuid.UUID) ([]Item, error)
+	FindAll(ctx context.Context, page FindAllPage) (FindResult, error)
+	// Update replaces the item, it returns ErrNotExist when no item has the id.
+	Update(ctx context.Context, item Item) error
+	// DeleteByID returns ErrNotExist when no item has the id. The orders keep the price they were created with.
+	DeleteByID(ctx context.Context, id uuid.UUID) error
+}
+
+// Handler serves the administration of the catalog.
+type Handler struct {
+	Repo Repository
+}
+
+const pageSize = 50
+
+type itemBody struct {
+	Name  string      `json:"name"`
+	Price order.Money `json:"price"`
+	// Active defaults to true, items are deactivated rather than deleted to stop selling them.
+	Active *bool `json:"active"`
+}
+
+func (body itemBody) validate() error {
+	if body.Name == "" {
+		return errors.New("missing name")
+	}
+
+	if !order.ValidCurrency(body.Price.Currency) {
+		return fmt.Errorf("invalid currency %q", body.Price.Currency)
+	}
+
+	if body.Price.Amount < 0 {
+		return errors.New("negative price")
+	}
+
+	return nil
+}
+
+func (body itemBody) active() bool {
+	return body.Active == nil || *body.Active
+}
+
+func (h *Handler) Create(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		// ID is generated unless the item already appears in orders under its own id.
+		ID uuid.UUID `json:"id"`
+		itemBody
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if err := body.validate(); err != nil {
+		fmt.Println("invalid catalog item:", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if body.ID == uuid.Nil {
+		body.ID = uuid.New()
+	}
+
+	now := time.Now().UTC()
+	item := Item{
+		ID:        body.ID,
+		Name:      body.Name,
+		Price:     body.Price,
+		Active:    body.active(),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	err := h.Repo.Insert(r.Context(), item)
+	if errors.Is(err, ErrAlreadyExists) {
+		w.WriteHeader(http.StatusConflict)
+		return
+	} else if err != nil {
+		fmt.Println("failed to insert catalog item:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	res, err := json.Marshal(item)
+	if err != nil {
+		fmt.Println("failed to marshal:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	_, _ = w.Write(res)
+}
+
+func (h *Handler) List(w http.ResponseWriter, r *http.Request) {
+	page := FindAllPage{Size: pageSize, Cursor: r.URL.Query().Get("cursor")}
+
+	res, err := h.Repo.FindAll(r.Context(), page)
+	if errors.Is(err, ErrInvalidCursor) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	} else if err != nil {
+		fmt.Println("failed to find catalog items:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	var response struct {
+		Items []Item `json:"items"`
+		Next  string `json:"next,omitempty"`
+	}
+	response.Items = res.Items
+	response.Next = res.Cursor
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		fmt.Println("failed to marshal:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *Handler) GetByID(w http.ResponseWriter, r *http.Request) {
+	itemID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	item, err := h.Repo.FindByID(r.Context(), itemID)
+	if errors.Is(err, ErrNotExist) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		fmt.Println("failed to find catalog item:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(item); err != nil {
+		fmt.Println("failed to marshal:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// UpdateByID replaces the name, price and active flag of an item, the existing orders keep their prices.
+func (h *Handler) UpdateByID(w http.ResponseWriter, r *http.Request) {
+	itemID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var body itemBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if err := body.validate(); err != nil {
+		fmt.Println("invalid catalog item:", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	item, err := h.Repo.FindByID(r.Context(), itemID)
+	if errors.Is(err, ErrNotExist) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		fmt.Println("failed to find catalog item:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	item.Name = body.Name
+	item.Price = body.Price
+	item.Active = body.active()
+	item.UpdatedAt = time.Now().UTC()
+
+	err = h.Repo.Update(r.Context(), item)
+	if errors.Is(err, ErrNotExist) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		fmt.Println("failed to update catalog item:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(item); err != nil {
+		fmt.Println("failed to marshal:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *Handler) DeleteByID(w http.ResponseWriter, r *http.Request) {
+	itemID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	err = h.Repo.DeleteByID(r.Context(), itemID)
+	if errors.Is(err, ErrNotExist) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		fmt.Println("failed to delete catalog item:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
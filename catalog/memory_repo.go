@@ -0,0 +1,115 @@
+package catalog
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"first-little-server/paging"
+
+	"github.com/google/uuid"
+)
+
+// MemoryRepo keeps the catalog in process memory, along with the memory order database.
+// The zero value is ready to use.
+type MemoryRepo struct {
+	mu    sync.RWMutex
+	items map[uuid.UUID]Item
+}
+
+func (repo *MemoryRepo) Insert(_ context.Context, item Item) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if repo.items == nil {
+		repo.items = make(map[uuid.UUID]Item)
+	}
+
+	if _, exist := repo.items[item.ID]; exist {
+		return fmt.Errorf("catalog item %s: %w", item.ID, ErrAlreadyExists)
+	}
+
+	repo.items[item.ID] = item
+
+	return nil
+}
+
+func (repo *MemoryRepo) FindByID(_ context.Context, id uuid.UUID) (Item, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	item, exist := repo.items[id]
+	if !exist {
+		return Item{}, ErrNotExist
+	}
+
+	return item, nil
+}
+
+func (repo *MemoryRepo) FindByIDs(_ context.Context, ids []uuid.UUID) ([]Item, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	items := make([]Item, 0, len(ids))
+	for _, id := range ids {
+		if item, exist := repo.items[id]; exist {
+			items = append(items, item)
+		}
+	}
+
+	return items, nil
+}
+
+func (repo *MemoryRepo) FindAll(_ context.Context, page FindAllPage) (FindResult, error) {
+	after, err := paging.ParseCursor(page.Cursor)
+	if err != nil {
+		return FindResult{}, err
+	}
+
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	items := make([]Item, 0, len(repo.items))
+	for id, item := range repo.items {
+		if after == uuid.Nil || id.String() > after.String() {
+			items = append(items, item)
+		}
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].ID.String() < items[j].ID.String()
+	})
+
+	if uint(len(items)) > page.Size+1 {
+		items = items[:page.Size+1]
+	}
+
+	return pageOf(items, page.Size), nil
+}
+
+func (repo *MemoryRepo) Update(_ context.Context, item Item) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if _, exist := repo.items[item.ID]; !exist {
+		return ErrNotExist
+	}
+
+	repo.items[item.ID] = item
+
+	return nil
+}
+
+func (repo *MemoryRepo) DeleteByID(_ context.Context, id uuid.UUID) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if _, exist := repo.items[id]; !exist {
+		return ErrNotExist
+	}
+
+	delete(repo.items, id)
+
+	return nil
+}
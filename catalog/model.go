@@ -0,0 +1,48 @@
+package catalog
+
+import (
+	"errors"
+	"time"
+
+	"first-little-server/order"
+	"first-little-server/paging"
+
+	"github.com/google/uuid"
+)
+
+// Item is a product of the catalog, keyed by the ItemID of the order line items.
+// Orders snapshot the price of the item when they are created, and only active items may be ordered.
+type Item struct {
+	ID        uuid.UUID   `json:"id"`
+	Name      string      `json:"name"`
+	Price     order.Money `json:"price"`
+	Active    bool        `json:"active"`
+	CreatedAt time.Time   `json:"created_at"`
+	UpdatedAt time.Time   `json:"updated_at"`
+}
+
+var ErrNotExist = errors.New("catalog item does not exist")
+
+var ErrAlreadyExists = errors.New("catalog item already exists")
+
+var ErrInvalidCursor = paging.ErrInvalidCursor
+
+// FindAllPage requests a page of items sorted by id.
+// Cursor is the value returned by the previous page, it is empty for the first page.
+type FindAllPage struct {
+	Size   uint
+	Cursor string
+}
+
+// FindResult is a page of items, Cursor is empty on the last page.
+type FindResult struct {
+	Items  []Item
+	Cursor string
+}
+
+// pageOf trims items, fetched with one more item than the page size, to the page
+// and returns the cursor of the next page.
+func pageOf(items []Item, size uint) FindResult {
+	items, cursor := paging.Trim(items, size, func(record Item) uuid.UUID { return record.ID })
+	return FindResult{Items: items, Cursor: cursor}
+}
@@ -0,0 +1,185 @@
+package catalog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"first-little-server/paging"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// PostgresRepo keeps the catalog in the postgres order database.
+type PostgresRepo struct {
+	Client *pgxpool.Pool
+}
+
+const (
+	itemTable = "catalog_item"
+
+	itemIdRow    = "item_id"
+	nameRow      = "name"
+	priceRow     = "price"
+	currencyRow  = "currency"
+	activeRow    = "active"
+	createdAtRow = "created_at"
+	updatedAtRow = "updated_at"
+)
+
+// uniqueViolationCode is the postgres error code of a duplicate key.
+const uniqueViolationCode = "23505"
+
+const insertItemSQL = "INSERT INTO " + itemTable +
+	" (" + itemIdRow + ", " + nameRow + ", " + priceRow + ", " + currencyRow + ", " + activeRow + ", " +
+	createdAtRow + ", " + updatedAtRow + ")" +
+	" VALUES (@id, @name, @price, @currency, @active, @createdAt, @updatedAt)"
+
+func (p *PostgresRepo) Insert(ctx context.Context, item Item) error {
+	args := pgx.NamedArgs{
+		"id":        item.ID,
+		"name":      item.Name,
+		"price":     item.Price.Amount,
+		"currency":  item.Price.Currency,
+		"active":    item.Active,
+		"createdAt": item.CreatedAt,
+		"updatedAt": item.UpdatedAt,
+	}
+	_, err := p.Client.Exec(ctx, insertItemSQL, args)
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolationCode {
+		return fmt.Errorf("catalog item %s: %w", item.ID, ErrAlreadyExists)
+	} else if err != nil {
+		return fmt.Errorf("failed to insert catalog item: %w", err)
+	}
+
+	return nil
+}
+
+const selectItemColumns = itemIdRow + ", " + nameRow + ", " + priceRow + ", " + currencyRow + ", " + activeRow + ", " +
+	createdAtRow + ", " + updatedAtRow
+
+const selectItemSQL = "SELECT " + selectItemColumns + " FROM " + itemTable + " WHERE " + itemIdRow + " = @id"
+
+const selectItemsByIDSQL = "SELECT " + selectItemColumns + " FROM " + itemTable +
+	" WHERE " + itemIdRow + " = ANY(@ids)"
+
+const selectItemsSQL = "SELECT " + selectItemColumns + " FROM " + itemTable +
+	" WHERE " + itemIdRow + " > @after ORDER BY " + itemIdRow + " LIMIT @limit"
+
+func scanItem(row pgx.Row) (Item, error) {
+	var item Item
+	err := row.Scan(&item.ID, &item.Name, &item.Price.Amount, &item.Price.Currency, &item.Active, &item.CreatedAt,
+		&item.UpdatedAt)
+	if err != nil {
+		return Item{}, err
+	}
+
+	item.CreatedAt = item.CreatedAt.UTC()
+	item.UpdatedAt = item.UpdatedAt.UTC()
+
+	return item, nil
+}
+
+func (p *PostgresRepo) FindByID(ctx context.Context, id uuid.UUID) (Item, error) {
+	item, err := scanItem(p.Client.QueryRow(ctx, selectItemSQL, pgx.NamedArgs{"id": id}))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Item{}, ErrNotExist
+	} else if err != nil {
+		return Item{}, fmt.Errorf("failed to find catalog item: %w", err)
+	}
+
+	return item, nil
+}
+
+func (p *PostgresRepo) FindByIDs(ctx context.Context, ids []uuid.UUID) ([]Item, error) {
+	return p.queryItems(ctx, selectItemsByIDSQL, pgx.NamedArgs{"ids": ids})
+}
+
+func (p *PostgresRepo) FindAll(ctx context.Context, page FindAllPage) (FindResult, error) {
+	after, err := paging.ParseCursor(page.Cursor)
+	if err != nil {
+		return FindResult{}, err
+	}
+
+	// One more item than requested is fetched to know whether a next page exists.
+	args := pgx.NamedArgs{
+		"after": after,
+		"limit": page.Size + 1,
+	}
+
+	items, err := p.queryItems(ctx, selectItemsSQL, args)
+	if err != nil {
+		return FindResult{}, err
+	}
+
+	return pageOf(items, page.Size), nil
+}
+
+func (p *PostgresRepo) queryItems(ctx context.Context, sql string, args pgx.NamedArgs) ([]Item, error) {
+	rows, err := p.Client.Query(ctx, sql, args)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query catalog items: %w", err)
+	}
+	defer rows.Close()
+
+	items := []Item{}
+	for rows.Next() {
+		item, err := scanItem(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning catalog item row: %w", err)
+		}
+		items = append(items, item)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error closing rows: %w", err)
+	}
+
+	return items, nil
+}
+
+const updateItemSQL = "UPDATE " + itemTable + " SET " + nameRow + " = @name, " + priceRow + " = @price, " +
+	currencyRow + " = @currency, " + activeRow + " = @active, " + updatedAtRow + " = @updatedAt" +
+	" WHERE " + itemIdRow + " = @id"
+
+func (p *PostgresRepo) Update(ctx context.Context, item Item) error {
+	args := pgx.NamedArgs{
+		"id":        item.ID,
+		"name":      item.Name,
+		"price":     item.Price.Amount,
+		"currency":  item.Price.Currency,
+		"active":    item.Active,
+		"updatedAt": item.UpdatedAt,
+	}
+
+	tag, err := p.Client.Exec(ctx, updateItemSQL, args)
+	if err != nil {
+		return fmt.Errorf("failed to update catalog item: %w", err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return ErrNotExist
+	}
+
+	return nil
+}
+
+const deleteItemSQL = "DELETE FROM " + itemTable + " WHERE " + itemIdRow + " = @id"
+
+func (p *PostgresRepo) DeleteByID(ctx context.Context, id uuid.UUID) error {
+	tag, err := p.Client.Exec(ctx, deleteItemSQL, pgx.NamedArgs{"id": id})
+	if err != nil {
+		return fmt.Errorf("failed to delete catalog item: %w", err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return ErrNotExist
+	}
+
+	return nil
+}
@@ -0,0 +1,30 @@
+package catalog
+
+import (
+	"context"
+
+	"first-little-server/order"
+
+	"github.com/google/uuid"
+)
+
+// Prices is the order.PriceCatalog of the items of Repo.
+type Prices struct {
+	Repo Repository
+}
+
+func (p Prices) Prices(ctx context.Context, itemIDs []uuid.UUID) (map[uuid.UUID]order.Money, error) {
+	items, err := p.Repo.FindByIDs(ctx, itemIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	prices := make(map[uuid.UUID]order.Money, len(items))
+	for _, item := range items {
+		if item.Active {
+			prices[item.ID] = item.Price
+		}
+	}
+
+	return prices, nil
+}
@@ -0,0 +1,178 @@
+package catalog
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"first-little-server/paging"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisRepo keeps the catalog in the redis order database.
+type RedisRepo struct {
+	Client *redis.Client
+}
+
+const itemKeyPrefix = "catalog:item:"
+
+func itemKey(id uuid.UUID) string {
+	return itemKeyPrefix + id.String()
+}
+
+// itemsIndexKey is a sorted set of the item ids, all scored zero so that they page in lexical order.
+const itemsIndexKey = "catalog:items"
+
+func (repo *RedisRepo) Insert(ctx context.Context, item Item) error {
+	data, err := json.Marshal(item)
+	if err != nil {
+		return fmt.Errorf("failed to encode catalog item: %w", err)
+	}
+
+	set, err := repo.Client.SetNX(ctx, itemKey(item.ID), string(data), 0).Result()
+	if err != nil {
+		return fmt.Errorf("failed to insert catalog item: %w", err)
+	}
+
+	if !set {
+		return fmt.Errorf("catalog item %s: %w", item.ID, ErrAlreadyExists)
+	}
+
+	member := redis.Z{Score: 0, Member: item.ID.String()}
+	if err := repo.Client.ZAdd(ctx, itemsIndexKey, member).Err(); err != nil {
+		return fmt.Errorf("failed to index catalog item: %w", err)
+	}
+
+	return nil
+}
+
+func (repo *RedisRepo) FindByID(ctx context.Context, id uuid.UUID) (Item, error) {
+	value, err := repo.Client.Get(ctx, itemKey(id)).Result()
+	if errors.Is(err, redis.Nil) {
+		return Item{}, ErrNotExist
+	} else if err != nil {
+		return Item{}, fmt.Errorf("failed to find catalog item: %w", err)
+	}
+
+	var item Item
+	if err := json.Unmarshal([]byte(value), &item); err != nil {
+		return Item{}, fmt.Errorf("failed to decode catalog item json: %w", err)
+	}
+
+	return item, nil
+}
+
+func (repo *RedisRepo) FindByIDs(ctx context.Context, ids []uuid.UUID) ([]Item, error) {
+	if len(ids) == 0 {
+		return []Item{}, nil
+	}
+
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = itemKey(id)
+	}
+
+	return repo.getItems(ctx, keys)
+}
+
+// getItems reads the items stored at keys, skipping the missing ones.
+func (repo *RedisRepo) getItems(ctx context.Context, keys []string) ([]Item, error) {
+	values, err := repo.Client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get catalog items: %w", err)
+	}
+
+	items := make([]Item, 0, len(values))
+	for _, value := range values {
+		data, ok := value.(string)
+		if !ok {
+			continue
+		}
+
+		var item Item
+		if err := json.Unmarshal([]byte(data), &item); err != nil {
+			return nil, fmt.Errorf("failed to decode catalog item json: %w", err)
+		}
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
+func (repo *RedisRepo) FindAll(ctx context.Context, page FindAllPage) (FindResult, error) {
+	after, err := paging.ParseCursor(page.Cursor)
+	if err != nil {
+		return FindResult{}, err
+	}
+
+	start := "-"
+	if after != uuid.Nil {
+		start = "(" + after.String()
+	}
+
+	// One more item than requested is fetched to know whether a next page exists.
+	ids, err := repo.Client.ZRangeByLex(ctx, itemsIndexKey, &redis.ZRangeBy{
+		Min:   start,
+		Max:   "+",
+		Count: int64(page.Size + 1),
+	}).Result()
+	if err != nil {
+		return FindResult{}, fmt.Errorf("failed to page catalog items: %w", err)
+	}
+
+	if len(ids) == 0 {
+		return FindResult{Items: []Item{}}, nil
+	}
+
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = itemKeyPrefix + id
+	}
+
+	// An item deleted between the two reads is skipped.
+	items, err := repo.getItems(ctx, keys)
+	if err != nil {
+		return FindResult{}, err
+	}
+
+	return pageOf(items, page.Size), nil
+}
+
+func (repo *RedisRepo) Update(ctx context.Context, item Item) error {
+	data, err := json.Marshal(item)
+	if err != nil {
+		return fmt.Errorf("failed to encode catalog item: %w", err)
+	}
+
+	// SetXX only replaces an existing item.
+	set, err := repo.Client.SetXX(ctx, itemKey(item.ID), string(data), redis.KeepTTL).Result()
+	if err != nil {
+		return fmt.Errorf("failed to update catalog item: %w", err)
+	}
+
+	if !set {
+		return ErrNotExist
+	}
+
+	return nil
+}
+
+func (repo *RedisRepo) DeleteByID(ctx context.Context, id uuid.UUID) error {
+	deleted, err := repo.Client.Del(ctx, itemKey(id)).Result()
+	if err != nil {
+		return fmt.Errorf("failed to delete catalog item: %w", err)
+	}
+
+	if deleted == 0 {
+		return ErrNotExist
+	}
+
+	if err := repo.Client.ZRem(ctx, itemsIndexKey, id.String()).Err(); err != nil {
+		return fmt.Errorf("failed to unindex catalog item: %w", err)
+	}
+
+	return nil
+}
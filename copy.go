@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"first-little-server/application"
+	"first-little-server/customer"
+	"first-little-server/order"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// copyCheckpoint is saved after every copied page so that an interrupted copy resumes where it stopped.
+type copyCheckpoint struct {
+	Cursor string           `json:"cursor"`
+	Report order.CopyReport `json:"report"`
+}
+
+// runCopyOrders executes the copy-orders subcommand, which moves every order from one database to another.
+func runCopyOrders(ctx context.Context, config application.Config, args []string) error {
+	flags := flag.NewFlagSet("copy-orders", flag.ContinueOnError)
+	from := flags.String("from", string(application.ReddisEnv), "database to read the orders from")
+	to := flags.String("to", string(application.PostgresEnv), "database to write the orders to")
+	pageSize := flags.Uint("page-size", 100, "number of orders read at once")
+	dryRun := flags.Bool("dry-run", false, "report what would be copied without writing anything")
+	onConflict := flags.String("on-conflict", string(order.ConflictSkip), "skip, overwrite or fail on ids already in the destination")
+	checkpointPath := flags.String("checkpoint", ".copy-orders.checkpoint", "file saving the progress of the copy")
+	verify := flags.Bool("verify", true, "compare counts and checksums of both databases once copied")
+	verifyOnly := flags.Bool("verify-only", false, "only compare both databases")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *pageSize == 0 {
+		flags.Usage()
+		return errors.New("page size must be positive")
+	}
+
+	policy := order.ConflictPolicy(*onConflict)
+	switch policy {
+	case order.ConflictSkip, order.ConflictOverwrite, order.ConflictFail:
+	default:
+		return fmt.Errorf("unknown conflict policy %q", *onConflict)
+	}
+
+	if *from == *to {
+		return errors.New("source and destination databases must differ")
+	}
+
+	srcConfig := config
+	srcConfig.Database = application.EnvDatabase(*from)
+	src := application.NewDatastore(ctx, srcConfig)
+	defer closeDatastore(ctx, src)
+
+	dstConfig := config
+	dstConfig.Database = application.EnvDatabase(*to)
+	dst := application.NewDatastore(ctx, dstConfig)
+	defer closeDatastore(ctx, dst)
+
+	if err := src.Ping(ctx); err != nil {
+		return err
+	}
+	if err := dst.Ping(ctx); err != nil {
+		return err
+	}
+
+	srcRepo := src.GetActiveRepo()
+	dstRepo := dst.GetActiveRepo()
+
+	// Orders written before the redis indexes existed are only listed once indexed.
+	if redisRepo, ok := srcRepo.(*order.RedisRepo); ok {
+		if err := redisRepo.Reindex(ctx); err != nil {
+			return err
+		}
+	}
+
+	if !*verifyOnly {
+		checkpoint, err := loadCopyCheckpoint(*checkpointPath)
+		if err != nil {
+			return err
+		}
+		if checkpoint.Cursor != "" {
+			fmt.Printf("resuming from checkpoint %s after %d orders\n", *checkpointPath, checkpoint.Report.Read)
+		}
+
+		opts := order.CopyOptions{
+			PageSize:   *pageSize,
+			Cursor:     checkpoint.Cursor,
+			DryRun:     *dryRun,
+			OnConflict: policy,
+			OnPage: func(cursor string, report order.CopyReport) error {
+				if *dryRun {
+					return nil
+				}
+				return saveCopyCheckpoint(*checkpointPath, copyCheckpoint{Cursor: cursor, Report: addReports(checkpoint.Report, report)})
+			},
+		}
+
+		// The postgres orders reference their customer, which must be copied first.
+		if !*dryRun {
+			copied, err := copyCustomers(ctx, src.GetCustomerRepo(), dst.GetCustomerRepo(), *pageSize)
+			fmt.Printf("copied %d customers\n", copied)
+			if err != nil {
+				return err
+			}
+		}
+
+		// The destination history records the copied orders as created by the command.
+		placeholders := &customerPlaceholders{Repository: dstRepo, Customers: dst.GetCustomerRepo()}
+		report, err := order.Copy(order.WithActor(ctx, "copy-orders"), srcRepo, placeholders, opts)
+		report = addReports(checkpoint.Report, report)
+		fmt.Printf("read %d, inserted %d, skipped %d, overwritten %d\n",
+			report.Read, report.Inserted, report.Skipped, report.Overwritten)
+		if err != nil {
+			return err
+		}
+
+		if *dryRun {
+			fmt.Println("dry run, nothing was written")
+			return nil
+		}
+
+		if err := os.Remove(*checkpointPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove checkpoint: %w", err)
+		}
+	}
+
+	if !*verify && !*verifyOnly {
+		return nil
+	}
+
+	report, err := order.Verify(ctx, srcRepo, dstRepo, *pageSize)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("source: %d orders, checksum %s\n", report.SourceCount, report.SourceChecksum)
+	fmt.Printf("destination: %d orders, checksum %s\n", report.DestinationCount, report.DestinationChecksum)
+	if !report.Match() {
+		return fmt.Errorf("verification failed, missing orders %v, different orders %v", report.Missing, report.Different)
+	}
+	fmt.Println("verification succeeded")
+
+	return nil
+}
+
+// copyCustomers inserts the customers of src missing from dst and returns how many were inserted.
+func copyCustomers(ctx context.Context, src, dst customer.Repository, pageSize uint) (uint64, error) {
+	var copied uint64
+	page := customer.FindAllPage{Size: pageSize}
+
+	for {
+		res, err := src.FindAll(ctx, page)
+		if err != nil {
+			return copied, fmt.Errorf("failed to read source customers: %w", err)
+		}
+
+		for _, c := range res.Customers {
+			err := dst.Insert(ctx, c)
+			if errors.Is(err, customer.ErrAlreadyExists) {
+				continue
+			} else if err != nil {
+				return copied, fmt.Errorf("failed to copy customer %s: %w", c.ID, err)
+			}
+			copied++
+		}
+
+		if res.Cursor == "" {
+			return copied, nil
+		}
+		page.Cursor = res.Cursor
+	}
+}
+
+// customerPlaceholders inserts the copied orders into Repository along with a placeholder for their customer
+// when Customers lacks it. Legacy orders name customers which were never stored, the postgres orders
+// reference their customer and would be refused without one.
+type customerPlaceholders struct {
+	order.Repository
+	Customers customer.Repository
+}
+
+func (repo *customerPlaceholders) Insert(ctx context.Context, o order.Order) error {
+	if o.CustomerID != uuid.Nil {
+		exist, err := repo.Customers.Exists(ctx, o.CustomerID)
+		if err != nil {
+			return fmt.Errorf("failed to check customer %s: %w", o.CustomerID, err)
+		}
+
+		if !exist {
+			now := time.Now().UTC()
+			placeholder := customer.Customer{ID: o.CustomerID, CreatedAt: now, UpdatedAt: now}
+			if err := repo.Customers.Insert(ctx, placeholder); err != nil && !errors.Is(err, customer.ErrAlreadyExists) {
+				return fmt.Errorf("failed to insert placeholder customer %s: %w", o.CustomerID, err)
+			}
+		}
+	}
+
+	return repo.Repository.Insert(ctx, o)
+}
+
+func addReports(a, b order.CopyReport) order.CopyReport {
+	return order.CopyReport{
+		Read:        a.Read + b.Read,
+		Inserted:    a.Inserted + b.Inserted,
+		Skipped:     a.Skipped + b.Skipped,
+		Overwritten: a.Overwritten + b.Overwritten,
+	}
+}
+
+func loadCopyCheckpoint(path string) (copyCheckpoint, error) {
+	var checkpoint copyCheckpoint
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return checkpoint, nil
+	} else if err != nil {
+		return checkpoint, fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &checkpoint); err != nil {
+		return checkpoint, fmt.Errorf("failed to decode checkpoint: %w", err)
+	}
+
+	return checkpoint, nil
+}
+
+// saveCopyCheckpoint replaces the checkpoint file atomically so that a crash never leaves it truncated.
+func saveCopyCheckpoint(path string, checkpoint copyCheckpoint) error {
+	data, err := json.Marshal(checkpoint)
+	if err != nil {
+		return fmt.Errorf("failed to encode checkpoint: %w", err)
+	}
+
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to save checkpoint: %w", err)
+	}
+
+	return nil
+}
+
+func closeDatastore(ctx context.Context, ds *application.Datastore) {
+	if err := ds.Close(ctx); err != nil {
+		fmt.Println("failed to close datastore", err)
+	}
+}
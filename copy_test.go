@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"first-little-server/customer"
+	"first-little-server/order"
+	"first-little-server/order/repotest"
+
+	"github.com/google/uuid"
+)
+
+// referencingRepo refuses the orders of unknown customers as the postgres orders do.
+type referencingRepo struct {
+	*order.MemoryRepo
+	customers customer.Repository
+}
+
+func (repo *referencingRepo) Insert(ctx context.Context, o order.Order) error {
+	if o.CustomerID != uuid.Nil {
+		exist, err := repo.customers.Exists(ctx, o.CustomerID)
+		if err != nil {
+			return err
+		}
+		if !exist {
+			return order.ErrUnknownCustomer
+		}
+	}
+
+	return repo.MemoryRepo.Insert(ctx, o)
+}
+
+func TestCopyOrdersOfUnknownCustomers(t *testing.T) {
+	ctx := context.Background()
+	src := &order.MemoryRepo{}
+
+	unknown := repotest.NewOrder(1, 1)
+	withoutCustomer := repotest.NewOrder(2, 1)
+	withoutCustomer.CustomerID = uuid.Nil
+	for _, o := range []order.Order{unknown, withoutCustomer} {
+		if err := src.Insert(ctx, o); err != nil {
+			t.Fatalf("Insert(%d) in the source: %v", o.OrderID, err)
+		}
+	}
+
+	customers := &customer.MemoryRepo{}
+	dst := &referencingRepo{MemoryRepo: &order.MemoryRepo{}, customers: customers}
+	placeholders := &customerPlaceholders{Repository: dst, Customers: customers}
+
+	report, err := order.Copy(ctx, src, placeholders, order.CopyOptions{PageSize: 10})
+	if err != nil {
+		t.Fatalf("Copy: %v", err)
+	}
+	if report.Inserted != 2 {
+		t.Errorf("Copy inserted %d orders, want 2", report.Inserted)
+	}
+
+	exist, err := customers.Exists(ctx, unknown.CustomerID)
+	if err != nil || !exist {
+		t.Errorf("placeholder customer %s exists = %t, %v, want true", unknown.CustomerID, exist, err)
+	}
+
+	verified, err := order.Verify(ctx, src, dst, 10)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if !verified.Match() {
+		t.Errorf("Verify = %+v, want a match", verified)
+	}
+}
@@ -0,0 +1,285 @@
+package customer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"time"
+
+	"first-little-server/order"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+type Repository interface {
+	// Insert returns ErrAlreadyExists when a customer has the same id.
+	Insert(ctx context.Context, customer Customer) error
+	// FindByID returns ErrNotExist when no customer has the id.
+	FindByID(ctx context.Context, id uuid.UUID) (Customer, error)
+	// Exists tells whether a customer has the id, it is what orders check their customer with.
+	Exists(ctx context.Context, id uuid.UUID) (bool, error)
+	FindAll(ctx context.Context, page FindAllPage) (FindResult, error)
+	// Update replaces the name and email of the customer, it returns ErrNotExist when no customer has the id.
+	Update(ctx context.Context, customer Customer) error
+	// DeleteByID returns ErrNotExist when no customer has the id,
+	// and ErrHasOrders when the backend references the customers from the orders and an order names the customer.
+	DeleteByID(ctx context.Context, id uuid.UUID) error
+}
+
+type Handler struct {
+	Repo Repository
+	// Orders lists the orders of the customers, and keeps the customers with orders from being deleted.
+	Orders order.Repository
+}
+
+const pageSize = 50
+
+type customerBody struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+func (body customerBody) validate() error {
+	if body.Name == "" {
+		return errors.New("missing name")
+	}
+
+	if _, err := mail.ParseAddress(body.Email); err != nil {
+		return fmt.Errorf("invalid email: %w", err)
+	}
+
+	return nil
+}
+
+func (h *Handler) Create(w http.ResponseWriter, r *http.Request) {
+	var body customerBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if err := body.validate(); err != nil {
+		fmt.Println("invalid customer:", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now().UTC()
+	customer := Customer{
+		ID:        uuid.New(),
+		Name:      body.Name,
+		Email:     body.Email,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	if err := h.Repo.Insert(r.Context(), customer); err != nil {
+		fmt.Println("failed to insert customer:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	res, err := json.Marshal(customer)
+	if err != nil {
+		fmt.Println("failed to marshal:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	_, _ = w.Write(res)
+}
+
+func (h *Handler) List(w http.ResponseWriter, r *http.Request) {
+	page := FindAllPage{Size: pageSize, Cursor: r.URL.Query().Get("cursor")}
+
+	res, err := h.Repo.FindAll(r.Context(), page)
+	if errors.Is(err, ErrInvalidCursor) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	} else if err != nil {
+		fmt.Println("failed to find customers:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	var response struct {
+		Items []Customer `json:"items"`
+		Next  string     `json:"next,omitempty"`
+	}
+	response.Items = res.Customers
+	response.Next = res.Cursor
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		fmt.Println("failed to marshal:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *Handler) GetByID(w http.ResponseWriter, r *http.Request) {
+	customerID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	customer, err := h.Repo.FindByID(r.Context(), customerID)
+	if errors.Is(err, ErrNotExist) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		fmt.Println("failed to find customer:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(customer); err != nil {
+		fmt.Println("failed to marshal:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *Handler) UpdateByID(w http.ResponseWriter, r *http.Request) {
+	customerID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var body customerBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if err := body.validate(); err != nil {
+		fmt.Println("invalid customer:", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	customer, err := h.Repo.FindByID(r.Context(), customerID)
+	if errors.Is(err, ErrNotExist) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		fmt.Println("failed to find customer:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	customer.Name = body.Name
+	customer.Email = body.Email
+	customer.UpdatedAt = time.Now().UTC()
+
+	err = h.Repo.Update(r.Context(), customer)
+	if errors.Is(err, ErrNotExist) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		fmt.Println("failed to update customer:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(customer); err != nil {
+		fmt.Println("failed to marshal:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *Handler) DeleteByID(w http.ResponseWriter, r *http.Request) {
+	customerID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Customers with orders, even deleted or cancelled ones, are kept for the orders to name them.
+	// The postgres orders reference their customer, which also rejects the deletion racing an order creation.
+	filter := order.FindAllFilter{
+		CustomerID: customerID,
+		Deleted:    order.DeletedInclude,
+		Cancelled:  order.CancelledInclude,
+	}
+	orders, err := h.Orders.FindAll(r.Context(), filter, order.FindAllPage{Size: 1})
+	if err != nil {
+		fmt.Println("failed to find orders:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if len(orders.Orders) > 0 {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+
+	err = h.Repo.DeleteByID(r.Context(), customerID)
+	if errors.Is(err, ErrNotExist) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if errors.Is(err, ErrHasOrders) {
+		w.WriteHeader(http.StatusConflict)
+		return
+	} else if err != nil {
+		fmt.Println("failed to delete customer:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListOrders lists the orders of a customer, with the cursor of the order listing.
+func (h *Handler) ListOrders(w http.ResponseWriter, r *http.Request) {
+	customerID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	exist, err := h.Repo.Exists(r.Context(), customerID)
+	if err != nil {
+		fmt.Println("failed to find customer:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if !exist {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	filter := order.FindAllFilter{CustomerID: customerID}
+	page := order.FindAllPage{Size: pageSize, Cursor: r.URL.Query().Get("cursor")}
+
+	res, err := h.Orders.FindAll(r.Context(), filter, page)
+	if errors.Is(err, order.ErrInvalidCursor) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	} else if err != nil {
+		fmt.Println("failed to find orders:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	var response struct {
+		Items []order.Order `json:"items"`
+		Next  string        `json:"next,omitempty"`
+	}
+	response.Items = res.Orders
+	response.Next = res.Cursor
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		fmt.Println("failed to marshal:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
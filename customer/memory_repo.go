@@ -0,0 +1,110 @@
+package customer
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"first-little-server/paging"
+
+	"github.com/google/uuid"
+)
+
+// MemoryRepo keeps customers in process memory, along with the memory order database.
+// The zero value is ready to use.
+type MemoryRepo struct {
+	mu        sync.RWMutex
+	customers map[uuid.UUID]Customer
+}
+
+func (repo *MemoryRepo) Insert(_ context.Context, customer Customer) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if repo.customers == nil {
+		repo.customers = make(map[uuid.UUID]Customer)
+	}
+
+	if _, exist := repo.customers[customer.ID]; exist {
+		return fmt.Errorf("customer %s: %w", customer.ID, ErrAlreadyExists)
+	}
+
+	repo.customers[customer.ID] = customer
+
+	return nil
+}
+
+func (repo *MemoryRepo) FindByID(_ context.Context, id uuid.UUID) (Customer, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	customer, exist := repo.customers[id]
+	if !exist {
+		return Customer{}, ErrNotExist
+	}
+
+	return customer, nil
+}
+
+func (repo *MemoryRepo) Exists(_ context.Context, id uuid.UUID) (bool, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	_, exist := repo.customers[id]
+
+	return exist, nil
+}
+
+func (repo *MemoryRepo) FindAll(_ context.Context, page FindAllPage) (FindResult, error) {
+	after, err := paging.ParseCursor(page.Cursor)
+	if err != nil {
+		return FindResult{}, err
+	}
+
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	customers := make([]Customer, 0, len(repo.customers))
+	for id, customer := range repo.customers {
+		if after == uuid.Nil || id.String() > after.String() {
+			customers = append(customers, customer)
+		}
+	}
+
+	sort.Slice(customers, func(i, j int) bool {
+		return customers[i].ID.String() < customers[j].ID.String()
+	})
+
+	if uint(len(customers)) > page.Size+1 {
+		customers = customers[:page.Size+1]
+	}
+
+	return pageOf(customers, page.Size), nil
+}
+
+func (repo *MemoryRepo) Update(_ context.Context, customer Customer) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if _, exist := repo.customers[customer.ID]; !exist {
+		return ErrNotExist
+	}
+
+	repo.customers[customer.ID] = customer
+
+	return nil
+}
+
+func (repo *MemoryRepo) DeleteByID(_ context.Context, id uuid.UUID) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if _, exist := repo.customers[id]; !exist {
+		return ErrNotExist
+	}
+
+	delete(repo.customers, id)
+
+	return nil
+}
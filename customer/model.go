@@ -0,0 +1,47 @@
+package customer
+
+import (
+	"errors"
+	"time"
+
+	"first-little-server/paging"
+
+	"github.com/google/uuid"
+)
+
+// Customer places orders, whose CustomerID must name an existing customer.
+type Customer struct {
+	ID        uuid.UUID `json:"id"`
+	Name      string    `json:"name"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+var ErrNotExist = errors.New("customer does not exist")
+
+var ErrAlreadyExists = errors.New("customer already exists")
+
+var ErrHasOrders = errors.New("customer has orders")
+
+var ErrInvalidCursor = paging.ErrInvalidCursor
+
+// FindAllPage requests a page of customers sorted by id.
+// Cursor is the value returned by the previous page, it is empty for the first page.
+type FindAllPage struct {
+	Size   uint
+	Cursor string
+}
+
+// FindResult is a page of customers, Cursor is empty on the last page.
+type FindResult struct {
+	Customers []Customer
+	Cursor    string
+}
+
+// pageOf trims customers, fetched with one more customer than the page size, to the page
+// and returns the cursor of the next page.
+func pageOf(customers []Customer, size uint) FindResult {
+	customers, cursor := paging.Trim(customers, size, func(record Customer) uuid.UUID { return record.ID })
+	return FindResult{Customers: customers, Cursor: cursor}
+}
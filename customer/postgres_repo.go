@@ -0,0 +1,179 @@
+package customer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"first-little-server/paging"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// PostgresRepo keeps customers in the postgres order database.
+type PostgresRepo struct {
+	Client *pgxpool.Pool
+}
+
+const (
+	customerTable = "customer"
+
+	customerIdRow = "customer_id"
+	nameRow       = "name"
+	emailRow      = "email"
+	createdAtRow  = "created_at"
+	updatedAtRow  = "updated_at"
+)
+
+// uniqueViolationCode is the postgres error code of a duplicate key.
+const uniqueViolationCode = "23505"
+
+// foreignKeyViolationCode is the postgres error code of a row still referenced, by the orders of a customer.
+const foreignKeyViolationCode = "23503"
+
+const insertCustomerSQL = "INSERT INTO " + customerTable +
+	" (" + customerIdRow + ", " + nameRow + ", " + emailRow + ", " + createdAtRow + ", " + updatedAtRow + ")" +
+	" VALUES (@id, @name, @email, @createdAt, @updatedAt)"
+
+func (p *PostgresRepo) Insert(ctx context.Context, customer Customer) error {
+	args := pgx.NamedArgs{
+		"id":        customer.ID,
+		"name":      customer.Name,
+		"email":     customer.Email,
+		"createdAt": customer.CreatedAt,
+		"updatedAt": customer.UpdatedAt,
+	}
+	_, err := p.Client.Exec(ctx, insertCustomerSQL, args)
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolationCode {
+		return fmt.Errorf("customer %s: %w", customer.ID, ErrAlreadyExists)
+	} else if err != nil {
+		return fmt.Errorf("failed to insert customer: %w", err)
+	}
+
+	return nil
+}
+
+const selectCustomerColumns = customerIdRow + ", " + nameRow + ", " + emailRow + ", " + createdAtRow + ", " +
+	updatedAtRow
+
+const selectCustomerSQL = "SELECT " + selectCustomerColumns + " FROM " + customerTable +
+	" WHERE " + customerIdRow + " = @id"
+
+func scanCustomer(row pgx.Row) (Customer, error) {
+	var customer Customer
+	err := row.Scan(&customer.ID, &customer.Name, &customer.Email, &customer.CreatedAt, &customer.UpdatedAt)
+	if err != nil {
+		return Customer{}, err
+	}
+
+	customer.CreatedAt = customer.CreatedAt.UTC()
+	customer.UpdatedAt = customer.UpdatedAt.UTC()
+
+	return customer, nil
+}
+
+func (p *PostgresRepo) FindByID(ctx context.Context, id uuid.UUID) (Customer, error) {
+	customer, err := scanCustomer(p.Client.QueryRow(ctx, selectCustomerSQL, pgx.NamedArgs{"id": id}))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Customer{}, ErrNotExist
+	} else if err != nil {
+		return Customer{}, fmt.Errorf("failed to find customer: %w", err)
+	}
+
+	return customer, nil
+}
+
+const existCustomerSQL = "SELECT EXISTS (SELECT 1 FROM " + customerTable + " WHERE " + customerIdRow + " = @id)"
+
+func (p *PostgresRepo) Exists(ctx context.Context, id uuid.UUID) (bool, error) {
+	var exist bool
+	if err := p.Client.QueryRow(ctx, existCustomerSQL, pgx.NamedArgs{"id": id}).Scan(&exist); err != nil {
+		return false, fmt.Errorf("failed to check customer existence: %w", err)
+	}
+
+	return exist, nil
+}
+
+const selectCustomersSQL = "SELECT " + selectCustomerColumns + " FROM " + customerTable +
+	" WHERE " + customerIdRow + " > @after ORDER BY " + customerIdRow + " LIMIT @limit"
+
+func (p *PostgresRepo) FindAll(ctx context.Context, page FindAllPage) (FindResult, error) {
+	after, err := paging.ParseCursor(page.Cursor)
+	if err != nil {
+		return FindResult{}, err
+	}
+
+	// One more customer than requested is fetched to know whether a next page exists.
+	args := pgx.NamedArgs{
+		"after": after,
+		"limit": page.Size + 1,
+	}
+
+	rows, err := p.Client.Query(ctx, selectCustomersSQL, args)
+	if err != nil {
+		return FindResult{}, fmt.Errorf("failed to query customers: %w", err)
+	}
+	defer rows.Close()
+
+	customers := []Customer{}
+	for rows.Next() {
+		customer, err := scanCustomer(rows)
+		if err != nil {
+			return FindResult{}, fmt.Errorf("error scanning customer row: %w", err)
+		}
+		customers = append(customers, customer)
+	}
+
+	if err := rows.Err(); err != nil {
+		return FindResult{}, fmt.Errorf("error closing rows: %w", err)
+	}
+
+	return pageOf(customers, page.Size), nil
+}
+
+const updateCustomerSQL = "UPDATE " + customerTable + " SET " + nameRow + " = @name, " + emailRow + " = @email, " +
+	updatedAtRow + " = @updatedAt WHERE " + customerIdRow + " = @id"
+
+func (p *PostgresRepo) Update(ctx context.Context, customer Customer) error {
+	args := pgx.NamedArgs{
+		"id":        customer.ID,
+		"name":      customer.Name,
+		"email":     customer.Email,
+		"updatedAt": customer.UpdatedAt,
+	}
+
+	tag, err := p.Client.Exec(ctx, updateCustomerSQL, args)
+	if err != nil {
+		return fmt.Errorf("failed to update customer: %w", err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return ErrNotExist
+	}
+
+	return nil
+}
+
+const deleteCustomerSQL = "DELETE FROM " + customerTable + " WHERE " + customerIdRow + " = @id"
+
+func (p *PostgresRepo) DeleteByID(ctx context.Context, id uuid.UUID) error {
+	tag, err := p.Client.Exec(ctx, deleteCustomerSQL, pgx.NamedArgs{"id": id})
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == foreignKeyViolationCode {
+		return fmt.Errorf("customer %s: %w", id, ErrHasOrders)
+	} else if err != nil {
+		return fmt.Errorf("failed to delete customer: %w", err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return ErrNotExist
+	}
+
+	return nil
+}
@@ -0,0 +1,164 @@
+package customer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"first-little-server/paging"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisRepo keeps customers in the redis order database.
+type RedisRepo struct {
+	Client *redis.Client
+}
+
+const customerKeyPrefix = "customer:"
+
+func customerKey(id uuid.UUID) string {
+	return customerKeyPrefix + id.String()
+}
+
+// customersIndexKey is a sorted set of the customer ids, all scored zero so that they page in lexical order.
+const customersIndexKey = "customers"
+
+func (repo *RedisRepo) Insert(ctx context.Context, customer Customer) error {
+	data, err := json.Marshal(customer)
+	if err != nil {
+		return fmt.Errorf("failed to encode customer: %w", err)
+	}
+
+	set, err := repo.Client.SetNX(ctx, customerKey(customer.ID), string(data), 0).Result()
+	if err != nil {
+		return fmt.Errorf("failed to insert customer: %w", err)
+	}
+
+	if !set {
+		return fmt.Errorf("customer %s: %w", customer.ID, ErrAlreadyExists)
+	}
+
+	member := redis.Z{Score: 0, Member: customer.ID.String()}
+	if err := repo.Client.ZAdd(ctx, customersIndexKey, member).Err(); err != nil {
+		return fmt.Errorf("failed to index customer: %w", err)
+	}
+
+	return nil
+}
+
+func (repo *RedisRepo) FindByID(ctx context.Context, id uuid.UUID) (Customer, error) {
+	value, err := repo.Client.Get(ctx, customerKey(id)).Result()
+	if errors.Is(err, redis.Nil) {
+		return Customer{}, ErrNotExist
+	} else if err != nil {
+		return Customer{}, fmt.Errorf("failed to find customer: %w", err)
+	}
+
+	var customer Customer
+	if err := json.Unmarshal([]byte(value), &customer); err != nil {
+		return Customer{}, fmt.Errorf("failed to decode customer json: %w", err)
+	}
+
+	return customer, nil
+}
+
+func (repo *RedisRepo) Exists(ctx context.Context, id uuid.UUID) (bool, error) {
+	exist, err := repo.Client.Exists(ctx, customerKey(id)).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to check customer existence: %w", err)
+	}
+
+	return exist > 0, nil
+}
+
+func (repo *RedisRepo) FindAll(ctx context.Context, page FindAllPage) (FindResult, error) {
+	after, err := paging.ParseCursor(page.Cursor)
+	if err != nil {
+		return FindResult{}, err
+	}
+
+	start := "-"
+	if after != uuid.Nil {
+		start = "(" + after.String()
+	}
+
+	// One more customer than requested is fetched to know whether a next page exists.
+	ids, err := repo.Client.ZRangeByLex(ctx, customersIndexKey, &redis.ZRangeBy{
+		Min:   start,
+		Max:   "+",
+		Count: int64(page.Size + 1),
+	}).Result()
+	if err != nil {
+		return FindResult{}, fmt.Errorf("failed to page customers: %w", err)
+	}
+
+	if len(ids) == 0 {
+		return FindResult{Customers: []Customer{}}, nil
+	}
+
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = customerKeyPrefix + id
+	}
+
+	values, err := repo.Client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return FindResult{}, fmt.Errorf("failed to get customers: %w", err)
+	}
+
+	customers := make([]Customer, 0, len(values))
+	for _, value := range values {
+		// A customer deleted between the two reads is skipped.
+		data, ok := value.(string)
+		if !ok {
+			continue
+		}
+
+		var customer Customer
+		if err := json.Unmarshal([]byte(data), &customer); err != nil {
+			return FindResult{}, fmt.Errorf("failed to decode customer json: %w", err)
+		}
+		customers = append(customers, customer)
+	}
+
+	return pageOf(customers, page.Size), nil
+}
+
+func (repo *RedisRepo) Update(ctx context.Context, customer Customer) error {
+	data, err := json.Marshal(customer)
+	if err != nil {
+		return fmt.Errorf("failed to encode customer: %w", err)
+	}
+
+	// SetXX only replaces an existing customer.
+	set, err := repo.Client.SetXX(ctx, customerKey(customer.ID), string(data), redis.KeepTTL).Result()
+	if err != nil {
+		return fmt.Errorf("failed to update customer: %w", err)
+	}
+
+	if !set {
+		return ErrNotExist
+	}
+
+	return nil
+}
+
+func (repo *RedisRepo) DeleteByID(ctx context.Context, id uuid.UUID) error {
+	deleted, err := repo.Client.Del(ctx, customerKey(id)).Result()
+	if err != nil {
+		return fmt.Errorf("failed to delete customer: %w", err)
+	}
+
+	if deleted == 0 {
+		return ErrNotExist
+	}
+
+	if err := repo.Client.ZRem(ctx, customersIndexKey, id.String()).Err(); err != nil {
+		return fmt.Errorf("failed to unindex customer: %w", err)
+	}
+
+	return nil
+}
@@ -12,6 +12,22 @@ func main() {
 	ctx, cancelFunc := signal.NotifyContext(context.Background(), os.Interrupt)
 	defer cancelFunc()
 
+	if len(os.Args) > 1 {
+		subcommands := map[string]func(context.Context, application.Config, []string) error{
+			"migrate":     runMigrate,
+			"copy-orders": runCopyOrders,
+		}
+
+		if run, exist := subcommands[os.Args[1]]; exist {
+			if err := run(ctx, application.LoadConfig(), os.Args[2:]); err != nil {
+				fmt.Printf("failed to %s: %v\n", os.Args[1], err)
+				cancelFunc()
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
 	app := application.NewApp(ctx, application.LoadConfig())
 
 	err := app.Start(ctx)
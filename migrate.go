@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"first-little-server/application"
+	"fmt"
+)
+
+const migrateUsage = "usage: migrate up|down|status"
+
+// runMigrate executes the migrate subcommand against the configured postgres database.
+func runMigrate(ctx context.Context, config application.Config, args []string) error {
+	if len(args) != 1 {
+		return errors.New(migrateUsage)
+	}
+
+	ds := application.NewDatastore(ctx, config)
+	defer closeDatastore(ctx, ds)
+
+	if err := ds.Ping(ctx); err != nil {
+		return err
+	}
+
+	migrator := ds.GetMigrator()
+	if migrator == nil {
+		return fmt.Errorf("database %s does not support migrations", config.Database)
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		for _, migration := range applied {
+			fmt.Printf("applied %d_%s\n", migration.Version, migration.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			fmt.Println("schema is up to date")
+		}
+	case "down":
+		migration, err := migrator.Down(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("reverted %d_%s\n", migration.Version, migration.Name)
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			state := "pending"
+			if status.AppliedAt != nil {
+				state = "applied " + status.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%d_%s\t%s\n", status.Migration.Version, status.Migration.Name, state)
+		}
+	default:
+		return errors.New(migrateUsage)
+	}
+
+	return nil
+}
@@ -0,0 +1,298 @@
+// Package migration applies the versioned postgres schema embedded in the binary.
+//
+// Every migration is a pair of files in the sql directory named
+// <version>_<name>.up.sql and <version>_<name>.down.sql.
+package migration
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+//go:embed sql/*.sql
+var files embed.FS
+
+type Migration struct {
+	Version int64
+	Name    string
+	up      string
+	down    string
+}
+
+type Status struct {
+	Migration Migration
+	AppliedAt *time.Time
+}
+
+type Migrator struct {
+	Client *pgxpool.Pool
+}
+
+var ErrNothingToRollback = errors.New("no migration has been applied")
+
+const (
+	migrationTable = "schema_migration"
+
+	versionRow   = "version"
+	nameRow      = "name"
+	appliedAtRow = "applied_at"
+)
+
+const createMigrationTableSQL = "CREATE TABLE IF NOT EXISTS " + migrationTable + " (" +
+	versionRow + " BIGINT PRIMARY KEY, " + nameRow + " TEXT NOT NULL, " +
+	appliedAtRow + " TIMESTAMPTZ NOT NULL DEFAULT now())"
+const selectAppliedSQL = "SELECT " + versionRow + ", " + appliedAtRow + " FROM " + migrationTable
+const insertAppliedSQL = "INSERT INTO " + migrationTable + " (" + versionRow + ", " + nameRow + ") VALUES ($1, $2)"
+const deleteAppliedSQL = "DELETE FROM " + migrationTable + " WHERE " + versionRow + " = $1"
+
+// lockSQL serializes concurrent migrators, the lock is released with the transaction.
+// The key is an arbitrary constant shared by every instance of the server.
+const lockSQL = "SELECT pg_advisory_xact_lock(8146391)"
+
+// Migrations returns every embedded migration sorted by version.
+func Migrations() ([]Migration, error) {
+	entries, err := fs.ReadDir(files, "sql")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read embedded migrations: %w", err)
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		fileName := entry.Name()
+
+		var direction string
+		switch {
+		case strings.HasSuffix(fileName, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(fileName, ".down.sql"):
+			direction = "down"
+		default:
+			return nil, fmt.Errorf("migration %s is neither an up nor a down migration", fileName)
+		}
+
+		versionStr, name, found := strings.Cut(strings.TrimSuffix(fileName, "."+direction+".sql"), "_")
+		if !found {
+			return nil, fmt.Errorf("migration %s is not named <version>_<name>", fileName)
+		}
+
+		const decimal = 10
+		const bitSize = 64
+		version, err := strconv.ParseInt(versionStr, decimal, bitSize)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse version of migration %s: %w", fileName, err)
+		}
+
+		content, err := fs.ReadFile(files, path.Join("sql", fileName))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %w", fileName, err)
+		}
+
+		migration, exist := byVersion[version]
+		if !exist {
+			migration = &Migration{Version: version, Name: name}
+			byVersion[version] = migration
+		}
+
+		if direction == "up" {
+			migration.up = string(content)
+		} else {
+			migration.down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.up == "" || migration.down == "" {
+			return nil, fmt.Errorf("migration %d is missing its up or down file", migration.Version)
+		}
+		migrations = append(migrations, *migration)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// Status reports every embedded migration along with the time it was applied, if it was.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, len(migrations))
+	for i, migration := range migrations {
+		statuses[i] = Status{Migration: migration}
+
+		if appliedAt, exist := applied[migration.Version]; exist {
+			statuses[i].AppliedAt = &appliedAt
+		}
+	}
+
+	return statuses, nil
+}
+
+// Pending returns the number of embedded migrations not yet applied.
+func (m *Migrator) Pending(ctx context.Context) (int, error) {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	pending := 0
+	for _, status := range statuses {
+		if status.AppliedAt == nil {
+			pending++
+		}
+	}
+
+	return pending, nil
+}
+
+// Up applies every pending migration in version order and returns the ones applied.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []Migration
+	for _, migration := range migrations {
+		done, err := m.apply(ctx, migration, true)
+		if err != nil {
+			return applied, fmt.Errorf("failed to apply migration %d_%s: %w", migration.Version, migration.Name, err)
+		}
+
+		if done {
+			applied = append(applied, migration)
+		}
+	}
+
+	return applied, nil
+}
+
+// Down reverts the most recently applied migration and returns it.
+func (m *Migrator) Down(ctx context.Context) (Migration, error) {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return Migration{}, err
+	}
+
+	for i := len(statuses) - 1; i >= 0; i-- {
+		if statuses[i].AppliedAt == nil {
+			continue
+		}
+
+		migration := statuses[i].Migration
+		if _, err := m.apply(ctx, migration, false); err != nil {
+			return Migration{}, fmt.Errorf("failed to revert migration %d_%s: %w", migration.Version, migration.Name, err)
+		}
+
+		return migration, nil
+	}
+
+	return Migration{}, ErrNothingToRollback
+}
+
+// apply runs one direction of a migration along with its bookkeeping in a single transaction.
+// It reports false when another migrator already did the work.
+func (m *Migrator) apply(ctx context.Context, migration Migration, up bool) (bool, error) {
+	tx, err := m.Client.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return false, fmt.Errorf("failed to begin migration transaction: %w", err)
+	}
+
+	// Rollback is a no-op once the transaction has been committed.
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	if _, err := tx.Exec(ctx, lockSQL); err != nil {
+		return false, fmt.Errorf("failed to lock migrations: %w", err)
+	}
+
+	if _, err := tx.Exec(ctx, createMigrationTableSQL); err != nil {
+		return false, fmt.Errorf("failed to create migration table: %w", err)
+	}
+
+	var applied bool
+	err = tx.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM "+migrationTable+" WHERE "+versionRow+" = $1)",
+		migration.Version).Scan(&applied)
+	if err != nil {
+		return false, fmt.Errorf("failed to read migration table: %w", err)
+	}
+
+	if applied == up {
+		return false, nil
+	}
+
+	if up {
+		if _, err := tx.Exec(ctx, migration.up); err != nil {
+			return false, err
+		}
+		if _, err := tx.Exec(ctx, insertAppliedSQL, migration.Version, migration.Name); err != nil {
+			return false, fmt.Errorf("failed to record migration: %w", err)
+		}
+	} else {
+		if _, err := tx.Exec(ctx, migration.down); err != nil {
+			return false, err
+		}
+		if _, err := tx.Exec(ctx, deleteAppliedSQL, migration.Version); err != nil {
+			return false, fmt.Errorf("failed to record migration: %w", err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return false, fmt.Errorf("failed to commit migration transaction: %w", err)
+	}
+
+	return true, nil
+}
+
+func (m *Migrator) applied(ctx context.Context) (map[int64]time.Time, error) {
+	if _, err := m.Client.Exec(ctx, createMigrationTableSQL); err != nil {
+		return nil, fmt.Errorf("failed to create migration table: %w", err)
+	}
+
+	rows, err := m.Client.Query(ctx, selectAppliedSQL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query migration table: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int64]time.Time)
+	for rows.Next() {
+		var version int64
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, fmt.Errorf("error scanning migration row: %w", err)
+		}
+
+		applied[version] = appliedAt.UTC()
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error closing rows: %w", err)
+	}
+
+	return applied, nil
+}
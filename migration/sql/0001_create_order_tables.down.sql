@@ -0,0 +1,2 @@
+DROP TABLE line_item;
+DROP TABLE order_store;
@@ -0,0 +1,16 @@
+CREATE TABLE order_store (
+    order_id     BIGINT PRIMARY KEY,
+    customer_id  UUID        NOT NULL,
+    created_at   TIMESTAMPTZ NOT NULL,
+    shipped_at   TIMESTAMPTZ,
+    completed_at TIMESTAMPTZ
+);
+
+CREATE TABLE line_item (
+    item_id  UUID   NOT NULL,
+    quantity BIGINT NOT NULL,
+    price    BIGINT NOT NULL,
+    order_id BIGINT NOT NULL REFERENCES order_store (order_id) ON DELETE CASCADE
+);
+
+CREATE INDEX line_item_order_id_idx ON line_item (order_id);
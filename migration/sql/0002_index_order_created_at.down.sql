@@ -0,0 +1 @@
+DROP INDEX order_store_created_at_idx;
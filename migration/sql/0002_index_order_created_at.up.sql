@@ -0,0 +1 @@
+CREATE INDEX order_store_created_at_idx ON order_store (created_at, order_id);
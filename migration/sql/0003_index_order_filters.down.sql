@@ -0,0 +1,3 @@
+DROP INDEX line_item_item_id_idx;
+DROP INDEX order_store_shipped_at_idx;
+DROP INDEX order_store_customer_id_idx;
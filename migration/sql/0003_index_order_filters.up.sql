@@ -0,0 +1,3 @@
+CREATE INDEX order_store_customer_id_idx ON order_store (customer_id, created_at, order_id);
+CREATE INDEX order_store_shipped_at_idx ON order_store (shipped_at);
+CREATE INDEX line_item_item_id_idx ON line_item (item_id);
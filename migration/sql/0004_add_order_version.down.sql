@@ -0,0 +1 @@
+ALTER TABLE order_store DROP COLUMN version;
@@ -0,0 +1 @@
+ALTER TABLE order_store ADD COLUMN version BIGINT NOT NULL DEFAULT 1;
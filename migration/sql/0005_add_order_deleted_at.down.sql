@@ -0,0 +1,2 @@
+DROP INDEX order_store_deleted_at_idx;
+ALTER TABLE order_store DROP COLUMN deleted_at;
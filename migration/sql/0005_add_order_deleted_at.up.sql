@@ -0,0 +1,3 @@
+ALTER TABLE order_store ADD COLUMN deleted_at TIMESTAMPTZ;
+
+CREATE INDEX order_store_deleted_at_idx ON order_store (deleted_at) WHERE deleted_at IS NOT NULL;
@@ -0,0 +1,11 @@
+CREATE TABLE order_event (
+    event_id    BIGSERIAL PRIMARY KEY,
+    order_id    BIGINT      NOT NULL REFERENCES order_store (order_id) ON DELETE CASCADE,
+    type        TEXT        NOT NULL,
+    actor       TEXT        NOT NULL,
+    occurred_at TIMESTAMPTZ NOT NULL,
+    version     BIGINT      NOT NULL,
+    changes     JSONB       NOT NULL
+);
+
+CREATE INDEX order_event_order_id_idx ON order_event (order_id, event_id);
@@ -0,0 +1,14 @@
+-- The outbox has no foreign key, the events of purged orders may still be pending.
+CREATE TABLE order_outbox (
+    event_id        BIGSERIAL PRIMARY KEY,
+    order_id        BIGINT      NOT NULL,
+    type            TEXT        NOT NULL,
+    payload         JSONB       NOT NULL,
+    occurred_at     TIMESTAMPTZ NOT NULL,
+    attempts        INTEGER     NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ NOT NULL,
+    dispatched_at   TIMESTAMPTZ,
+    last_error      TEXT
+);
+
+CREATE INDEX order_outbox_pending_idx ON order_outbox (order_id, event_id) WHERE dispatched_at IS NULL;
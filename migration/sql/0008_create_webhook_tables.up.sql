@@ -0,0 +1,32 @@
+CREATE TABLE webhook_subscription (
+    subscription_id UUID PRIMARY KEY,
+    url             TEXT        NOT NULL,
+    event_types     TEXT[]      NOT NULL,
+    secret          TEXT        NOT NULL,
+    created_at      TIMESTAMPTZ NOT NULL
+);
+
+-- Deliveries have no foreign key, they outlive their subscription for inspection.
+CREATE TABLE webhook_delivery (
+    delivery_id     UUID PRIMARY KEY,
+    subscription_id UUID        NOT NULL,
+    event           JSONB       NOT NULL,
+    status          TEXT        NOT NULL,
+    attempts        INTEGER     NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ NOT NULL,
+    last_error      TEXT        NOT NULL DEFAULT '',
+    created_at      TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX webhook_delivery_due_idx ON webhook_delivery (next_attempt_at) WHERE status = 'pending';
+CREATE INDEX webhook_delivery_subscription_idx ON webhook_delivery (subscription_id, status, created_at);
+
+CREATE TABLE webhook_attempt (
+    delivery_id  UUID        NOT NULL REFERENCES webhook_delivery (delivery_id) ON DELETE CASCADE,
+    attempted_at TIMESTAMPTZ NOT NULL,
+    status_code  INTEGER     NOT NULL,
+    error        TEXT        NOT NULL,
+    duration_ns  BIGINT      NOT NULL
+);
+
+CREATE INDEX webhook_attempt_delivery_id_idx ON webhook_attempt (delivery_id, attempted_at);
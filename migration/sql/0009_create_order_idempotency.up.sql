@@ -0,0 +1,11 @@
+-- The response is null while the request holding the key is in progress.
+CREATE TABLE order_idempotency (
+    idempotency_key TEXT        PRIMARY KEY,
+    fingerprint     TEXT        NOT NULL,
+    status_code     INTEGER,
+    etag            TEXT,
+    body            BYTEA,
+    expires_at      TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX order_idempotency_expires_at_idx ON order_idempotency (expires_at);
@@ -0,0 +1,2 @@
+DROP INDEX order_store_status_idx;
+ALTER TABLE order_store DROP COLUMN status;
@@ -0,0 +1,12 @@
+ALTER TABLE order_store ADD COLUMN status TEXT;
+
+-- Existing orders get the status their timestamps implied.
+UPDATE order_store SET status = CASE
+    WHEN completed_at IS NOT NULL THEN 'completed'
+    WHEN shipped_at IS NOT NULL THEN 'shipped'
+    ELSE 'pending'
+END;
+
+ALTER TABLE order_store ALTER COLUMN status SET NOT NULL;
+
+CREATE INDEX order_store_status_idx ON order_store (status, created_at, order_id);
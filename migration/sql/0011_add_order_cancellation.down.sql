@@ -0,0 +1,3 @@
+ALTER TABLE order_store DROP COLUMN cancel_note;
+ALTER TABLE order_store DROP COLUMN cancel_reason;
+ALTER TABLE order_store DROP COLUMN cancelled_at;
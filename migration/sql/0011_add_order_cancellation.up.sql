@@ -0,0 +1,3 @@
+ALTER TABLE order_store ADD COLUMN cancelled_at TIMESTAMPTZ;
+ALTER TABLE order_store ADD COLUMN cancel_reason TEXT;
+ALTER TABLE order_store ADD COLUMN cancel_note TEXT;
@@ -0,0 +1,4 @@
+ALTER TABLE order_store DROP COLUMN currency;
+ALTER TABLE order_store DROP COLUMN total;
+ALTER TABLE order_store DROP COLUMN subtotal;
+ALTER TABLE line_item DROP COLUMN currency;
@@ -0,0 +1,18 @@
+-- Prices stored before they carried a currency were in USD.
+ALTER TABLE line_item ADD COLUMN currency TEXT NOT NULL DEFAULT 'USD';
+ALTER TABLE line_item ALTER COLUMN currency DROP DEFAULT;
+
+ALTER TABLE order_store ADD COLUMN subtotal BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE order_store ADD COLUMN total BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE order_store ADD COLUMN currency TEXT;
+
+UPDATE order_store SET subtotal = sums.amount, total = sums.amount, currency = sums.currency
+FROM (
+    SELECT order_id, SUM(quantity * price) AS amount, MIN(currency) AS currency
+    FROM line_item
+    GROUP BY order_id
+) AS sums
+WHERE order_store.order_id = sums.order_id;
+
+ALTER TABLE order_store ALTER COLUMN subtotal DROP DEFAULT;
+ALTER TABLE order_store ALTER COLUMN total DROP DEFAULT;
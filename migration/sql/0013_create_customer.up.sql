@@ -0,0 +1,7 @@
+CREATE TABLE customer (
+    customer_id UUID PRIMARY KEY,
+    name        TEXT        NOT NULL,
+    email       TEXT        NOT NULL,
+    created_at  TIMESTAMPTZ NOT NULL,
+    updated_at  TIMESTAMPTZ NOT NULL
+);
@@ -0,0 +1,9 @@
+CREATE TABLE catalog_item (
+    item_id    UUID PRIMARY KEY,
+    name       TEXT        NOT NULL,
+    price      BIGINT      NOT NULL,
+    currency   TEXT        NOT NULL,
+    active     BOOLEAN     NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL
+);
@@ -0,0 +1,4 @@
+CREATE TABLE inventory (
+    item_id   UUID PRIMARY KEY,
+    available BIGINT NOT NULL CHECK (available >= 0)
+);
@@ -0,0 +1 @@
+DROP INDEX order_outbox_dispatched_idx;
@@ -0,0 +1,2 @@
+-- The purge removes the events dispatched before the retention period.
+CREATE INDEX order_outbox_dispatched_idx ON order_outbox (dispatched_at) WHERE dispatched_at IS NOT NULL;
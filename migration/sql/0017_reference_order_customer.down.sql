@@ -0,0 +1,3 @@
+ALTER TABLE order_store DROP CONSTRAINT order_store_customer_id_fkey;
+UPDATE order_store SET customer_id = '00000000-0000-0000-0000-000000000000' WHERE customer_id IS NULL;
+ALTER TABLE order_store ALTER COLUMN customer_id SET NOT NULL;
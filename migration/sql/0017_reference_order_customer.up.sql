@@ -0,0 +1,8 @@
+-- Orders without a customer stored the nil uuid, they store NULL to satisfy the reference.
+ALTER TABLE order_store ALTER COLUMN customer_id DROP NOT NULL;
+UPDATE order_store SET customer_id = NULL WHERE customer_id = '00000000-0000-0000-0000-000000000000';
+
+-- Orders created before the customers existed may name unknown ones, only the new rows are checked.
+-- The reference still forbids deleting a customer named by an order.
+ALTER TABLE order_store ADD CONSTRAINT order_store_customer_id_fkey
+    FOREIGN KEY (customer_id) REFERENCES customer (customer_id) NOT VALID;
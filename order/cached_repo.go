@@ -0,0 +1,289 @@
+package order
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// CachedRepo serves reads from a redis cache in front of the Repo system of record.
+// Orders are cached by id on insert and read, and replaced by a tombstone on update, delete and restore.
+// Pages of FindAll are cached under a generation number that every write bumps,
+// so stale pages are never read again and expire with their TTL.
+type CachedRepo struct {
+	Repo   Repository
+	Client *redis.Client
+	// OrderTTL and PageTTL are the lifetimes of the cached orders and pages.
+	OrderTTL time.Duration
+	PageTTL  time.Duration
+
+	orderHits   atomic.Uint64
+	orderMisses atomic.Uint64
+	pageHits    atomic.Uint64
+	pageMisses  atomic.Uint64
+}
+
+// CacheStats counts the cache hits and misses since the start of the server.
+type CacheStats struct {
+	OrderHits   uint64 `json:"order_hits"`
+	OrderMisses uint64 `json:"order_misses"`
+	PageHits    uint64 `json:"page_hits"`
+	PageMisses  uint64 `json:"page_misses"`
+}
+
+const cacheGenerationKey = "cache:orders:generation"
+
+// cacheTombstoneTTL outlives the reads of the system of record in flight when an order is invalidated,
+// which must not cache the order they read before the write.
+const cacheTombstoneTTL = 30 * time.Second
+
+const cacheTombstonePrefix = "invalidated:"
+
+// cacheOrderScript caches an order unless the key holds one already, or a tombstone whose minimum version
+// is above the version of the order. A zero minimum version blocks every version.
+var cacheOrderScript = redis.NewScript(`
+local current = redis.call('GET', KEYS[1])
+if current then
+	local min = string.match(current, '^invalidated:(%d+)$')
+	if not min or tonumber(min) == 0 or tonumber(ARGV[2]) < tonumber(min) then
+		return 0
+	end
+end
+
+if ARGV[3] == '0' then
+	redis.call('SET', KEYS[1], ARGV[1])
+else
+	redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[3])
+end
+
+return 1
+`)
+
+func cachedOrderKey(id int64) string {
+	return fmt.Sprintf("cache:order:%d", id)
+}
+
+func cachedPageKey(generation int64, filter FindAllFilter, page FindAllPage) (string, error) {
+	data, err := json.Marshal(struct {
+		Filter FindAllFilter
+		Page   FindAllPage
+	}{filter, page})
+	if err != nil {
+		return "", fmt.Errorf("failed to encode page key: %w", err)
+	}
+
+	hash := sha256.Sum256(data)
+	return fmt.Sprintf("cache:orders:%d:%s", generation, hex.EncodeToString(hash[:])), nil
+}
+
+func (repo *CachedRepo) Stats() CacheStats {
+	return CacheStats{
+		OrderHits:   repo.orderHits.Load(),
+		OrderMisses: repo.orderMisses.Load(),
+		PageHits:    repo.pageHits.Load(),
+		PageMisses:  repo.pageMisses.Load(),
+	}
+}
+
+func (repo *CachedRepo) Insert(ctx context.Context, order Order) error {
+	if err := repo.Repo.Insert(ctx, order); err != nil {
+		return err
+	}
+
+	repo.cacheOrder(ctx, order)
+	repo.invalidatePages(ctx)
+
+	return nil
+}
+
+func (repo *CachedRepo) FindByID(ctx context.Context, id int64) (Order, error) {
+	// A tombstone is a miss, the order was written recently.
+	value, err := repo.Client.Get(ctx, cachedOrderKey(id)).Result()
+	if err == nil && !strings.HasPrefix(value, cacheTombstonePrefix) {
+		var order Order
+		if err := json.Unmarshal([]byte(value), &order); err == nil {
+			repo.orderHits.Add(1)
+			return order, nil
+		}
+		fmt.Println("failed to decode cached order:", err)
+	} else if err != nil && !errors.Is(err, redis.Nil) {
+		fmt.Println("failed to read cached order:", err)
+	}
+
+	repo.orderMisses.Add(1)
+
+	order, err := repo.Repo.FindByID(ctx, id)
+	if err != nil {
+		return Order{}, err
+	}
+
+	repo.cacheOrder(ctx, order)
+
+	return order, nil
+}
+
+func (repo *CachedRepo) DeleteByID(ctx context.Context, id int64, version int64) error {
+	err := repo.Repo.DeleteByID(ctx, id, version)
+
+	// The cache is dropped even on failure, the system of record may have changed anyway.
+	// Deleted orders are never cached, the tombstone blocks every version.
+	repo.invalidateOrder(ctx, id, 0)
+	repo.invalidatePages(ctx)
+
+	return err
+}
+
+func (repo *CachedRepo) Update(ctx context.Context, order Order) error {
+	err := repo.Repo.Update(ctx, order)
+
+	repo.invalidateOrder(ctx, order.OrderID, writtenVersion(order.Version, err))
+	repo.invalidatePages(ctx)
+
+	return err
+}
+
+func (repo *CachedRepo) FindAnyByID(ctx context.Context, id int64) (Order, error) {
+	// Soft deleted orders are never cached, they are rarely read.
+	return repo.Repo.FindAnyByID(ctx, id)
+}
+
+func (repo *CachedRepo) Restore(ctx context.Context, id int64, version int64) error {
+	err := repo.Repo.Restore(ctx, id, version)
+
+	repo.invalidateOrder(ctx, id, writtenVersion(version, err))
+	repo.invalidatePages(ctx)
+
+	return err
+}
+
+func (repo *CachedRepo) Purge(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	purged, err := repo.Repo.Purge(ctx, deletedBefore)
+
+	if purged > 0 {
+		repo.invalidatePages(ctx)
+	}
+
+	return purged, err
+}
+
+// SetStock and Stock forward to the inventory of the system of record, which its writes reserve from.
+func (repo *CachedRepo) SetStock(ctx context.Context, itemID uuid.UUID, available int64) error {
+	inventory, ok := repo.Repo.(Inventory)
+	if !ok {
+		return ErrNoInventory
+	}
+
+	return inventory.SetStock(ctx, itemID, available)
+}
+
+func (repo *CachedRepo) Stock(ctx context.Context, itemID uuid.UUID) (int64, bool, error) {
+	inventory, ok := repo.Repo.(Inventory)
+	if !ok {
+		return 0, false, ErrNoInventory
+	}
+
+	return inventory.Stock(ctx, itemID)
+}
+
+func (repo *CachedRepo) History(ctx context.Context, id int64) ([]Event, error) {
+	return repo.Repo.History(ctx, id)
+}
+
+func (repo *CachedRepo) FindAll(ctx context.Context, filter FindAllFilter, page FindAllPage) (FindResult, error) {
+	key, err := repo.pageKey(ctx, filter, page)
+	if err != nil {
+		fmt.Println("failed to compute cached page key:", err)
+		return repo.Repo.FindAll(ctx, filter, page)
+	}
+
+	value, err := repo.Client.Get(ctx, key).Result()
+	if err == nil {
+		var res FindResult
+		if err := json.Unmarshal([]byte(value), &res); err == nil {
+			repo.pageHits.Add(1)
+			return res, nil
+		}
+		fmt.Println("failed to decode cached page:", err)
+	} else if !errors.Is(err, redis.Nil) {
+		fmt.Println("failed to read cached page:", err)
+	}
+
+	repo.pageMisses.Add(1)
+
+	res, err := repo.Repo.FindAll(ctx, filter, page)
+	if err != nil {
+		return FindResult{}, err
+	}
+
+	data, err := json.Marshal(res)
+	if err != nil {
+		fmt.Println("failed to encode page:", err)
+		return res, nil
+	}
+
+	if err := repo.Client.Set(ctx, key, data, repo.PageTTL).Err(); err != nil {
+		fmt.Println("failed to cache page:", err)
+	}
+
+	return res, nil
+}
+
+func (repo *CachedRepo) pageKey(ctx context.Context, filter FindAllFilter, page FindAllPage) (string, error) {
+	generation, err := repo.Client.Get(ctx, cacheGenerationKey).Int64()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return "", fmt.Errorf("failed to read cache generation: %w", err)
+	}
+
+	return cachedPageKey(generation, filter, page)
+}
+
+// Cache failures are logged rather than returned, the system of record stays authoritative.
+
+// cacheOrder caches order unless a newer version was written since it was read.
+func (repo *CachedRepo) cacheOrder(ctx context.Context, order Order) {
+	data, err := json.Marshal(order)
+	if err != nil {
+		fmt.Println("failed to encode order:", err)
+		return
+	}
+
+	keys := []string{cachedOrderKey(order.OrderID)}
+	err = cacheOrderScript.Run(ctx, repo.Client, keys, string(data), order.Version, repo.OrderTTL.Milliseconds()).Err()
+	if err != nil {
+		fmt.Println("failed to cache order:", err)
+	}
+}
+
+// invalidateOrder replaces the cached order by a tombstone, which only lets versions from minVersion be cached.
+func (repo *CachedRepo) invalidateOrder(ctx context.Context, id int64, minVersion int64) {
+	tombstone := cacheTombstonePrefix + strconv.FormatInt(minVersion, 10)
+	if err := repo.Client.Set(ctx, cachedOrderKey(id), tombstone, cacheTombstoneTTL).Err(); err != nil {
+		fmt.Println("failed to invalidate cached order:", err)
+	}
+}
+
+// writtenVersion is the version stored by a successful write of version, zero when the write failed
+// or the version is unknown.
+func writtenVersion(version int64, err error) int64 {
+	if err != nil || version == 0 {
+		return 0
+	}
+
+	return version + 1
+}
+
+func (repo *CachedRepo) invalidatePages(ctx context.Context) {
+	if err := repo.Client.Incr(ctx, cacheGenerationKey).Err(); err != nil {
+		fmt.Println("failed to invalidate cached pages:", err)
+	}
+}
@@ -0,0 +1,82 @@
+package order_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"first-little-server/order"
+	"first-little-server/order/repotest"
+)
+
+func TestCachedRepo(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) order.Repository {
+		return &order.CachedRepo{
+			Repo:     &order.MemoryRepo{},
+			Client:   newRedisClient(t),
+			OrderTTL: time.Minute,
+			PageTTL:  time.Minute,
+		}
+	})
+}
+
+// racingRepo runs onFind once, after its first read and before returning it.
+type racingRepo struct {
+	*order.MemoryRepo
+	onFind func()
+}
+
+func (repo *racingRepo) FindByID(ctx context.Context, id int64) (order.Order, error) {
+	found, err := repo.MemoryRepo.FindByID(ctx, id)
+
+	if onFind := repo.onFind; onFind != nil {
+		repo.onFind = nil
+		onFind()
+	}
+
+	return found, err
+}
+
+func TestCachedRepoUpdateDuringRead(t *testing.T) {
+	ctx := context.Background()
+	inner := &racingRepo{MemoryRepo: &order.MemoryRepo{}}
+	repo := &order.CachedRepo{Repo: inner, Client: newRedisClient(t), OrderTTL: time.Minute, PageTTL: time.Minute}
+
+	created := repotest.NewOrder(1, 1)
+	if err := repo.Insert(ctx, created); err != nil {
+		t.Fatalf("Insert: %v", err)
+	}
+
+	// The update drops the cached order, the next read misses and races with another update.
+	paid := created
+	paid.Status = order.StatusPaid
+	if err := repo.Update(ctx, paid); err != nil {
+		t.Fatalf("Update to paid: %v", err)
+	}
+
+	inner.onFind = func() {
+		cancelled := paid
+		cancelled.Version++
+		cancelled.Status = order.StatusCancelled
+		cancelledAt := time.Now().UTC()
+		cancelled.CancelledAt = &cancelledAt
+		cancelled.CancelReason = &order.CancelReason{Code: order.CancelOther}
+		if err := repo.Update(ctx, cancelled); err != nil {
+			t.Fatalf("Update to cancelled: %v", err)
+		}
+	}
+
+	if _, err := repo.FindByID(ctx, created.OrderID); err != nil {
+		t.Fatalf("FindByID racing with the update: %v", err)
+	}
+
+	got, err := repo.FindByID(ctx, created.OrderID)
+	if err != nil {
+		t.Fatalf("FindByID: %v", err)
+	}
+
+	if got.Version != created.Version+2 || got.Status != order.StatusCancelled {
+		t.Errorf("FindByID after the race = version %d %s, want version %d %s",
+			got.Version, got.Status, created.Version+2, order.StatusCancelled)
+	}
+}
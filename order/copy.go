@@ -0,0 +1,260 @@
+package order
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// ConflictPolicy tells Copy what to do with an order whose id already exists in the destination.
+type ConflictPolicy string
+
+const (
+	ConflictSkip      ConflictPolicy = "skip"
+	ConflictOverwrite ConflictPolicy = "overwrite"
+	ConflictFail      ConflictPolicy = "fail"
+)
+
+var ErrConflictingOrder = errors.New("order already exists in the destination")
+
+// copyFilter lists every order, soft deleted and cancelled ones included.
+var copyFilter = FindAllFilter{Deleted: DeletedInclude, Cancelled: CancelledInclude}
+
+type CopyOptions struct {
+	PageSize uint
+	// Cursor resumes the copy after the page it designates, it is empty to copy from the start.
+	Cursor     string
+	DryRun     bool
+	OnConflict ConflictPolicy
+	// OnPage is called after every copied page with the cursor resuming after it.
+	// It is the place to save a checkpoint, an error stops the copy.
+	OnPage func(cursor string, report CopyReport) error
+}
+
+type CopyReport struct {
+	Read        uint64 `json:"read"`
+	Inserted    uint64 `json:"inserted"`
+	Skipped     uint64 `json:"skipped"`
+	Overwritten uint64 `json:"overwritten"`
+}
+
+// Copy streams every order of src into dst, soft deleted ones included, page after page in creation order.
+// The writes leave the stock of dst alone, the copied orders hold it in src already.
+// In dry run mode dst is only read, the report tells what would have been written.
+func Copy(ctx context.Context, src, dst Repository, opts CopyOptions) (CopyReport, error) {
+	var report CopyReport
+	page := FindAllPage{Size: opts.PageSize, Cursor: opts.Cursor}
+
+	for {
+		res, err := src.FindAll(ctx, copyFilter, page)
+		if err != nil {
+			return report, fmt.Errorf("failed to read source page: %w", err)
+		}
+
+		for _, order := range res.Orders {
+			report.Read++
+
+			if err := copyOrderTo(ctx, dst, order, opts, &report); err != nil {
+				return report, err
+			}
+		}
+
+		if opts.OnPage != nil {
+			if err := opts.OnPage(res.Cursor, report); err != nil {
+				return report, err
+			}
+		}
+
+		if res.Cursor == "" {
+			return report, nil
+		}
+		page.Cursor = res.Cursor
+	}
+}
+
+func copyOrderTo(ctx context.Context, dst Repository, order Order, opts CopyOptions, report *CopyReport) error {
+	// Soft deleted orders of the destination still hold their id.
+	existing, err := dst.FindAnyByID(ctx, order.OrderID)
+	if errors.Is(err, ErrNotExist) {
+		report.Inserted++
+		if opts.DryRun {
+			return nil
+		}
+
+		if err := dst.Insert(skipTransitions(ctx), order); err != nil {
+			return fmt.Errorf("failed to insert order %d: %w", order.OrderID, err)
+		}
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to look up order %d in the destination: %w", order.OrderID, err)
+	}
+
+	switch opts.OnConflict {
+	case ConflictOverwrite:
+		report.Overwritten++
+		if opts.DryRun {
+			return nil
+		}
+
+		if existing.DeletedAt != nil {
+			if err := dst.Restore(skipTransitions(ctx), order.OrderID, existing.Version); err != nil {
+				return fmt.Errorf("failed to restore order %d: %w", order.OrderID, err)
+			}
+			existing.Version++
+		}
+
+		// The destination keeps counting versions from its own, and takes the status of the source as is.
+		order.Version = existing.Version
+		if err := dst.Update(skipTransitions(ctx), order); err != nil {
+			return fmt.Errorf("failed to overwrite order %d: %w", order.OrderID, err)
+		}
+
+		// Update never deletes, the tombstone of the source is set again.
+		if order.DeletedAt != nil {
+			if err := dst.DeleteByID(skipTransitions(ctx), order.OrderID, order.Version+1); err != nil {
+				return fmt.Errorf("failed to delete order %d: %w", order.OrderID, err)
+			}
+		}
+		return nil
+	case ConflictFail:
+		return fmt.Errorf("order %d: %w", order.OrderID, ErrConflictingOrder)
+	default:
+		report.Skipped++
+		return nil
+	}
+}
+
+type VerifyReport struct {
+	SourceCount      uint64 `json:"source_count"`
+	DestinationCount uint64 `json:"destination_count"`
+	SourceChecksum   string `json:"source_checksum"`
+	// DestinationChecksum only covers the orders whose id exists in the source,
+	// so that it equals SourceChecksum when every source order was copied as is.
+	DestinationChecksum string  `json:"destination_checksum"`
+	Missing             []int64 `json:"missing,omitempty"`
+	Different           []int64 `json:"different,omitempty"`
+}
+
+// Match reports whether every source order exists unchanged in the destination.
+func (r VerifyReport) Match() bool {
+	return len(r.Missing) == 0 && len(r.Different) == 0 && r.SourceChecksum == r.DestinationChecksum
+}
+
+// maxReportedIDs bounds the ids listed in a VerifyReport.
+const maxReportedIDs = 100
+
+// Verify compares the orders of src and dst, soft deleted ones included, by count and checksum.
+func Verify(ctx context.Context, src, dst Repository, pageSize uint) (VerifyReport, error) {
+	var report VerifyReport
+
+	sourceSums := make(map[int64]string)
+	err := walkAll(ctx, src, pageSize, func(order Order) {
+		report.SourceCount++
+		sourceSums[order.OrderID] = orderChecksum(order)
+	})
+	if err != nil {
+		return VerifyReport{}, fmt.Errorf("failed to read source: %w", err)
+	}
+
+	destinationSums := make(map[int64]string)
+	err = walkAll(ctx, dst, pageSize, func(order Order) {
+		report.DestinationCount++
+		if _, exist := sourceSums[order.OrderID]; exist {
+			destinationSums[order.OrderID] = orderChecksum(order)
+		}
+	})
+	if err != nil {
+		return VerifyReport{}, fmt.Errorf("failed to read destination: %w", err)
+	}
+
+	for id, sum := range sourceSums {
+		destinationSum, exist := destinationSums[id]
+		if !exist && len(report.Missing) < maxReportedIDs {
+			report.Missing = append(report.Missing, id)
+		} else if exist && destinationSum != sum && len(report.Different) < maxReportedIDs {
+			report.Different = append(report.Different, id)
+		}
+	}
+
+	sort.Slice(report.Missing, func(i, j int) bool { return report.Missing[i] < report.Missing[j] })
+	sort.Slice(report.Different, func(i, j int) bool { return report.Different[i] < report.Different[j] })
+
+	report.SourceChecksum = combineChecksums(sourceSums)
+	report.DestinationChecksum = combineChecksums(destinationSums)
+
+	return report, nil
+}
+
+func walkAll(ctx context.Context, repo Repository, pageSize uint, fn func(Order)) error {
+	page := FindAllPage{Size: pageSize}
+
+	for {
+		res, err := repo.FindAll(ctx, copyFilter, page)
+		if err != nil {
+			return err
+		}
+
+		for _, order := range res.Orders {
+			fn(order)
+		}
+
+		if res.Cursor == "" {
+			return nil
+		}
+		page.Cursor = res.Cursor
+	}
+}
+
+// orderChecksum hashes the content of an order independently of the backend representation:
+// timestamps are taken to the microsecond and line items are sorted.
+func orderChecksum(order Order) string {
+	hash := sha256.New()
+
+	_, _ = fmt.Fprintf(hash, "%d|%s|%s", order.OrderID, order.CustomerID, order.Status)
+	for _, t := range []*time.Time{order.CreatedAt, order.ShippedAt, order.CompletedAt, order.CancelledAt} {
+		micro := "-"
+		if t != nil {
+			micro = strconv.FormatInt(t.UnixMicro(), 10)
+		}
+		_, _ = fmt.Fprintf(hash, "|%s", micro)
+	}
+
+	if order.CancelReason != nil {
+		_, _ = fmt.Fprintf(hash, "|%s:%s", order.CancelReason.Code, order.CancelReason.Note)
+	}
+
+	// Only the deletion is compared, an overwrite deletes the order again at its own time.
+	_, _ = fmt.Fprintf(hash, "|deleted:%t", order.DeletedAt != nil)
+
+	items := make([]LineItem, len(order.LineItems))
+	copy(items, order.LineItems)
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].ItemID.String() < items[j].ItemID.String()
+	})
+
+	for _, item := range items {
+		_, _ = fmt.Fprintf(hash, "|%s:%d:%d:%s", item.ItemID, item.Quantity, item.Price.Amount, item.Price.Currency)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func combineChecksums(sums map[int64]string) string {
+	ids := make([]int64, 0, len(sums))
+	for id := range sums {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	hash := sha256.New()
+	for _, id := range ids {
+		_, _ = fmt.Fprintf(hash, "%d=%s\n", id, sums[id])
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
@@ -0,0 +1,66 @@
+package order_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"first-little-server/order"
+	"first-little-server/order/repotest"
+)
+
+func TestCopyDeletedOrders(t *testing.T) {
+	ctx := context.Background()
+	src := &order.MemoryRepo{}
+	dst := &order.MemoryRepo{}
+
+	live := repotest.NewOrder(1, 1)
+	deleted := repotest.NewOrder(2, 1)
+	overwritten := repotest.NewOrder(3, 1)
+	for _, o := range []order.Order{live, deleted, overwritten} {
+		if err := src.Insert(ctx, o); err != nil {
+			t.Fatalf("Insert(%d) in the source: %v", o.OrderID, err)
+		}
+	}
+
+	for _, id := range []int64{deleted.OrderID, overwritten.OrderID} {
+		if err := src.DeleteByID(ctx, id, 0); err != nil {
+			t.Fatalf("DeleteByID(%d) in the source: %v", id, err)
+		}
+	}
+
+	// The destination has a live version of the third order, and no stock left for the items
+	// the copied orders hold in the source.
+	if err := dst.Insert(ctx, overwritten); err != nil {
+		t.Fatalf("Insert(%d) in the destination: %v", overwritten.OrderID, err)
+	}
+	for _, o := range []order.Order{live, deleted, overwritten} {
+		if err := dst.SetStock(ctx, o.LineItems[0].ItemID, 0); err != nil {
+			t.Fatalf("SetStock: %v", err)
+		}
+	}
+
+	report, err := order.Copy(ctx, src, dst, order.CopyOptions{PageSize: 2, OnConflict: order.ConflictOverwrite})
+	if err != nil {
+		t.Fatalf("Copy: %v", err)
+	}
+
+	if report.Read != 3 || report.Inserted != 2 || report.Overwritten != 1 {
+		t.Errorf("Copy report = %+v, want 3 read, 2 inserted and 1 overwritten", report)
+	}
+
+	for _, id := range []int64{deleted.OrderID, overwritten.OrderID} {
+		if _, err := dst.FindByID(ctx, id); !errors.Is(err, order.ErrNotExist) {
+			t.Errorf("FindByID(%d) in the destination returned %v, want %v", id, err, order.ErrNotExist)
+		}
+	}
+
+	verify, err := order.Verify(ctx, src, dst, 2)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+
+	if !verify.Match() || verify.SourceCount != 3 {
+		t.Errorf("Verify = %+v, want a match of 3 orders", verify)
+	}
+}
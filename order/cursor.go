@@ -0,0 +1,103 @@
+package order
+
+import (
+	"encoding/base64"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// keysetCursor is the position of the last order of a page.
+type keysetCursor struct {
+	createdAt time.Time
+	orderID   int64
+}
+
+func (c keysetCursor) encode() string {
+	raw := strconv.FormatInt(c.createdAt.UnixMicro(), 10) + ":" + strconv.FormatInt(c.orderID, 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeKeysetCursor(cursor string) (keysetCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return keysetCursor{}, ErrInvalidCursor
+	}
+
+	createdAtStr, orderIDStr, found := strings.Cut(string(raw), ":")
+	if !found {
+		return keysetCursor{}, ErrInvalidCursor
+	}
+
+	const decimal = 10
+	const bitSize = 64
+	createdAt, err := strconv.ParseInt(createdAtStr, decimal, bitSize)
+	if err != nil {
+		return keysetCursor{}, ErrInvalidCursor
+	}
+	orderID, err := strconv.ParseInt(orderIDStr, decimal, bitSize)
+	if err != nil {
+		return keysetCursor{}, ErrInvalidCursor
+	}
+
+	return keysetCursor{createdAt: time.UnixMicro(createdAt).UTC(), orderID: orderID}, nil
+}
+
+func cursorOf(order Order) keysetCursor {
+	var createdAt time.Time
+	if order.CreatedAt != nil {
+		createdAt = order.CreatedAt.UTC().Truncate(time.Microsecond)
+	}
+
+	return keysetCursor{createdAt: createdAt, orderID: order.OrderID}
+}
+
+// before reports whether the position c comes before other in ascending (created_at, order_id) order.
+func (c keysetCursor) before(other keysetCursor) bool {
+	if !c.createdAt.Equal(other.createdAt) {
+		return c.createdAt.Before(other.createdAt)
+	}
+
+	return c.orderID < other.orderID
+}
+
+// paginate sorts orders and returns the page following the cursor of page.
+// It serves backends that cannot sort and seek natively.
+func paginate(orders []Order, page FindAllPage) (FindResult, error) {
+	descending := page.Sort == SortCreatedDesc
+
+	sort.Slice(orders, func(i, j int) bool {
+		if descending {
+			return cursorOf(orders[j]).before(cursorOf(orders[i]))
+		}
+		return cursorOf(orders[i]).before(cursorOf(orders[j]))
+	})
+
+	start := 0
+	if page.Cursor != "" {
+		cursor, err := decodeKeysetCursor(page.Cursor)
+		if err != nil {
+			return FindResult{}, err
+		}
+
+		start = sort.Search(len(orders), func(i int) bool {
+			if descending {
+				return cursorOf(orders[i]).before(cursor)
+			}
+			return cursor.before(cursorOf(orders[i]))
+		})
+	}
+
+	end := start + int(page.Size)
+	if end > len(orders) {
+		end = len(orders)
+	}
+
+	var cursor string
+	if end < len(orders) && end > start {
+		cursor = cursorOf(orders[end-1]).encode()
+	}
+
+	return FindResult{Orders: orders[start:end], Cursor: cursor}, nil
+}
@@ -0,0 +1,113 @@
+package order
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// FindAllFilter restricts the orders returned by FindAll, zero fields do not filter.
+// Time ranges include their lower bound and exclude their upper bound.
+type FindAllFilter struct {
+	CustomerID    uuid.UUID
+	Status        Status
+	ItemID        uuid.UUID
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	ShippedAfter  *time.Time
+	ShippedBefore *time.Time
+	Deleted       DeletedFilter
+	Cancelled     CancelledFilter
+}
+
+// DeletedFilter tells whether soft deleted orders are listed, they are hidden by default.
+type DeletedFilter string
+
+const (
+	DeletedExclude DeletedFilter = ""
+	DeletedInclude DeletedFilter = "include"
+	DeletedOnly    DeletedFilter = "only"
+)
+
+// CancelledFilter tells whether cancelled orders are listed. They are hidden by default,
+// unless the Status of the filter asks for them.
+type CancelledFilter string
+
+const (
+	CancelledExclude CancelledFilter = ""
+	CancelledInclude CancelledFilter = "include"
+)
+
+// excludesCancelled tells whether the filter hides the cancelled orders.
+func (f FindAllFilter) excludesCancelled() bool {
+	return f.Cancelled == CancelledExclude && f.Status == ""
+}
+
+type SortOrder string
+
+const (
+	SortCreatedAsc  SortOrder = "created_at"
+	SortCreatedDesc SortOrder = "-created_at"
+)
+
+func (f FindAllFilter) matches(order Order) bool {
+	switch f.Deleted {
+	case DeletedExclude:
+		if order.DeletedAt != nil {
+			return false
+		}
+	case DeletedOnly:
+		if order.DeletedAt == nil {
+			return false
+		}
+	}
+
+	if f.CustomerID != uuid.Nil && order.CustomerID != f.CustomerID {
+		return false
+	}
+
+	if f.Status != "" && order.Status != f.Status {
+		return false
+	}
+
+	if f.excludesCancelled() && order.Status == StatusCancelled {
+		return false
+	}
+
+	if f.ItemID != uuid.Nil && !containsItem(order, f.ItemID) {
+		return false
+	}
+
+	if !inRange(order.CreatedAt, f.CreatedAfter, f.CreatedBefore) {
+		return false
+	}
+
+	return inRange(order.ShippedAt, f.ShippedAfter, f.ShippedBefore)
+}
+
+func containsItem(order Order, itemID uuid.UUID) bool {
+	for _, item := range order.LineItems {
+		if item.ItemID == itemID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// inRange reports whether t is within [after, before). A nil t is only in an unbounded range.
+func inRange(t, after, before *time.Time) bool {
+	if after == nil && before == nil {
+		return true
+	}
+
+	if t == nil {
+		return false
+	}
+
+	if after != nil && t.Before(*after) {
+		return false
+	}
+
+	return before == nil || t.Before(*before)
+}
@@ -5,9 +5,11 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"math/rand"
+	"io"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
@@ -16,48 +18,230 @@ import (
 
 type Handler struct {
 	Repo Repository
+	// IDs generates the ids of the created orders.
+	IDs IDGenerator
+	// States are the status transitions of the orders, the default ones when nil.
+	States *StateMachine
+	// Hub receives the events of the writes and serves the event streams, which are disabled when nil.
+	Hub *Hub
+	// Heartbeat is the interval of the comments keeping idle event streams open.
+	Heartbeat time.Duration
+	// Idempotency keeps the Idempotency-Key of the creations for IdempotencyTTL, the header is ignored when nil.
+	Idempotency    IdempotencyStore
+	IdempotencyTTL time.Duration
+	// Customers checks that the customer of a created order exists, any customer but the nil uuid is accepted when nil.
+	Customers CustomerChecker
+	// Catalog prices the line items of the orders, the prices sent by the clients are trusted when nil.
+	Catalog PriceCatalog
 }
 
+// PriceCatalog returns the unit prices of the items which may be ordered.
+type PriceCatalog interface {
+	// Prices returns the prices of the active items among itemIDs, the unknown and inactive items are left out.
+	Prices(ctx context.Context, itemIDs []uuid.UUID) (map[uuid.UUID]Money, error)
+}
+
+// CustomerChecker tells whether a customer exists.
+type CustomerChecker interface {
+	Exists(ctx context.Context, id uuid.UUID) (bool, error)
+}
+
+// Repository stores the orders. Its writes reserve and release the stock of the line items in their transaction,
+// and return an InsufficientStockError when the stock of a tracked item runs short.
 type Repository interface {
+	// Insert returns ErrAlreadyExists when an order, soft deleted or not, has the same id,
+	// and ErrUnknownCustomer when the backend references the customers and the customer of the order does not exist.
 	Insert(ctx context.Context, order Order) error
 	FindByID(ctx context.Context, id int64) (Order, error)
-	DeleteByID(ctx context.Context, id int64) error
+	// DeleteByID soft deletes the order when its stored version equals version, or whatever its version when zero.
+	// It returns ErrConflict when the versions differ.
+	DeleteByID(ctx context.Context, id int64, version int64) error
+	// Update replaces the order when its stored version equals order.Version and stores it with the next version.
+	// It returns ErrConflict when the versions differ.
 	Update(ctx context.Context, order Order) error
-	FindAll(ctx context.Context, page FindAllPage) (FindResult, error)
+	FindAll(ctx context.Context, filter FindAllFilter, page FindAllPage) (FindResult, error)
+	// FindAnyByID returns the order even when it is soft deleted, FindByID hides such orders.
+	FindAnyByID(ctx context.Context, id int64) (Order, error)
+	// Restore clears the tombstone of a soft deleted order when its version equals version, or whatever
+	// its version when zero. It returns ErrNotDeleted when the order is not deleted.
+	Restore(ctx context.Context, id int64, version int64) error
+	// Purge permanently removes the orders soft deleted before deletedBefore and returns their number.
+	Purge(ctx context.Context, deletedBefore time.Time) (int64, error)
+	// History returns the events of the order, soft deleted or not, oldest first.
+	History(ctx context.Context, id int64) ([]Event, error)
 }
 
+// FindAllPage requests a page of orders.
+// Cursor is the opaque value returned by the previous page, it is empty for the first page.
+// Orders are sorted by ascending creation time unless Sort says otherwise.
 type FindAllPage struct {
 	Size   uint
-	Offset uint64
+	Cursor string
+	Sort   SortOrder
 }
 
+// FindResult holds a page of orders and the cursor of the next page.
+// An empty cursor means there is no more data.
 type FindResult struct {
 	Orders []Order
-	Cursor uint64
+	Cursor string
 }
 
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+var ErrConflict = errors.New("order has been modified concurrently")
+
+var ErrAlreadyExists = errors.New("order already exists")
+
+var ErrNotDeleted = errors.New("order is not deleted")
+
+// ErrUnknownCustomer is returned by the repositories checking that the customer of an inserted order exists.
+var ErrUnknownCustomer = errors.New("customer does not exist")
+
 func (h *Handler) Create(w http.ResponseWriter, r *http.Request) {
 	var body struct {
-		CustomerID uuid.UUID  `json:"customer_id"`
+		// CustomerID is absent or null for an order without a customer.
+		CustomerID *uuid.UUID `json:"customer_id"`
 		LineItems  []LineItem `json:"line_items"`
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+	raw, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if err := json.Unmarshal(raw, &body); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Retries with the same idempotency key get the response of the first request instead of a new order.
+	// The key is reserved before validation so that concurrent retries of a rejected request get the same response.
+	var key string
+	if h.Idempotency != nil {
+		key = r.Header.Get(IdempotencyKeyHeader)
+	}
+
+	requestFingerprint := fingerprint(raw)
+	if key != "" {
+		if len(key) > maxIdempotencyKeyLength {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		replay, err := reserveIdempotencyKey(r.Context(), h.Idempotency, key, requestFingerprint, h.IdempotencyTTL)
+		switch {
+		case errors.Is(err, ErrIdempotencyMismatch):
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			return
+		case errors.Is(err, ErrIdempotencyInProgress):
+			w.WriteHeader(http.StatusConflict)
+			return
+		case err != nil:
+			fmt.Println("failed to reserve idempotency key:", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		case replay != nil:
+			w.Header().Set("ETag", replay.ETag)
+			w.WriteHeader(replay.StatusCode)
+			_, _ = w.Write(replay.Body)
+			return
+		}
+	}
+
+	// Each item appears on a single line item, whose stock, edits and removal it names.
+	if duplicates := duplicateItemIDs(body.LineItems); len(duplicates) > 0 {
+		h.releaseIdempotencyKey(r.Context(), key)
+		writeItemsError(w, http.StatusUnprocessableEntity, "duplicate items", duplicates)
+		return
+	}
+
+	if h.Catalog != nil {
+		unavailable, err := snapshotPrices(r.Context(), h.Catalog, body.LineItems)
+		if err != nil {
+			fmt.Println("failed to price line items:", err)
+			h.releaseIdempotencyKey(r.Context(), key)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		if len(unavailable) > 0 {
+			h.releaseIdempotencyKey(r.Context(), key)
+			writeItemsError(w, http.StatusUnprocessableEntity, "unknown or inactive items", unavailable)
+			return
+		}
+	}
+
+	for _, item := range body.LineItems {
+		if err := validatePrice(item); err != nil {
+			fmt.Println("invalid line item:", err)
+			h.releaseIdempotencyKey(r.Context(), key)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Orders may be created without a customer, which they then need to be paid.
+	// The nil uuid names no customer, it is an unknown one rather than a way to leave the customer out.
+	var customerID uuid.UUID
+	if body.CustomerID != nil {
+		customerID = *body.CustomerID
+
+		exist := customerID != uuid.Nil
+		if exist && h.Customers != nil {
+			exist, err = h.Customers.Exists(r.Context(), customerID)
+			if err != nil {
+				fmt.Println("failed to check customer:", err)
+				h.releaseIdempotencyKey(r.Context(), key)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+		}
+
+		if !exist {
+			fmt.Println("unknown customer:", customerID)
+			h.releaseIdempotencyKey(r.Context(), key)
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			return
+		}
+	}
+
+	// Orders are priced in a single currency.
+	priced := Order{LineItems: body.LineItems}
+	if err := priced.ComputeTotals(); err != nil {
+		fmt.Println("invalid line items:", err)
+		h.releaseIdempotencyKey(r.Context(), key)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
 	now := time.Now().UTC()
 	createdOrder := Order{
-		OrderID:    rand.Int63(),
-		CustomerID: body.CustomerID,
+		CustomerID: customerID,
+		Status:     h.states().Initial,
 		LineItems:  body.LineItems,
+		Subtotal:   priced.Subtotal,
+		Total:      priced.Total,
 		CreatedAt:  &now,
+		Version:    1,
 	}
 
-	err := h.Repo.Insert(r.Context(), createdOrder)
-	if err != nil {
+	err = h.insertWithNewID(r.Context(), &createdOrder)
+	var insufficient *InsufficientStockError
+	if errors.As(err, &insufficient) {
+		h.releaseIdempotencyKey(r.Context(), key)
+		writeInsufficientStock(w, insufficient)
+		return
+	} else if errors.Is(err, ErrUnknownCustomer) {
+		// The customer was deleted since it was checked.
+		fmt.Println("unknown customer:", customerID)
+		h.releaseIdempotencyKey(r.Context(), key)
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		return
+	} else if err != nil {
 		fmt.Println("failed to insert:", err)
+		h.releaseIdempotencyKey(r.Context(), key)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
@@ -65,32 +249,163 @@ func (h *Handler) Create(w http.ResponseWriter, r *http.Request) {
 	res, err := json.Marshal(createdOrder)
 	if err != nil {
 		fmt.Println("failed to marshal:", err)
+		h.releaseIdempotencyKey(r.Context(), key)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	_, _ = w.Write(res)
+	h.publish(OrderCreated, createdOrder)
+
+	if key != "" {
+		record := IdempotencyRecord{
+			Fingerprint: requestFingerprint,
+			StatusCode:  http.StatusCreated,
+			ETag:        etag(createdOrder.Version),
+			Body:        res,
+		}
+
+		// The order exists whatever happens to the key, a failure only loses the replay.
+		// The key is completed even when the client went away, its retry is then replayed.
+		if err := h.Idempotency.Complete(context.WithoutCancel(r.Context()), key, record); err != nil {
+			fmt.Println("failed to complete idempotency key:", err)
+		}
+	}
+
+	w.Header().Set("ETag", etag(createdOrder.Version))
 	w.WriteHeader(http.StatusCreated)
+	_, _ = w.Write(res)
 }
 
-func (h *Handler) List(w http.ResponseWriter, r *http.Request) {
-	cursorStr := r.URL.Query().Get("cursor")
-	if cursorStr == "" {
-		cursorStr = "0"
+// maxIDAttempts bounds the ids tried for an order, the ids of a generator only collide with the ids
+// of another server misconfigured with the same node id.
+const maxIDAttempts = 3
+
+// ErrNoIDGenerator is returned by the creations of a handler built without IDs.
+var ErrNoIDGenerator = errors.New("order handler has no id generator")
+
+// insertWithNewID inserts order under a new id, with another one when the id is already taken.
+func (h *Handler) insertWithNewID(ctx context.Context, order *Order) error {
+	if h.IDs == nil {
+		return ErrNoIDGenerator
 	}
 
-	const decimal = 10
-	const bitSize = 64
-	cursor, err := strconv.ParseUint(cursorStr, decimal, bitSize)
+	for i := 0; i < maxIDAttempts; i++ {
+		id, err := h.IDs.NewID()
+		if err != nil {
+			return fmt.Errorf("failed to generate order id: %w", err)
+		}
+		order.OrderID = id
+
+		err = h.Repo.Insert(ctx, *order)
+		if !errors.Is(err, ErrAlreadyExists) {
+			return err
+		}
+
+		fmt.Printf("order id %d is already taken, check that the node ids are distinct\n", id)
+	}
+
+	return fmt.Errorf("no free order id after %d attempts: %w", maxIDAttempts, ErrAlreadyExists)
+}
+
+// releaseIdempotencyKey frees the key of a failed creation, if any, so that the client can retry.
+// The failure may come from the client going away, the key is released regardless.
+func (h *Handler) releaseIdempotencyKey(ctx context.Context, key string) {
+	if key == "" {
+		return
+	}
+
+	if err := h.Idempotency.Release(context.WithoutCancel(ctx), key); err != nil {
+		fmt.Println("failed to release idempotency key:", err)
+	}
+}
+
+// parseListQuery reads the filter and sort order of a listing from the query parameters.
+func parseListQuery(query url.Values, states *StateMachine) (FindAllFilter, SortOrder, error) {
+	var filter FindAllFilter
+
+	if customerID := query.Get("customer_id"); customerID != "" {
+		parsed, err := uuid.Parse(customerID)
+		if err != nil {
+			return FindAllFilter{}, "", fmt.Errorf("invalid customer_id: %w", err)
+		}
+		filter.CustomerID = parsed
+	}
+
+	if itemID := query.Get("item_id"); itemID != "" {
+		parsed, err := uuid.Parse(itemID)
+		if err != nil {
+			return FindAllFilter{}, "", fmt.Errorf("invalid item_id: %w", err)
+		}
+		filter.ItemID = parsed
+	}
+
+	if status := Status(query.Get("status")); status != "" {
+		if !states.Known(status) {
+			return FindAllFilter{}, "", fmt.Errorf("invalid status %q", status)
+		}
+		filter.Status = status
+	}
+
+	timeParams := []struct {
+		name  string
+		field **time.Time
+	}{
+		{"created_after", &filter.CreatedAfter},
+		{"created_before", &filter.CreatedBefore},
+		{"shipped_after", &filter.ShippedAfter},
+		{"shipped_before", &filter.ShippedBefore},
+	}
+	for _, param := range timeParams {
+		if value := query.Get(param.name); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return FindAllFilter{}, "", fmt.Errorf("invalid %s: %w", param.name, err)
+			}
+			*param.field = &parsed
+		}
+	}
+
+	switch deleted := DeletedFilter(query.Get("deleted")); deleted {
+	case DeletedExclude, DeletedInclude, DeletedOnly:
+		filter.Deleted = deleted
+	default:
+		return FindAllFilter{}, "", fmt.Errorf("invalid deleted %q", deleted)
+	}
+
+	// Cancelled orders are hidden unless asked for, by their status or this parameter.
+	switch cancelled := CancelledFilter(query.Get("cancelled")); cancelled {
+	case CancelledExclude, CancelledInclude:
+		filter.Cancelled = cancelled
+	default:
+		return FindAllFilter{}, "", fmt.Errorf("invalid cancelled %q", cancelled)
+	}
+
+	sortOrder := SortOrder(query.Get("sort"))
+	switch sortOrder {
+	case "", SortCreatedAsc, SortCreatedDesc:
+	default:
+		return FindAllFilter{}, "", fmt.Errorf("invalid sort %q", sortOrder)
+	}
+
+	return filter, sortOrder, nil
+}
+
+func (h *Handler) List(w http.ResponseWriter, r *http.Request) {
+	cursor := r.URL.Query().Get("cursor")
+
+	filter, sortOrder, err := parseListQuery(r.URL.Query(), h.states())
 	if err != nil {
-		fmt.Println("failed to parse cursor:", err)
+		fmt.Println("failed to parse query:", err)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
 	const size = 50
-	res, err := h.Repo.FindAll(r.Context(), FindAllPage{Size: size, Offset: cursor})
-	if err != nil {
+	res, err := h.Repo.FindAll(r.Context(), filter, FindAllPage{Size: size, Cursor: cursor, Sort: sortOrder})
+	if errors.Is(err, ErrInvalidCursor) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	} else if err != nil {
 		fmt.Println("failed to find:", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
@@ -98,7 +413,7 @@ func (h *Handler) List(w http.ResponseWriter, r *http.Request) {
 
 	var response struct {
 		Items []Order `json:"items"`
-		Next  uint64  `json:"next,omitempty"`
+		Next  string  `json:"next,omitempty"`
 	}
 	response.Items = res.Orders
 	response.Next = res.Cursor
@@ -126,7 +441,18 @@ func (h *Handler) GetByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	found, err := h.Repo.FindByID(r.Context(), orderID)
+	// Soft deleted orders are only returned on demand.
+	find := h.Repo.FindByID
+	switch DeletedFilter(r.URL.Query().Get("deleted")) {
+	case DeletedExclude:
+	case DeletedInclude:
+		find = h.Repo.FindAnyByID
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	found, err := find(r.Context(), orderID)
 	if errors.Is(err, ErrNotExist) {
 		w.WriteHeader(http.StatusNotFound)
 		return
@@ -136,6 +462,7 @@ func (h *Handler) GetByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	w.Header().Set("ETag", etag(found.Version))
 	if err := json.NewEncoder(w).Encode(found); err != nil {
 		fmt.Println("failed to marshal:", err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -154,6 +481,22 @@ func (h *Handler) UpdateByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Every cancellation has a reason, which only CancelByID takes.
+	if Status(body.Status) == StatusCancelled {
+		response := struct {
+			Error string `json:"error"`
+		}{
+			Error: "orders are cancelled with POST /orders/{id}/cancel and a reason",
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			fmt.Println("failed to marshal:", err)
+		}
+		return
+	}
+
 	idParam := chi.URLParam(r, "id")
 	const base = 10
 	const bitSize = 64
@@ -175,43 +518,349 @@ func (h *Handler) UpdateByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	const completedStatus = "completed"
-	const shippedStatus = "shipped"
-	now := time.Now().UTC()
+	if !ifMatch(r, toUpdate.Version) {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	}
 
-	switch body.Status {
-	case completedStatus:
-		if toUpdate.CompletedAt != nil || toUpdate.ShippedAt == nil {
-			w.WriteHeader(http.StatusBadRequest)
+	from := toUpdate.Status
+	if err := h.states().Apply(&toUpdate, Status(body.Status), time.Now().UTC()); err != nil {
+		h.writeInvalidTransition(w, from, Status(body.Status), err)
+		return
+	}
+
+	err = h.Repo.Update(r.Context(), toUpdate)
+	var insufficient *InsufficientStockError
+	if errors.Is(err, ErrConflict) {
+		w.WriteHeader(conflictStatus(r))
+		return
+	} else if errors.Is(err, ErrInvalidTransition) {
+		h.writeInvalidTransition(w, from, toUpdate.Status, err)
+		return
+	} else if errors.As(err, &insufficient) {
+		writeInsufficientStock(w, insufficient)
+		return
+	} else if errors.Is(err, ErrNotExist) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		fmt.Println("failed to update:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	toUpdate.Version++
+	h.publish(statusEventType(toUpdate.Status), toUpdate)
+
+	w.Header().Set("ETag", etag(toUpdate.Version))
+	if err := json.NewEncoder(w).Encode(toUpdate); err != nil {
+		fmt.Println("failed to marshal:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// CancelByID cancels an order with a reason, which the state machine only allows before shipment.
+func (h *Handler) CancelByID(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Reason CancelReasonCode `json:"reason"`
+		Note   string           `json:"note"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		fmt.Println("failed to parse body:", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if !body.Reason.Valid() {
+		fmt.Println("invalid cancel reason:", body.Reason)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	idParam := chi.URLParam(r, "id")
+	const base = 10
+	const bitSize = 64
+
+	orderID, err := strconv.ParseInt(idParam, base, bitSize)
+	if err != nil {
+		fmt.Println("failed to parse id:", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	toCancel, err := h.Repo.FindByID(r.Context(), orderID)
+	if errors.Is(err, ErrNotExist) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		fmt.Println("failed to find by id:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if !ifMatch(r, toCancel.Version) {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	}
+
+	from := toCancel.Status
+	if err := h.states().Apply(&toCancel, StatusCancelled, time.Now().UTC()); err != nil {
+		h.writeInvalidTransition(w, from, StatusCancelled, err)
+		return
+	}
+	toCancel.CancelReason = &CancelReason{Code: body.Reason, Note: body.Note}
+
+	err = h.Repo.Update(r.Context(), toCancel)
+	if errors.Is(err, ErrConflict) {
+		w.WriteHeader(conflictStatus(r))
+		return
+	} else if errors.Is(err, ErrInvalidTransition) {
+		h.writeInvalidTransition(w, from, StatusCancelled, err)
+		return
+	} else if errors.Is(err, ErrNotExist) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		fmt.Println("failed to cancel:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	toCancel.Version++
+	h.publish(OrderCancelled, toCancel)
+
+	w.Header().Set("ETag", etag(toCancel.Version))
+	if err := json.NewEncoder(w).Encode(toCancel); err != nil {
+		fmt.Println("failed to marshal:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// AddLineItem adds an item to an order which has not been shipped yet.
+func (h *Handler) AddLineItem(w http.ResponseWriter, r *http.Request) {
+	var item LineItem
+	if err := json.NewDecoder(r.Body).Decode(&item); err != nil {
+		fmt.Println("failed to parse body:", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if item.ItemID == uuid.Nil || item.Quantity == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if h.Catalog != nil {
+		items := []LineItem{item}
+		unavailable, err := snapshotPrices(r.Context(), h.Catalog, items)
+		if err != nil {
+			fmt.Println("failed to price line item:", err)
+			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-		toUpdate.CompletedAt = &now
-		break
-	case shippedStatus:
-		if toUpdate.ShippedAt != nil {
-			w.WriteHeader(http.StatusBadRequest)
+
+		if len(unavailable) > 0 {
+			writeItemsError(w, http.StatusUnprocessableEntity, "unknown or inactive items", unavailable)
 			return
 		}
-		toUpdate.ShippedAt = &now
-		break
-	default:
+		item = items[0]
+	}
+
+	if err := validatePrice(item); err != nil {
+		fmt.Println("invalid line item:", err)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
-	if err := h.Repo.Update(r.Context(), toUpdate); err != nil {
-		fmt.Println("failed to update:", err)
+	h.editLineItems(w, r, func(order *Order) error {
+		return AddLineItem(order, item)
+	})
+}
+
+// UpdateLineItem changes the quantity of an item of an order which has not been shipped yet.
+func (h *Handler) UpdateLineItem(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Quantity uint `json:"quantity"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		fmt.Println("failed to parse body:", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Items are removed with DELETE rather than a zero quantity.
+	if body.Quantity == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	itemID, err := uuid.Parse(chi.URLParam(r, "itemID"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	h.editLineItems(w, r, func(order *Order) error {
+		return SetLineItemQuantity(order, itemID, body.Quantity)
+	})
+}
+
+// RemoveLineItem removes an item from an order which has not been shipped yet.
+func (h *Handler) RemoveLineItem(w http.ResponseWriter, r *http.Request) {
+	itemID, err := uuid.Parse(chi.URLParam(r, "itemID"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	h.editLineItems(w, r, func(order *Order) error {
+		return RemoveLineItem(order, itemID)
+	})
+}
+
+// editLineItems applies edit to the line items of the order of the request and stores it in one update,
+// whose version check makes the edit atomic.
+func (h *Handler) editLineItems(w http.ResponseWriter, r *http.Request, edit func(order *Order) error) {
+	idParam := chi.URLParam(r, "id")
+	const base = 10
+	const bitSize = 64
+
+	orderID, err := strconv.ParseInt(idParam, base, bitSize)
+	if err != nil {
+		fmt.Println("failed to parse id:", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	toEdit, err := h.Repo.FindByID(r.Context(), orderID)
+	if errors.Is(err, ErrNotExist) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		fmt.Println("failed to find by id:", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	if err := json.NewEncoder(w).Encode(toUpdate); err != nil {
+	if !ifMatch(r, toEdit.Version) {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	}
+
+	if toEdit.ShippedAt != nil {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+
+	err = edit(&toEdit)
+	if errors.Is(err, ErrLineItemNotExist) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if errors.Is(err, ErrLineItemExists) {
+		w.WriteHeader(http.StatusConflict)
+		return
+	} else if errors.Is(err, ErrMixedCurrencies) || errors.Is(err, ErrAmountOverflow) {
+		fmt.Println("invalid line items:", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	} else if err != nil {
+		fmt.Println("failed to edit line items:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	err = h.Repo.Update(r.Context(), toEdit)
+	var insufficient *InsufficientStockError
+	if errors.Is(err, ErrConflict) {
+		w.WriteHeader(conflictStatus(r))
+		return
+	} else if errors.Is(err, ErrLineItemsFrozen) {
+		// The order was shipped since it was read.
+		w.WriteHeader(http.StatusConflict)
+		return
+	} else if errors.As(err, &insufficient) {
+		writeInsufficientStock(w, insufficient)
+		return
+	} else if errors.Is(err, ErrNotExist) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		fmt.Println("failed to update line items:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	toEdit.Version++
+	h.publish(OrderLineItemsChanged, toEdit)
+
+	w.Header().Set("ETag", etag(toEdit.Version))
+	if err := json.NewEncoder(w).Encode(toEdit); err != nil {
 		fmt.Println("failed to marshal:", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 }
 
+func (h *Handler) states() *StateMachine {
+	return statesOr(h.States)
+}
+
+// writeInvalidTransition explains why the order may not move from a status to another.
+func (h *Handler) writeInvalidTransition(w http.ResponseWriter, from Status, to Status, err error) {
+	response := struct {
+		Error   string   `json:"error"`
+		From    Status   `json:"from"`
+		To      Status   `json:"to"`
+		Allowed []Status `json:"allowed"`
+	}{
+		Error:   err.Error(),
+		From:    from,
+		To:      to,
+		Allowed: h.states().Allowed(from),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusConflict)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		fmt.Println("failed to marshal:", err)
+	}
+}
+
+// writeItemsError explains which items of the line items made the request fail.
+func writeItemsError(w http.ResponseWriter, status int, message string, itemIDs []uuid.UUID) {
+	response := struct {
+		Error   string      `json:"error"`
+		ItemIDs []uuid.UUID `json:"item_ids"`
+	}{
+		Error:   message,
+		ItemIDs: itemIDs,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		fmt.Println("failed to marshal:", err)
+	}
+}
+
+// writeInsufficientStock lists the items whose stock does not cover the quantities of the order.
+func writeInsufficientStock(w http.ResponseWriter, err *InsufficientStockError) {
+	response := struct {
+		Error string     `json:"error"`
+		Items []Shortage `json:"items"`
+	}{
+		Error: ErrInsufficientStock.Error(),
+		Items: err.Shortages,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusConflict)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		fmt.Println("failed to marshal:", err)
+	}
+}
+
 func (h *Handler) DeleteByID(w http.ResponseWriter, r *http.Request) {
 	idParam := chi.URLParam(r, "id")
 
@@ -223,13 +872,317 @@ func (h *Handler) DeleteByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = h.Repo.DeleteByID(r.Context(), orderID)
+	// Without If-Match the order is deleted whatever its version.
+	var version int64
+	if r.Header.Get("If-Match") != "" {
+		found, err := h.Repo.FindByID(r.Context(), orderID)
+		if errors.Is(err, ErrNotExist) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		} else if err != nil {
+			fmt.Println("failed to find by id:", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		if !ifMatch(r, found.Version) {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		version = found.Version
+	}
+
+	err = h.Repo.DeleteByID(r.Context(), orderID, version)
 	if errors.Is(err, ErrNotExist) {
 		w.WriteHeader(http.StatusBadRequest)
 		return
+	} else if errors.Is(err, ErrConflict) {
+		w.WriteHeader(conflictStatus(r))
+		return
 	} else if err != nil {
 		fmt.Println("failed to delete by id:", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+
+	// The tombstone is the order published with the event.
+	if h.Hub != nil {
+		deleted, err := h.Repo.FindAnyByID(r.Context(), orderID)
+		if err != nil {
+			fmt.Println("failed to find deleted order:", err)
+			return
+		}
+		h.publish(OrderDeleted, deleted)
+	}
+}
+
+func (h *Handler) RestoreByID(w http.ResponseWriter, r *http.Request) {
+	idParam := chi.URLParam(r, "id")
+
+	const base = 10
+	const bitSize = 64
+	orderID, err := strconv.ParseInt(idParam, base, bitSize)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	found, err := h.Repo.FindAnyByID(r.Context(), orderID)
+	if errors.Is(err, ErrNotExist) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		fmt.Println("failed to find by id:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if !ifMatch(r, found.Version) {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	}
+
+	err = h.Repo.Restore(r.Context(), orderID, found.Version)
+	var insufficient *InsufficientStockError
+	if errors.Is(err, ErrNotExist) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if errors.Is(err, ErrNotDeleted) {
+		w.WriteHeader(http.StatusConflict)
+		return
+	} else if errors.As(err, &insufficient) {
+		// The stock released by the deletion was reserved by other orders since.
+		writeInsufficientStock(w, insufficient)
+		return
+	} else if errors.Is(err, ErrConflict) {
+		w.WriteHeader(conflictStatus(r))
+		return
+	} else if err != nil {
+		fmt.Println("failed to restore by id:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	found.DeletedAt = nil
+	found.Version++
+	h.publish(OrderRestored, found)
+
+	w.Header().Set("ETag", etag(found.Version))
+	if err := json.NewEncoder(w).Encode(found); err != nil {
+		fmt.Println("failed to marshal:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *Handler) History(w http.ResponseWriter, r *http.Request) {
+	idParam := chi.URLParam(r, "id")
+
+	const base = 10
+	const bitSize = 64
+	orderID, err := strconv.ParseInt(idParam, base, bitSize)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	history, err := h.Repo.History(r.Context(), orderID)
+	if errors.Is(err, ErrNotExist) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		fmt.Println("failed to find history:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	var response struct {
+		Items []Event `json:"items"`
+	}
+	response.Items = history
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		fmt.Println("failed to marshal:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// publish sends the event of a write to the hub, if any.
+func (h *Handler) publish(eventType DomainEventType, order Order) {
+	if h.Hub == nil {
+		return
+	}
+
+	h.Hub.Publish(DomainEvent{
+		SchemaVersion: DomainEventSchemaVersion,
+		Type:          eventType,
+		OrderID:       order.OrderID,
+		OccurredAt:    time.Now().UTC(),
+		Order:         order,
+	})
+}
+
+// Events streams the events of the orders, optionally of a single order or customer, over SSE.
+func (h *Handler) Events(w http.ResponseWriter, r *http.Request) {
+	var filter EventFilter
+
+	if orderID := r.URL.Query().Get("order_id"); orderID != "" {
+		const base = 10
+		const bitSize = 64
+		parsed, err := strconv.ParseInt(orderID, base, bitSize)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		filter.OrderID = parsed
+	}
+
+	if customerID := r.URL.Query().Get("customer_id"); customerID != "" {
+		parsed, err := uuid.Parse(customerID)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		filter.CustomerID = parsed
+	}
+
+	h.streamEvents(w, r, filter)
+}
+
+// OrderEvents streams the events of a single order over SSE.
+func (h *Handler) OrderEvents(w http.ResponseWriter, r *http.Request) {
+	idParam := chi.URLParam(r, "id")
+
+	const base = 10
+	const bitSize = 64
+	orderID, err := strconv.ParseInt(idParam, base, bitSize)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	h.streamEvents(w, r, EventFilter{OrderID: orderID})
+}
+
+// streamEvents writes the events matching filter until the client leaves or the hub closes.
+// A Last-Event-ID header resumes the stream, a resync event tells the client that events were
+// missed and that it must read the orders again.
+func (h *Handler) streamEvents(w http.ResponseWriter, r *http.Request, filter EventFilter) {
+	if h.Hub == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	var lastEventID *uint64
+	if header := r.Header.Get("Last-Event-ID"); header != "" {
+		const base = 10
+		const bitSize = 64
+		parsed, err := strconv.ParseUint(header, base, bitSize)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		lastEventID = &parsed
+	}
+
+	replay, missed, events, cancel := h.Hub.Subscribe(filter, lastEventID)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	rc := http.NewResponseController(w)
+
+	if missed {
+		if _, err := fmt.Fprint(w, "event: resync\ndata: {}\n\n"); err != nil {
+			return
+		}
+	}
+
+	for _, event := range replay {
+		if err := writeEvent(w, event); err != nil {
+			return
+		}
+	}
+
+	if err := rc.Flush(); err != nil {
+		fmt.Println("failed to flush event stream:", err)
+		return
+	}
+
+	interval := h.Heartbeat
+	if interval <= 0 {
+		interval = defaultHeartbeat
+	}
+	heartbeat := time.NewTicker(interval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if err := writeEvent(w, event); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		}
+
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+const defaultHeartbeat = 15 * time.Second
+
+func writeEvent(w http.ResponseWriter, event HubEvent) error {
+	data, err := json.Marshal(event.Event)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Event.Type, data)
+	return err
+}
+
+// etag is the strong entity tag of an order version.
+func etag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// ifMatch reports whether the If-Match header of the request, if any, matches the order version.
+func ifMatch(r *http.Request, version int64) bool {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return true
+	}
+
+	current := etag(version)
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || tag == current {
+			return true
+		}
+	}
+
+	return false
+}
+
+// conflictStatus is the status of a write that lost a race against a concurrent one.
+// It is a failed precondition when the client asked for a version, a conflict otherwise.
+func conflictStatus(r *http.Request) int {
+	if r.Header.Get("If-Match") != "" {
+		return http.StatusPreconditionFailed
+	}
+
+	return http.StatusConflict
 }
@@ -0,0 +1,154 @@
+package order_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"first-little-server/customer"
+	"first-little-server/order"
+	"first-little-server/order/repotest"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+func TestCreateChecksCustomer(t *testing.T) {
+	customers := &customer.MemoryRepo{}
+	known := customer.Customer{ID: uuid.New(), Name: "Ada"}
+	if err := customers.Insert(context.Background(), known); err != nil {
+		t.Fatalf("Insert customer: %v", err)
+	}
+
+	handler := &order.Handler{
+		Repo:      &order.MemoryRepo{},
+		IDs:       &order.Snowflake{},
+		Customers: customers,
+	}
+
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"Absent", `{"line_items":[]}`, http.StatusCreated},
+		{"Null", `{"customer_id":null,"line_items":[]}`, http.StatusCreated},
+		{"Known", `{"customer_id":"` + known.ID.String() + `","line_items":[]}`, http.StatusCreated},
+		{"Unknown", `{"customer_id":"` + uuid.NewString() + `","line_items":[]}`, http.StatusUnprocessableEntity},
+		{"NilUUID", `{"customer_id":"` + uuid.Nil.String() + `","line_items":[]}`, http.StatusUnprocessableEntity},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler.Create(w, httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(test.body)))
+
+			if w.Code != test.want {
+				t.Errorf("Create returned %d, want %d", w.Code, test.want)
+			}
+		})
+	}
+}
+
+// customerCheckerFunc checks the customers with a function.
+type customerCheckerFunc func(ctx context.Context, id uuid.UUID) (bool, error)
+
+func (f customerCheckerFunc) Exists(ctx context.Context, id uuid.UUID) (bool, error) {
+	return f(ctx, id)
+}
+
+func TestCreateReservesIdempotencyKeyBeforeValidating(t *testing.T) {
+	body := `{"customer_id":"` + uuid.NewString() + `","line_items":[]}`
+	create := func(handler *order.Handler) int {
+		r := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(body))
+		r.Header.Set(order.IdempotencyKeyHeader, "key")
+
+		w := httptest.NewRecorder()
+		handler.Create(w, r)
+		return w.Code
+	}
+
+	var checks, retried int
+	handler := &order.Handler{
+		Repo:           &order.MemoryRepo{},
+		IDs:            &order.Snowflake{},
+		Idempotency:    &order.MemoryIdempotencyStore{},
+		IdempotencyTTL: time.Hour,
+	}
+	handler.Customers = customerCheckerFunc(func(ctx context.Context, id uuid.UUID) (bool, error) {
+		checks++
+		// A retry arriving while the customer is checked finds the key taken.
+		if checks == 1 {
+			retried = create(handler)
+		}
+		return false, nil
+	})
+
+	if got := create(handler); got != http.StatusUnprocessableEntity {
+		t.Fatalf("Create returned %d, want %d", got, http.StatusUnprocessableEntity)
+	}
+
+	if retried != http.StatusConflict {
+		t.Errorf("concurrent retry returned %d, want %d", retried, http.StatusConflict)
+	}
+
+	// The failed validation releases the key, a retry validates the order again.
+	if got := create(handler); got != http.StatusUnprocessableEntity || checks != 2 {
+		t.Errorf("retry returned %d after %d customer checks, want %d after 2", got, checks, http.StatusUnprocessableEntity)
+	}
+}
+
+func TestCreateRejectsDuplicateItems(t *testing.T) {
+	repo := &order.MemoryRepo{}
+	handler := &order.Handler{Repo: repo, IDs: &order.Snowflake{}}
+
+	itemID := uuid.NewString()
+	line := `{"item_id":"` + itemID + `","quantity":1,"price":{"amount":100,"currency":"USD"}}`
+	body := `{"line_items":[` + line + `,` + line + `]}`
+
+	w := httptest.NewRecorder()
+	handler.Create(w, httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(body)))
+
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("Create returned %d, want %d", w.Code, http.StatusUnprocessableEntity)
+	}
+
+	if !strings.Contains(w.Body.String(), itemID) {
+		t.Errorf("response %q does not name the duplicate item %s", w.Body.String(), itemID)
+	}
+
+	res, err := repo.FindAll(context.Background(), order.FindAllFilter{}, order.FindAllPage{Size: 10})
+	if err != nil || len(res.Orders) != 0 {
+		t.Errorf("FindAll returned %d orders, %v, want none", len(res.Orders), err)
+	}
+}
+
+func TestUpdateByIDCannotCancel(t *testing.T) {
+	ctx := context.Background()
+	repo := &order.MemoryRepo{}
+	created := repotest.NewOrder(1, 1)
+	if err := repo.Insert(ctx, created); err != nil {
+		t.Fatalf("Insert: %v", err)
+	}
+
+	router := chi.NewRouter()
+	router.Put("/orders/{id}", (&order.Handler{Repo: repo}).UpdateByID)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/orders/1", strings.NewReader(`{"status":"cancelled"}`)))
+
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("PUT cancelled returned %d, want %d", w.Code, http.StatusUnprocessableEntity)
+	}
+
+	found, err := repo.FindByID(ctx, created.OrderID)
+	if err != nil {
+		t.Fatalf("FindByID: %v", err)
+	}
+	if found.Status != created.Status || found.CancelReason != nil {
+		t.Errorf("order status = %s with reason %v, want %s without reason", found.Status, found.CancelReason, created.Status)
+	}
+}
@@ -0,0 +1,167 @@
+package order
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// EventType tells which write produced an entry of the order history.
+type EventType string
+
+const (
+	EventCreated  EventType = "created"
+	EventUpdated  EventType = "updated"
+	EventDeleted  EventType = "deleted"
+	EventRestored EventType = "restored"
+)
+
+// Event is an entry of the history of an order, appended by the repositories along with every write.
+type Event struct {
+	OrderID    int64     `json:"order_id"`
+	Type       EventType `json:"type"`
+	Actor      string    `json:"actor"`
+	OccurredAt time.Time `json:"occurred_at"`
+	// Version is the version of the order after the write.
+	Version int64    `json:"version"`
+	Changes []Change `json:"changes"`
+}
+
+// Change is the old and new value of a field of the order, a nil value means unset.
+type Change struct {
+	Field string  `json:"field"`
+	Old   *string `json:"old"`
+	New   *string `json:"new"`
+}
+
+type actorKey struct{}
+
+// WithActor returns a context whose writes are recorded in the history as done by actor.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// unknownActor is recorded when the context does not name an actor.
+const unknownActor = "anonymous"
+
+func actorFrom(ctx context.Context) string {
+	if actor, ok := ctx.Value(actorKey{}).(string); ok && actor != "" {
+		return actor
+	}
+
+	return unknownActor
+}
+
+// ActorHeader names the actor of a request in the history.
+const ActorHeader = "X-Actor"
+
+// ActorMiddleware records the actor named by the ActorHeader of the request in its context.
+func ActorMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if actor := r.Header.Get(ActorHeader); actor != "" {
+			r = r.WithContext(WithActor(r.Context(), actor))
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// newEvent describes the write from old to current, old is nil when the order is created.
+func newEvent(ctx context.Context, eventType EventType, old *Order, current Order) Event {
+	return Event{
+		OrderID:    current.OrderID,
+		Type:       eventType,
+		Actor:      actorFrom(ctx),
+		OccurredAt: time.Now().UTC(),
+		Version:    current.Version,
+		Changes:    diffOrders(old, current),
+	}
+}
+
+// diffOrders lists the fields whose value differs between old and current.
+func diffOrders(old *Order, current Order) []Change {
+	var before map[string]*string
+	if old != nil {
+		before = historyFields(*old)
+	}
+	after := historyFields(current)
+
+	changes := []Change{}
+	for _, field := range historyFieldNames {
+		if !equalValues(before[field], after[field]) {
+			changes = append(changes, Change{Field: field, Old: before[field], New: after[field]})
+		}
+	}
+
+	return changes
+}
+
+// historyFieldNames orders the changes of an event.
+var historyFieldNames = []string{"status", "customer_id", "created_at", "shipped_at", "completed_at", "cancelled_at",
+	"cancel_reason", "line_items", "deleted_at"}
+
+func historyFields(order Order) map[string]*string {
+	status := string(order.Status)
+	return map[string]*string{
+		"status":        &status,
+		"customer_id":   uuidValue(order.CustomerID),
+		"created_at":    timeValue(order.CreatedAt),
+		"shipped_at":    timeValue(order.ShippedAt),
+		"completed_at":  timeValue(order.CompletedAt),
+		"cancelled_at":  timeValue(order.CancelledAt),
+		"cancel_reason": reasonValue(order.CancelReason),
+		"line_items":    lineItemsValue(order.LineItems),
+		"deleted_at":    timeValue(order.DeletedAt),
+	}
+}
+
+func uuidValue(id uuid.UUID) *string {
+	value := id.String()
+	return &value
+}
+
+func timeValue(t *time.Time) *string {
+	if t == nil {
+		return nil
+	}
+
+	value := t.UTC().Format(time.RFC3339Nano)
+	return &value
+}
+
+func reasonValue(reason *CancelReason) *string {
+	if reason == nil {
+		return nil
+	}
+
+	value := string(reason.Code)
+	if reason.Note != "" {
+		value += ": " + reason.Note
+	}
+	return &value
+}
+
+// lineItemsValue lists the line items as item:quantityxprice currency sorted by item id, so that
+// the order in which a backend returns them never shows as a change.
+func lineItemsValue(items []LineItem) *string {
+	parts := make([]string, 0, len(items))
+	for _, item := range sortedByItemID(items) {
+		parts = append(parts, fmt.Sprintf("%s:%dx%d %s", item.ItemID, item.Quantity, item.Price.Amount,
+			item.Price.Currency))
+	}
+
+	value := strings.Join(parts, ",")
+	return &value
+}
+
+func equalValues(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	return *a == *b
+}
@@ -0,0 +1,143 @@
+package order
+
+import (
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// Hub fans the domain events published by the handlers out to the live subscribers of this server.
+// It keeps the last events so that subscribers resume where they stopped after a reconnection.
+type Hub struct {
+	// BufferSize bounds the events kept for resumes.
+	BufferSize int
+	// SubscriberBuffer bounds the events queued for a subscriber, a subscriber falling further behind
+	// is disconnected and resumes from the buffer. Both sizes must be positive.
+	SubscriberBuffer int
+
+	mu          sync.Mutex
+	lastID      uint64
+	buffer      []HubEvent
+	subscribers map[*subscription]struct{}
+	closed      bool
+}
+
+// HubEvent is a domain event numbered by the hub, the ids increase and restart with the server.
+type HubEvent struct {
+	ID    uint64
+	Event DomainEvent
+}
+
+// EventFilter selects the events of a subscription, zero fields match every event.
+type EventFilter struct {
+	OrderID    int64
+	CustomerID uuid.UUID
+}
+
+func (f EventFilter) matches(event DomainEvent) bool {
+	if f.OrderID != 0 && event.OrderID != f.OrderID {
+		return false
+	}
+
+	if f.CustomerID != uuid.Nil && event.Order.CustomerID != f.CustomerID {
+		return false
+	}
+
+	return true
+}
+
+type subscription struct {
+	filter EventFilter
+	events chan HubEvent
+}
+
+// Publish numbers event, keeps it for resumes and sends it to the matching subscribers.
+func (hub *Hub) Publish(event DomainEvent) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	if hub.closed {
+		return
+	}
+
+	hub.lastID++
+	published := HubEvent{ID: hub.lastID, Event: event}
+
+	hub.buffer = append(hub.buffer, published)
+	if len(hub.buffer) > hub.BufferSize {
+		hub.buffer = hub.buffer[len(hub.buffer)-hub.BufferSize:]
+	}
+
+	for sub := range hub.subscribers {
+		if !sub.filter.matches(event) {
+			continue
+		}
+
+		select {
+		case sub.events <- published:
+		default:
+			// The subscriber is too slow, closing its channel ends its stream.
+			delete(hub.subscribers, sub)
+			close(sub.events)
+		}
+	}
+}
+
+// Subscribe returns the buffered events following lastEventID, when given, and the channel of the
+// next events matching filter. missed reports that events following lastEventID are no longer buffered.
+// The channel is closed when the hub closes or the subscriber falls behind, cancel ends the subscription.
+func (hub *Hub) Subscribe(filter EventFilter, lastEventID *uint64) (replay []HubEvent, missed bool, events <-chan HubEvent, cancel func()) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	sub := &subscription{filter: filter, events: make(chan HubEvent, hub.SubscriberBuffer)}
+
+	if hub.closed {
+		close(sub.events)
+		return nil, false, sub.events, func() {}
+	}
+
+	if lastEventID != nil {
+		// Ids from before a restart of the server are unknown as well.
+		oldest := hub.lastID + 1
+		if len(hub.buffer) > 0 {
+			oldest = hub.buffer[0].ID
+		}
+		missed = *lastEventID+1 < oldest || *lastEventID > hub.lastID
+
+		for _, buffered := range hub.buffer {
+			if buffered.ID > *lastEventID && filter.matches(buffered.Event) {
+				replay = append(replay, buffered)
+			}
+		}
+	}
+
+	if hub.subscribers == nil {
+		hub.subscribers = make(map[*subscription]struct{})
+	}
+	hub.subscribers[sub] = struct{}{}
+
+	cancel = func() {
+		hub.mu.Lock()
+		defer hub.mu.Unlock()
+
+		if _, exist := hub.subscribers[sub]; exist {
+			delete(hub.subscribers, sub)
+			close(sub.events)
+		}
+	}
+
+	return replay, missed, sub.events, cancel
+}
+
+// Close ends every subscription, so that the streams return and the server can shut down.
+func (hub *Hub) Close() {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	hub.closed = true
+	for sub := range hub.subscribers {
+		delete(hub.subscribers, sub)
+		close(sub.events)
+	}
+}
@@ -0,0 +1,71 @@
+package order
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// IDGenerator hands out the ids of the created orders.
+type IDGenerator interface {
+	NewID() (int64, error)
+}
+
+// Snowflake ids are made of the milliseconds since snowflakeEpoch, the node id and a sequence
+// number within the millisecond, from the high to the low bits. They increase with time and never
+// collide across nodes with distinct node ids.
+const (
+	snowflakeNodeBits     = 10
+	snowflakeSequenceBits = 12
+
+	// MaxNodeID is the highest node id of a Snowflake generator.
+	MaxNodeID = 1<<snowflakeNodeBits - 1
+
+	maxSnowflakeSequence = 1<<snowflakeSequenceBits - 1
+)
+
+// snowflakeEpoch leaves the 41 bits of milliseconds room until 2093.
+var snowflakeEpoch = time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// Snowflake generates time ordered ids unique to its node. Every server sharing a database needs
+// its own NodeID, between 0 and MaxNodeID. The zero value is node 0.
+type Snowflake struct {
+	NodeID int64
+
+	mu       sync.Mutex
+	lastMs   int64
+	sequence int64
+}
+
+func (s *Snowflake) NewID() (int64, error) {
+	if s.NodeID < 0 || s.NodeID > MaxNodeID {
+		return 0, fmt.Errorf("node id %d is out of range [0, %d]", s.NodeID, MaxNodeID)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ms := time.Since(snowflakeEpoch).Milliseconds()
+
+	// A clock going backwards keeps the last millisecond, so that ids never repeat.
+	if ms <= s.lastMs {
+		ms = s.lastMs
+		s.sequence++
+	} else {
+		s.sequence = 0
+	}
+
+	// The sequence of the millisecond is exhausted, the next id waits for the following one.
+	for s.sequence > maxSnowflakeSequence {
+		time.Sleep(time.Millisecond)
+
+		if now := time.Since(snowflakeEpoch).Milliseconds(); now > s.lastMs {
+			ms = now
+			s.sequence = 0
+		}
+	}
+
+	s.lastMs = ms
+
+	return ms<<(snowflakeNodeBits+snowflakeSequenceBits) | s.NodeID<<snowflakeSequenceBits | s.sequence, nil
+}
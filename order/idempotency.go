@@ -0,0 +1,153 @@
+package order
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+)
+
+// IdempotencyKeyHeader lets clients retry the creation of an order without creating it twice.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// maxIdempotencyKeyLength bounds the keys accepted from the clients.
+const maxIdempotencyKeyLength = 255
+
+// IdempotencyRecord is the state of an idempotency key. The response is empty until the request
+// holding the key completes.
+type IdempotencyRecord struct {
+	// Fingerprint identifies the request which reserved the key.
+	Fingerprint string `json:"fingerprint"`
+	StatusCode  int    `json:"status_code,omitempty"`
+	ETag        string `json:"etag,omitempty"`
+	Body        []byte `json:"body,omitempty"`
+}
+
+func (r IdempotencyRecord) completed() bool {
+	return r.StatusCode != 0
+}
+
+// IdempotencyStore keeps the idempotency keys and the responses of the requests which used them.
+type IdempotencyStore interface {
+	// Reserve stores a record of fingerprint under key for ttl unless an unexpired record exists.
+	// It returns the existing record and false when the key was already reserved.
+	Reserve(ctx context.Context, key string, fingerprint string, ttl time.Duration) (IdempotencyRecord, bool, error)
+	// Complete stores the response of the request holding key, keeping the expiry of the key.
+	Complete(ctx context.Context, key string, record IdempotencyRecord) error
+	// Release removes the key of a failed request, so that the request can be retried.
+	Release(ctx context.Context, key string) error
+	// Purge removes the records expired at now and returns their number.
+	Purge(ctx context.Context, now time.Time) (int64, error)
+}
+
+// ErrIdempotencyInProgress is returned when a request reuses the key of a request still in progress.
+var ErrIdempotencyInProgress = errors.New("request with the same idempotency key is in progress")
+
+// ErrIdempotencyMismatch is returned when a request reuses a key with a different body.
+var ErrIdempotencyMismatch = errors.New("idempotency key was used for a different request")
+
+// fingerprint identifies a request by its body.
+func fingerprint(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// reserveIdempotencyKey reserves key for the request with the given fingerprint. It returns the response
+// to replay when the request already completed.
+func reserveIdempotencyKey(ctx context.Context, store IdempotencyStore, key string, requestFingerprint string,
+	ttl time.Duration) (*IdempotencyRecord, error) {
+	record, reserved, err := store.Reserve(ctx, key, requestFingerprint, ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	if reserved {
+		return nil, nil
+	}
+
+	if record.Fingerprint != requestFingerprint {
+		return nil, ErrIdempotencyMismatch
+	}
+
+	if !record.completed() {
+		return nil, ErrIdempotencyInProgress
+	}
+
+	return &record, nil
+}
+
+// MemoryIdempotencyStore keeps the idempotency keys in process memory, along with the memory order database.
+// The zero value is ready to use.
+type MemoryIdempotencyStore struct {
+	mu      sync.Mutex
+	records map[string]memoryIdempotencyRecord
+}
+
+type memoryIdempotencyRecord struct {
+	IdempotencyRecord
+	expiresAt time.Time
+}
+
+func (s *MemoryIdempotencyStore) Reserve(_ context.Context, key string, fingerprint string,
+	ttl time.Duration) (IdempotencyRecord, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.records == nil {
+		s.records = make(map[string]memoryIdempotencyRecord)
+	}
+
+	now := time.Now()
+	if existing, exist := s.records[key]; exist && existing.expiresAt.After(now) {
+		return existing.IdempotencyRecord, false, nil
+	}
+
+	s.records[key] = memoryIdempotencyRecord{
+		IdempotencyRecord: IdempotencyRecord{Fingerprint: fingerprint},
+		expiresAt:         now.Add(ttl),
+	}
+
+	return IdempotencyRecord{}, true, nil
+}
+
+func (s *MemoryIdempotencyStore) Complete(_ context.Context, key string, record IdempotencyRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, exist := s.records[key]
+	if !exist {
+		// The key expired while the request was in progress, there is nothing left to replay.
+		return nil
+	}
+
+	existing.IdempotencyRecord = record
+	s.records[key] = existing
+
+	return nil
+}
+
+func (s *MemoryIdempotencyStore) Release(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.records, key)
+
+	return nil
+}
+
+func (s *MemoryIdempotencyStore) Purge(_ context.Context, now time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var purged int64
+	for key, record := range s.records {
+		if !record.expiresAt.After(now) {
+			delete(s.records, key)
+			purged++
+		}
+	}
+
+	return purged, nil
+}
@@ -0,0 +1,126 @@
+package order
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// PostgresIdempotencyStore keeps the idempotency keys in the postgres order database.
+// Expired keys are replaced on reuse and removed by Purge.
+type PostgresIdempotencyStore struct {
+	Client *pgxpool.Pool
+}
+
+const (
+	idempotencyTable = "order_idempotency"
+
+	idempotencyKeyRow = "idempotency_key"
+	fingerprintRow    = "fingerprint"
+	statusCodeRow     = "status_code"
+	etagRow           = "etag"
+	bodyRow           = "body"
+	expiresAtRow      = "expires_at"
+)
+
+// reserveIdempotencySQL inserts the record or replaces an expired one, it returns no row when an
+// unexpired record holds the key.
+const reserveIdempotencySQL = "INSERT INTO " + idempotencyTable +
+	" (" + idempotencyKeyRow + ", " + fingerprintRow + ", " + expiresAtRow + ") VALUES (@key, @fingerprint, @expiresAt)" +
+	" ON CONFLICT (" + idempotencyKeyRow + ") DO UPDATE SET " + fingerprintRow + " = EXCLUDED." + fingerprintRow + ", " +
+	statusCodeRow + " = NULL, " + etagRow + " = NULL, " + bodyRow + " = NULL, " +
+	expiresAtRow + " = EXCLUDED." + expiresAtRow +
+	" WHERE " + idempotencyTable + "." + expiresAtRow + " <= @now" +
+	" RETURNING " + idempotencyKeyRow
+
+const selectIdempotencySQL = "SELECT " + fingerprintRow + ", " + statusCodeRow + ", " + etagRow + ", " + bodyRow +
+	" FROM " + idempotencyTable + " WHERE " + idempotencyKeyRow + " = @key"
+
+func (s *PostgresIdempotencyStore) Reserve(ctx context.Context, key string, fingerprint string,
+	ttl time.Duration) (IdempotencyRecord, bool, error) {
+	now := time.Now().UTC()
+	args := pgx.NamedArgs{
+		"key":         key,
+		"fingerprint": fingerprint,
+		"expiresAt":   now.Add(ttl),
+		"now":         now,
+	}
+
+	var reservedKey string
+	err := s.Client.QueryRow(ctx, reserveIdempotencySQL, args).Scan(&reservedKey)
+	if err == nil {
+		return IdempotencyRecord{}, true, nil
+	} else if !errors.Is(err, pgx.ErrNoRows) {
+		return IdempotencyRecord{}, false, fmt.Errorf("failed to reserve idempotency key: %w", err)
+	}
+
+	var (
+		record     IdempotencyRecord
+		statusCode *int
+		storedETag *string
+	)
+
+	err = s.Client.QueryRow(ctx, selectIdempotencySQL, pgx.NamedArgs{"key": key}).
+		Scan(&record.Fingerprint, &statusCode, &storedETag, &record.Body)
+	if errors.Is(err, pgx.ErrNoRows) {
+		// The holder released the key in the meantime, it is still in progress as far as this request knows.
+		return IdempotencyRecord{Fingerprint: fingerprint}, false, nil
+	} else if err != nil {
+		return IdempotencyRecord{}, false, fmt.Errorf("failed to get idempotency key: %w", err)
+	}
+
+	if statusCode != nil {
+		record.StatusCode = *statusCode
+	}
+
+	if storedETag != nil {
+		record.ETag = *storedETag
+	}
+
+	return record, false, nil
+}
+
+const completeIdempotencySQL = "UPDATE " + idempotencyTable + " SET " + statusCodeRow + " = @statusCode, " +
+	etagRow + " = @etag, " + bodyRow + " = @body WHERE " + idempotencyKeyRow + " = @key AND " +
+	fingerprintRow + " = @fingerprint"
+
+func (s *PostgresIdempotencyStore) Complete(ctx context.Context, key string, record IdempotencyRecord) error {
+	args := pgx.NamedArgs{
+		"key":         key,
+		"fingerprint": record.Fingerprint,
+		"statusCode":  record.StatusCode,
+		"etag":        record.ETag,
+		"body":        record.Body,
+	}
+
+	if _, err := s.Client.Exec(ctx, completeIdempotencySQL, args); err != nil {
+		return fmt.Errorf("failed to complete idempotency key: %w", err)
+	}
+
+	return nil
+}
+
+const releaseIdempotencySQL = "DELETE FROM " + idempotencyTable + " WHERE " + idempotencyKeyRow + " = @key"
+
+func (s *PostgresIdempotencyStore) Release(ctx context.Context, key string) error {
+	if _, err := s.Client.Exec(ctx, releaseIdempotencySQL, pgx.NamedArgs{"key": key}); err != nil {
+		return fmt.Errorf("failed to release idempotency key: %w", err)
+	}
+
+	return nil
+}
+
+const purgeIdempotencySQL = "DELETE FROM " + idempotencyTable + " WHERE " + expiresAtRow + " <= @now"
+
+func (s *PostgresIdempotencyStore) Purge(ctx context.Context, now time.Time) (int64, error) {
+	tag, err := s.Client.Exec(ctx, purgeIdempotencySQL, pgx.NamedArgs{"now": now})
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge idempotency keys: %w", err)
+	}
+
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,84 @@
+package order
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisIdempotencyStore keeps the idempotency keys in redis, which expires them.
+type RedisIdempotencyStore struct {
+	Client *redis.Client
+}
+
+func idempotencyKey(key string) string {
+	return "order_idempotency:" + key
+}
+
+func (s *RedisIdempotencyStore) Reserve(ctx context.Context, key string, fingerprint string,
+	ttl time.Duration) (IdempotencyRecord, bool, error) {
+	data, err := json.Marshal(IdempotencyRecord{Fingerprint: fingerprint})
+	if err != nil {
+		return IdempotencyRecord{}, false, fmt.Errorf("failed to encode idempotency record: %w", err)
+	}
+
+	// The existing record may expire between the reservation and the get, the reservation is then retried.
+	for i := 0; i < maxWatchRetries; i++ {
+		reserved, err := s.Client.SetNX(ctx, idempotencyKey(key), string(data), ttl).Result()
+		if err != nil {
+			return IdempotencyRecord{}, false, fmt.Errorf("failed to reserve idempotency key: %w", err)
+		}
+
+		if reserved {
+			return IdempotencyRecord{}, true, nil
+		}
+
+		value, err := s.Client.Get(ctx, idempotencyKey(key)).Result()
+		if errors.Is(err, redis.Nil) {
+			continue
+		} else if err != nil {
+			return IdempotencyRecord{}, false, fmt.Errorf("failed to get idempotency key: %w", err)
+		}
+
+		var record IdempotencyRecord
+		if err := json.Unmarshal([]byte(value), &record); err != nil {
+			return IdempotencyRecord{}, false, fmt.Errorf("failed to decode idempotency record: %w", err)
+		}
+
+		return record, false, nil
+	}
+
+	return IdempotencyRecord{}, false, fmt.Errorf("idempotency key %q kept changing during the reservation", key)
+}
+
+func (s *RedisIdempotencyStore) Complete(ctx context.Context, key string, record IdempotencyRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to encode idempotency record: %w", err)
+	}
+
+	// The key may have expired while the request was in progress, it is then left out.
+	err = s.Client.SetArgs(ctx, idempotencyKey(key), string(data), redis.SetArgs{Mode: "XX", KeepTTL: true}).Err()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return fmt.Errorf("failed to complete idempotency key: %w", err)
+	}
+
+	return nil
+}
+
+func (s *RedisIdempotencyStore) Release(ctx context.Context, key string) error {
+	if err := s.Client.Del(ctx, idempotencyKey(key)).Err(); err != nil {
+		return fmt.Errorf("failed to release idempotency key: %w", err)
+	}
+
+	return nil
+}
+
+// Purge does nothing, redis expires the keys itself.
+func (s *RedisIdempotencyStore) Purge(_ context.Context, _ time.Time) (int64, error) {
+	return 0, nil
+}
@@ -0,0 +1,201 @@
+package order
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// Inventory keeps the stock of the items, which the repositories reserve for the orders in the
+// transaction of their writes. Items without stock are not tracked, their quantities are unlimited.
+type Inventory interface {
+	// SetStock sets the quantity of itemID available to the orders, which starts tracking it.
+	SetStock(ctx context.Context, itemID uuid.UUID, available int64) error
+	// Stock returns the quantity of itemID available to the orders, false when it is not tracked.
+	Stock(ctx context.Context, itemID uuid.UUID) (int64, bool, error)
+}
+
+var ErrInsufficientStock = errors.New("insufficient stock")
+var ErrNegativeStock = errors.New("stock must not be negative")
+var ErrNoInventory = errors.New("repository keeps no inventory")
+
+// Shortage is an item whose available stock is below the quantity an order requests.
+type Shortage struct {
+	ItemID    uuid.UUID `json:"item_id"`
+	Requested int64     `json:"requested"`
+	Available int64     `json:"available"`
+}
+
+// InsufficientStockError is returned by the writes of the repositories which would reserve more than the stock.
+type InsufficientStockError struct {
+	Shortages []Shortage
+}
+
+func (e *InsufficientStockError) Error() string {
+	return fmt.Sprintf("%s of %d items", ErrInsufficientStock, len(e.Shortages))
+}
+
+func (e *InsufficientStockError) Unwrap() error {
+	return ErrInsufficientStock
+}
+
+// heldQuantities returns the quantities an order holds per item. Cancelled orders release their stock,
+// soft deleted orders too unless they were shipped.
+func heldQuantities(order *Order) map[uuid.UUID]int64 {
+	if order == nil || order.Status == StatusCancelled || (order.DeletedAt != nil && order.ShippedAt == nil) {
+		return nil
+	}
+
+	held := make(map[uuid.UUID]int64)
+	for _, item := range order.LineItems {
+		held[item.ItemID] += int64(item.Quantity)
+	}
+
+	return held
+}
+
+// stockDelta returns the quantities to reserve per item when an order goes from old to current,
+// negative ones being released. The copies of skipTransitions leave the stock alone.
+func stockDelta(ctx context.Context, old, current *Order) map[uuid.UUID]int64 {
+	if skipped, _ := ctx.Value(transitionsSkippedKey{}).(bool); skipped {
+		return nil
+	}
+
+	delta := heldQuantities(current)
+	if delta == nil {
+		delta = make(map[uuid.UUID]int64)
+	}
+
+	for itemID, quantity := range heldQuantities(old) {
+		delta[itemID] -= quantity
+	}
+
+	for itemID, quantity := range delta {
+		if quantity == 0 {
+			delete(delta, itemID)
+		}
+	}
+
+	return delta
+}
+
+// deltaItemIDs returns the items of delta sorted, so that the stock is always locked in the same order.
+func deltaItemIDs(delta map[uuid.UUID]int64) []uuid.UUID {
+	ids := make([]uuid.UUID, 0, len(delta))
+	for itemID := range delta {
+		ids = append(ids, itemID)
+	}
+
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i].String() < ids[j].String()
+	})
+
+	return ids
+}
+
+// checkStock returns an InsufficientStockError for the items of delta reserving more than their stock,
+// the items missing from stock being untracked.
+func checkStock(delta map[uuid.UUID]int64, stock map[uuid.UUID]int64) error {
+	var shortages []Shortage
+	for _, itemID := range deltaItemIDs(delta) {
+		available, tracked := stock[itemID]
+		if tracked && delta[itemID] > available {
+			shortages = append(shortages, Shortage{ItemID: itemID, Requested: delta[itemID], Available: available})
+		}
+	}
+
+	if len(shortages) > 0 {
+		return &InsufficientStockError{Shortages: shortages}
+	}
+
+	return nil
+}
+
+// InventoryHandler serves the stock of the items.
+type InventoryHandler struct {
+	Inventory Inventory
+}
+
+type stockBody struct {
+	ItemID    uuid.UUID `json:"item_id"`
+	Available int64     `json:"available"`
+}
+
+func (h *InventoryHandler) GetByID(w http.ResponseWriter, r *http.Request) {
+	itemID, err := uuid.Parse(chi.URLParam(r, "itemID"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	available, tracked, err := h.Inventory.Stock(r.Context(), itemID)
+	if err != nil {
+		fmt.Println("failed to find stock:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// Untracked items have no stock, their quantities are unlimited until it is set.
+	if !tracked {
+		response := struct {
+			Error   string    `json:"error"`
+			ItemID  uuid.UUID `json:"item_id"`
+			Tracked bool      `json:"tracked"`
+		}{
+			Error:  "untracked item, its quantities are unlimited",
+			ItemID: itemID,
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			fmt.Println("failed to marshal:", err)
+		}
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(stockBody{ItemID: itemID, Available: available}); err != nil {
+		fmt.Println("failed to marshal:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *InventoryHandler) UpdateByID(w http.ResponseWriter, r *http.Request) {
+	itemID, err := uuid.Parse(chi.URLParam(r, "itemID"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var body struct {
+		Available *int64 `json:"available"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Available == nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	err = h.Inventory.SetStock(r.Context(), itemID, *body.Available)
+	if errors.Is(err, ErrNegativeStock) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	} else if err != nil {
+		fmt.Println("failed to set stock:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(stockBody{ItemID: itemID, Available: *body.Available}); err != nil {
+		fmt.Println("failed to marshal:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,165 @@
+package order
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/google/uuid"
+)
+
+// ErrLineItemsFrozen is returned when the line items of an order change after its shipment.
+var ErrLineItemsFrozen = errors.New("line items of a shipped order cannot change")
+
+// ErrLineItemNotExist is returned when an order has no line item with the requested item id.
+var ErrLineItemNotExist = errors.New("line item does not exist")
+
+// ErrLineItemExists is returned when an item is added to an order which already holds it.
+var ErrLineItemExists = errors.New("line item already exists")
+
+// AddLineItem appends item to the line items of order, each item appearing at most once.
+// The edits of the line items keep the totals of the order up to date.
+func AddLineItem(order *Order, item LineItem) error {
+	if findLineItem(order.LineItems, item.ItemID) >= 0 {
+		return ErrLineItemExists
+	}
+
+	items := make([]LineItem, len(order.LineItems), len(order.LineItems)+1)
+	copy(items, order.LineItems)
+
+	return setLineItems(order, append(items, item))
+}
+
+// SetLineItemQuantity changes the quantity of the line item of order with the given item id.
+func SetLineItemQuantity(order *Order, itemID uuid.UUID, quantity uint) error {
+	i := findLineItem(order.LineItems, itemID)
+	if i < 0 {
+		return ErrLineItemNotExist
+	}
+
+	items := make([]LineItem, len(order.LineItems))
+	copy(items, order.LineItems)
+	items[i].Quantity = quantity
+
+	return setLineItems(order, items)
+}
+
+// RemoveLineItem removes the line item of order with the given item id.
+func RemoveLineItem(order *Order, itemID uuid.UUID) error {
+	i := findLineItem(order.LineItems, itemID)
+	if i < 0 {
+		return ErrLineItemNotExist
+	}
+
+	items := make([]LineItem, 0, len(order.LineItems)-1)
+	items = append(items, order.LineItems[:i]...)
+
+	return setLineItems(order, append(items, order.LineItems[i+1:]...))
+}
+
+// duplicateItemIDs returns the item ids appearing on more than one of items, once each.
+func duplicateItemIDs(items []LineItem) []uuid.UUID {
+	seen := make(map[uuid.UUID]int, len(items))
+	var duplicates []uuid.UUID
+	for _, item := range items {
+		seen[item.ItemID]++
+		if seen[item.ItemID] == 2 {
+			duplicates = append(duplicates, item.ItemID)
+		}
+	}
+
+	return duplicates
+}
+
+// setLineItems replaces the line items of order and its totals, leaving order untouched when they fail.
+func setLineItems(order *Order, items []LineItem) error {
+	edited := *order
+	edited.LineItems = items
+	if err := edited.ComputeTotals(); err != nil {
+		return err
+	}
+
+	*order = edited
+
+	return nil
+}
+
+// snapshotPrices replaces the prices of items with the prices of the catalog, whatever the client sent.
+// It returns the ids of the items the catalog does not sell, leaving items partly priced when there are some.
+func snapshotPrices(ctx context.Context, catalog PriceCatalog, items []LineItem) ([]uuid.UUID, error) {
+	itemIDs := make([]uuid.UUID, len(items))
+	for i, item := range items {
+		itemIDs[i] = item.ItemID
+	}
+
+	prices, err := catalog.Prices(ctx, itemIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	var unavailable []uuid.UUID
+	for i := range items {
+		price, exist := prices[items[i].ItemID]
+		if !exist {
+			unavailable = append(unavailable, items[i].ItemID)
+			continue
+		}
+		items[i].Price = price
+	}
+
+	return unavailable, nil
+}
+
+// validatePrice checks that the price of item is a positive amount, or zero, in a valid currency.
+func validatePrice(item LineItem) error {
+	if !ValidCurrency(item.Price.Currency) {
+		return fmt.Errorf("line item %s: invalid currency %q", item.ItemID, item.Price.Currency)
+	}
+
+	if item.Price.Amount < 0 {
+		return fmt.Errorf("line item %s: negative price", item.ItemID)
+	}
+
+	return nil
+}
+
+func findLineItem(items []LineItem, itemID uuid.UUID) int {
+	for i, item := range items {
+		if item.ItemID == itemID {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// lineItemsChanged tells whether the line items of current differ from old, regardless of their order.
+func lineItemsChanged(old, current Order) bool {
+	return !equalValues(lineItemsValue(old.LineItems), lineItemsValue(current.LineItems))
+}
+
+// checkLineItems is the check of the repositories that the line items of a shipped order stay the same,
+// which the copies of skipTransitions skip along with the transitions.
+func checkLineItems(ctx context.Context, existing, order Order) error {
+	if skipped, _ := ctx.Value(transitionsSkippedKey{}).(bool); skipped {
+		return nil
+	}
+
+	if existing.ShippedAt != nil && lineItemsChanged(existing, order) {
+		return ErrLineItemsFrozen
+	}
+
+	return nil
+}
+
+// sortedByItemID returns a copy of items sorted by item id.
+func sortedByItemID(items []LineItem) []LineItem {
+	sorted := make([]LineItem, len(items))
+	copy(sorted, items)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].ItemID.String() < sorted[j].ItemID.String()
+	})
+
+	return sorted
+}
@@ -0,0 +1,312 @@
+package order
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MemoryRepo keeps orders in process memory. It needs no external service,
+// which makes it suited for local development, demos and handler tests.
+// The zero value is ready to use.
+type MemoryRepo struct {
+	// States are the transitions enforced by Update, the default ones when nil.
+	States *StateMachine
+
+	mu      sync.RWMutex
+	orders  map[int64]Order
+	history map[int64][]Event
+	// stock is the available quantity of the tracked items, under the lock of the orders it is reserved for.
+	stock map[uuid.UUID]int64
+}
+
+// copyOrder returns a deep copy of order so that callers never share memory with the repository.
+func copyOrder(order Order) Order {
+	copied := order
+
+	if order.LineItems != nil {
+		copied.LineItems = make([]LineItem, len(order.LineItems))
+		copy(copied.LineItems, order.LineItems)
+	}
+
+	copied.CreatedAt = copyTime(order.CreatedAt)
+	copied.ShippedAt = copyTime(order.ShippedAt)
+	copied.CompletedAt = copyTime(order.CompletedAt)
+	copied.DeletedAt = copyTime(order.DeletedAt)
+	copied.CancelledAt = copyTime(order.CancelledAt)
+
+	if order.CancelReason != nil {
+		reason := *order.CancelReason
+		copied.CancelReason = &reason
+	}
+
+	return copied
+}
+
+func copyTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+
+	copied := *t
+	return &copied
+}
+
+// reserveStock applies delta to the stock, the caller holds the write lock.
+func (repo *MemoryRepo) reserveStock(delta map[uuid.UUID]int64) error {
+	if err := checkStock(delta, repo.stock); err != nil {
+		return err
+	}
+
+	for itemID, quantity := range delta {
+		if _, tracked := repo.stock[itemID]; tracked {
+			repo.stock[itemID] -= quantity
+		}
+	}
+
+	return nil
+}
+
+func (repo *MemoryRepo) SetStock(_ context.Context, itemID uuid.UUID, available int64) error {
+	if available < 0 {
+		return ErrNegativeStock
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if repo.stock == nil {
+		repo.stock = make(map[uuid.UUID]int64)
+	}
+
+	repo.stock[itemID] = available
+
+	return nil
+}
+
+func (repo *MemoryRepo) Stock(_ context.Context, itemID uuid.UUID) (int64, bool, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	available, tracked := repo.stock[itemID]
+
+	return available, tracked, nil
+}
+
+// appendEvent records an event in the history, the caller holds the write lock.
+func (repo *MemoryRepo) appendEvent(event Event) {
+	if repo.history == nil {
+		repo.history = make(map[int64][]Event)
+	}
+
+	repo.history[event.OrderID] = append(repo.history[event.OrderID], event)
+}
+
+func (repo *MemoryRepo) Insert(ctx context.Context, order Order) error {
+	if err := order.ComputeTotals(); err != nil {
+		return err
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if repo.orders == nil {
+		repo.orders = make(map[int64]Order)
+	}
+
+	if _, exist := repo.orders[order.OrderID]; exist {
+		return fmt.Errorf("order %d: %w", order.OrderID, ErrAlreadyExists)
+	}
+
+	if err := repo.reserveStock(stockDelta(ctx, nil, &order)); err != nil {
+		return err
+	}
+
+	repo.orders[order.OrderID] = copyOrder(order)
+	repo.appendEvent(newEvent(ctx, EventCreated, nil, order))
+
+	return nil
+}
+
+func (repo *MemoryRepo) FindByID(_ context.Context, id int64) (Order, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	order, exist := repo.orders[id]
+	if !exist || order.DeletedAt != nil {
+		return Order{}, ErrNotExist
+	}
+
+	return copyOrder(order), nil
+}
+
+func (repo *MemoryRepo) FindAnyByID(_ context.Context, id int64) (Order, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	order, exist := repo.orders[id]
+	if !exist {
+		return Order{}, ErrNotExist
+	}
+
+	return copyOrder(order), nil
+}
+
+func (repo *MemoryRepo) DeleteByID(ctx context.Context, id int64, version int64) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	existing, exist := repo.orders[id]
+	if !exist || existing.DeletedAt != nil {
+		return ErrNotExist
+	}
+
+	if version != 0 && existing.Version != version {
+		return ErrConflict
+	}
+
+	deleted := copyOrder(existing)
+	now := time.Now().UTC()
+	deleted.DeletedAt = &now
+	deleted.Version++
+
+	if err := repo.reserveStock(stockDelta(ctx, &existing, &deleted)); err != nil {
+		return err
+	}
+
+	repo.orders[id] = deleted
+	repo.appendEvent(newEvent(ctx, EventDeleted, &existing, deleted))
+
+	return nil
+}
+
+func (repo *MemoryRepo) Restore(ctx context.Context, id int64, version int64) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	existing, exist := repo.orders[id]
+	if !exist {
+		return ErrNotExist
+	}
+
+	if existing.DeletedAt == nil {
+		return ErrNotDeleted
+	}
+
+	if version != 0 && existing.Version != version {
+		return ErrConflict
+	}
+
+	restored := copyOrder(existing)
+	restored.DeletedAt = nil
+	restored.Version++
+
+	if err := repo.reserveStock(stockDelta(ctx, &existing, &restored)); err != nil {
+		return err
+	}
+
+	repo.orders[id] = restored
+	repo.appendEvent(newEvent(ctx, EventRestored, &existing, restored))
+
+	return nil
+}
+
+func (repo *MemoryRepo) Purge(_ context.Context, deletedBefore time.Time) (int64, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	var purged int64
+	for id, order := range repo.orders {
+		if order.DeletedAt != nil && order.DeletedAt.Before(deletedBefore) {
+			delete(repo.orders, id)
+			delete(repo.history, id)
+			purged++
+		}
+	}
+
+	return purged, nil
+}
+
+func (repo *MemoryRepo) Update(ctx context.Context, order Order) error {
+	if err := order.ComputeTotals(); err != nil {
+		return err
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	existing, exist := repo.orders[order.OrderID]
+	if !exist || existing.DeletedAt != nil {
+		return ErrNotExist
+	}
+
+	if existing.Version != order.Version {
+		return ErrConflict
+	}
+
+	if err := checkTransition(ctx, repo.States, existing.Status, order); err != nil {
+		return err
+	}
+
+	if err := checkLineItems(ctx, existing, order); err != nil {
+		return err
+	}
+
+	stored := copyOrder(order)
+	stored.Version++
+	// Update never deletes, DeleteByID does.
+	stored.DeletedAt = nil
+
+	if err := repo.reserveStock(stockDelta(ctx, &existing, &stored)); err != nil {
+		return err
+	}
+
+	repo.orders[order.OrderID] = stored
+	repo.appendEvent(newEvent(ctx, EventUpdated, &existing, stored))
+
+	return nil
+}
+
+func (repo *MemoryRepo) History(_ context.Context, id int64) ([]Event, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	if _, exist := repo.orders[id]; !exist {
+		return nil, ErrNotExist
+	}
+
+	history := make([]Event, len(repo.history[id]))
+	copy(history, repo.history[id])
+
+	return history, nil
+}
+
+// FindAll pages through the orders matching filter with a keyset cursor,
+// so pages stay stable when orders are inserted or deleted between calls.
+func (repo *MemoryRepo) FindAll(_ context.Context, filter FindAllFilter, page FindAllPage) (FindResult, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	var matching []Order
+	for _, order := range repo.orders {
+		if filter.matches(order) {
+			matching = append(matching, order)
+		}
+	}
+
+	res, err := paginate(matching, page)
+	if err != nil {
+		return FindResult{}, err
+	}
+
+	orders := make([]Order, len(res.Orders))
+	for i, order := range res.Orders {
+		orders[i] = copyOrder(order)
+	}
+	res.Orders = orders
+
+	return res, nil
+}
@@ -0,0 +1,14 @@
+package order_test
+
+import (
+	"testing"
+
+	"first-little-server/order"
+	"first-little-server/order/repotest"
+)
+
+func TestMemoryRepo(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) order.Repository {
+		return &order.MemoryRepo{}
+	})
+}
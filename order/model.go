@@ -1,21 +1,93 @@
 package order
 
 import (
+	"encoding/json"
 	"github.com/google/uuid"
 	"time"
 )
 
 type Order struct {
-	OrderID     int64      `json:"order_id"`
-	CustomerID  uuid.UUID  `json:"customer_id"`
-	LineItems   []LineItem `json:"line_items"`
+	OrderID    int64     `json:"order_id"`
+	CustomerID uuid.UUID `json:"customer_id"`
+	// Status moves along the transitions of the state machine of the server.
+	Status    Status     `json:"status"`
+	LineItems []LineItem `json:"line_items"`
+	// Subtotal sums the line items and Total is the amount due, both are computed when the order is written.
+	Subtotal    Money      `json:"subtotal"`
+	Total       Money      `json:"total"`
 	CreatedAt   *time.Time `json:"created_at"`
 	ShippedAt   *time.Time `json:"shipped_at"`
 	CompletedAt *time.Time `json:"completed_at"`
+	// CancelledAt and CancelReason are set when the order is cancelled, which only happens before shipment.
+	CancelledAt  *time.Time    `json:"cancelled_at,omitempty"`
+	CancelReason *CancelReason `json:"cancel_reason,omitempty"`
+	// DeletedAt is the tombstone of a soft deleted order, such orders are purged after a retention period.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	// Version is incremented by every update, it guards against concurrent modifications.
+	Version int64 `json:"version"`
 }
 
 type LineItem struct {
 	ItemID   uuid.UUID `json:"item_id"`
 	Quantity uint      `json:"quantity"`
-	Price    uint      `json:"price"`
+	Price    Money     `json:"price"`
+}
+
+type Status string
+
+// CancelReason tells why an order was cancelled.
+type CancelReason struct {
+	Code CancelReasonCode `json:"code"`
+	Note string           `json:"note,omitempty"`
+}
+
+type CancelReasonCode string
+
+const (
+	CancelCustomerRequest CancelReasonCode = "customer_request"
+	CancelPaymentFailed   CancelReasonCode = "payment_failed"
+	CancelOutOfStock      CancelReasonCode = "out_of_stock"
+	CancelFraud           CancelReasonCode = "fraud"
+	CancelOther           CancelReasonCode = "other"
+)
+
+// Valid tells whether code is one of the known reason codes.
+func (code CancelReasonCode) Valid() bool {
+	switch code {
+	case CancelCustomerRequest, CancelPaymentFailed, CancelOutOfStock, CancelFraud, CancelOther:
+		return true
+	default:
+		return false
+	}
+}
+
+// UnmarshalJSON derives the status and the totals of the orders stored before they were recorded.
+func (o *Order) UnmarshalJSON(data []byte) error {
+	type plainOrder Order
+	if err := json.Unmarshal(data, (*plainOrder)(o)); err != nil {
+		return err
+	}
+
+	if o.Status == "" {
+		o.Status = legacyStatus(*o)
+	}
+
+	if o.Subtotal.Currency == "" && len(o.LineItems) > 0 {
+		// Stored orders never mixed currencies, legacy prices all being in LegacyCurrency.
+		_ = o.ComputeTotals()
+	}
+
+	return nil
+}
+
+// legacyStatus derives the status of an order from its timestamps, as it was before being recorded.
+func legacyStatus(order Order) Status {
+	switch {
+	case order.CompletedAt != nil:
+		return StatusCompleted
+	case order.ShippedAt != nil:
+		return StatusShipped
+	default:
+		return StatusPending
+	}
 }
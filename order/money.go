@@ -0,0 +1,107 @@
+package order
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// LegacyCurrency is the currency of the prices stored before they carried one.
+const LegacyCurrency = "USD"
+
+var ErrMixedCurrencies = errors.New("line items have different currencies")
+
+var ErrAmountOverflow = errors.New("amount overflows")
+
+// Money is an amount in the minor units of its ISO 4217 currency, cents for USD.
+// The zero Money has no currency yet and takes the currency of the first amount added to it.
+type Money struct {
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+// ValidCurrency tells whether code has the form of an ISO 4217 code, three upper case letters.
+func ValidCurrency(code string) bool {
+	if len(code) != 3 {
+		return false
+	}
+
+	for _, c := range code {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Add returns the sum of m and other, which must share their currency.
+func (m Money) Add(other Money) (Money, error) {
+	currency := m.Currency
+	if currency == "" {
+		currency = other.Currency
+	} else if other.Currency != "" && other.Currency != currency {
+		return Money{}, fmt.Errorf("%w: %s and %s", ErrMixedCurrencies, currency, other.Currency)
+	}
+
+	if (other.Amount > 0 && m.Amount > math.MaxInt64-other.Amount) ||
+		(other.Amount < 0 && m.Amount < math.MinInt64-other.Amount) {
+		return Money{}, ErrAmountOverflow
+	}
+
+	return Money{Amount: m.Amount + other.Amount, Currency: currency}, nil
+}
+
+// Times returns m multiplied by quantity.
+func (m Money) Times(quantity uint) (Money, error) {
+	if quantity == 0 || m.Amount == 0 {
+		return Money{Currency: m.Currency}, nil
+	}
+
+	if uint64(quantity) > math.MaxInt64 {
+		return Money{}, ErrAmountOverflow
+	}
+
+	amount := m.Amount * int64(quantity)
+	if amount/int64(quantity) != m.Amount {
+		return Money{}, ErrAmountOverflow
+	}
+
+	return Money{Amount: amount, Currency: m.Currency}, nil
+}
+
+// UnmarshalJSON also reads the bare amounts of the prices stored before they carried a currency.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	var amount int64
+	if err := json.Unmarshal(data, &amount); err == nil {
+		*m = Money{Amount: amount, Currency: LegacyCurrency}
+		return nil
+	}
+
+	type plainMoney Money
+	return json.Unmarshal(data, (*plainMoney)(m))
+}
+
+// ComputeTotals sets the subtotal and the total of order from its line items,
+// failing when they mix currencies or when the sums overflow.
+func (o *Order) ComputeTotals() error {
+	var subtotal Money
+	for _, item := range o.LineItems {
+		lineTotal, err := item.Price.Times(item.Quantity)
+		if err != nil {
+			return fmt.Errorf("line item %s: %w", item.ItemID, err)
+		}
+
+		subtotal, err = subtotal.Add(lineTotal)
+		if err != nil {
+			return fmt.Errorf("line item %s: %w", item.ItemID, err)
+		}
+	}
+
+	o.Subtotal = subtotal
+	// Orders carry no discount, tax nor shipping fee yet, they pay their subtotal.
+	o.Total = subtotal
+
+	return nil
+}
@@ -0,0 +1,346 @@
+package order
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// DomainEventType names the order events published to downstream systems.
+type DomainEventType string
+
+const (
+	OrderCreated          DomainEventType = "order.created"
+	OrderPaid             DomainEventType = "order.paid"
+	OrderShipped          DomainEventType = "order.shipped"
+	OrderCompleted        DomainEventType = "order.completed"
+	OrderCancelled        DomainEventType = "order.cancelled"
+	OrderRefunded         DomainEventType = "order.refunded"
+	OrderDeleted          DomainEventType = "order.deleted"
+	OrderRestored         DomainEventType = "order.restored"
+	OrderLineItemsChanged DomainEventType = "order.line_items_changed"
+)
+
+// statusEventType names the event of an order reaching status, including the statuses added by configuration.
+func statusEventType(status Status) DomainEventType {
+	return DomainEventType("order." + status)
+}
+
+// DomainEventSchemaVersion is the version of the DomainEvent payload, it changes when a change
+// of the payload breaks the consumers.
+const DomainEventSchemaVersion = 1
+
+// DomainEvent tells downstream systems that an order changed. Order is its state after the change.
+type DomainEvent struct {
+	// ID increases with the writes of the outbox, consumers use it to drop the events delivered
+	// more than once. Events of redis streams are identified by their entry id instead.
+	ID            int64           `json:"id,omitempty"`
+	SchemaVersion int             `json:"schema_version"`
+	Type          DomainEventType `json:"type"`
+	OrderID       int64           `json:"order_id"`
+	OccurredAt    time.Time       `json:"occurred_at"`
+	Order         Order           `json:"order"`
+}
+
+// EventSink receives the domain events dispatched by the OutboxRelay.
+// An error makes the relay retry the event later, so Publish must tolerate duplicates.
+type EventSink interface {
+	Publish(ctx context.Context, event DomainEvent) error
+}
+
+// LogSink prints the domain events, it stands in for a sink when no downstream system is configured.
+type LogSink struct{}
+
+func (LogSink) Publish(_ context.Context, event DomainEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+
+	fmt.Println("order event:", string(data))
+
+	return nil
+}
+
+// domainEvents returns the domain events of the write recorded by event, current is the order after it.
+// Updates that leave the status unchanged publish nothing.
+func domainEvents(event Event, current Order) []DomainEvent {
+	var types []DomainEventType
+
+	switch event.Type {
+	case EventCreated:
+		types = append(types, OrderCreated)
+	case EventUpdated:
+		for _, change := range event.Changes {
+			switch {
+			case change.Field == "status" && change.New != nil:
+				types = append(types, statusEventType(Status(*change.New)))
+			case change.Field == "line_items":
+				types = append(types, OrderLineItemsChanged)
+			}
+		}
+	case EventDeleted:
+		types = append(types, OrderDeleted)
+	case EventRestored:
+		types = append(types, OrderRestored)
+	}
+
+	events := make([]DomainEvent, 0, len(types))
+	for _, eventType := range types {
+		events = append(events, DomainEvent{
+			SchemaVersion: DomainEventSchemaVersion,
+			Type:          eventType,
+			OrderID:       event.OrderID,
+			OccurredAt:    event.OccurredAt,
+			Order:         current,
+		})
+	}
+
+	return events
+}
+
+const (
+	outboxTable = "order_outbox"
+
+	outboxIdRow      = "event_id"
+	payloadRow       = "payload"
+	attemptsRow      = "attempts"
+	nextAttemptAtRow = "next_attempt_at"
+	dispatchedAtRow  = "dispatched_at"
+	lastErrorRow     = "last_error"
+)
+
+const insertOutboxSQL = "INSERT INTO " + outboxTable +
+	" (" + orderIdRow + ", " + eventTypeRow + ", " + payloadRow + ", " + occurredAtRow + ", " + nextAttemptAtRow + ")" +
+	" VALUES (@orderId, @type, @payload, @occurredAt, @occurredAt)"
+
+// insertOutbox queues the domain events of a write within its transaction, so that they are
+// published if and only if the write commits.
+func insertOutbox(ctx context.Context, tx pgx.Tx, event Event, current Order) error {
+	for _, domainEvent := range domainEvents(event, current) {
+		payload, err := json.Marshal(domainEvent)
+		if err != nil {
+			return fmt.Errorf("failed to encode domain event: %w", err)
+		}
+
+		args := pgx.NamedArgs{
+			"orderId":    domainEvent.OrderID,
+			"type":       domainEvent.Type,
+			"payload":    string(payload),
+			"occurredAt": domainEvent.OccurredAt,
+		}
+
+		if _, err := tx.Exec(ctx, insertOutboxSQL, args); err != nil {
+			return fmt.Errorf("failed to insert outbox event: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// OutboxRelay dispatches the events of the outbox to Sink with at-least-once delivery.
+// The events of an order are dispatched in order: a failed event holds back the following ones
+// until a retry succeeds.
+type OutboxRelay struct {
+	Client    *pgxpool.Pool
+	Sink      EventSink
+	BatchSize uint
+	// MinBackoff and MaxBackoff bound the exponential delay between the attempts of an event.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// Lease hides the claimed events from other relays while they are published, it must exceed
+	// the time the Sink takes to publish a batch.
+	Lease time.Duration
+}
+
+// relayLockSQL lets a single server claim events at a time, concurrent claims would break the order.
+const relayLockSQL = "SELECT pg_try_advisory_xact_lock(8146392)"
+
+// claimPendingSQL leases the pending events in write order, skipping the orders whose oldest
+// pending events wait for a retry or are leased by another relay.
+const claimPendingSQL = "UPDATE " + outboxTable + " SET " + nextAttemptAtRow + " = @leasedUntil" +
+	" WHERE " + outboxIdRow + " IN (SELECT " + outboxIdRow + " FROM " + outboxTable + " AS o" +
+	" WHERE " + dispatchedAtRow + " IS NULL" +
+	" AND NOT EXISTS (SELECT 1 FROM " + outboxTable + " AS b WHERE b." + orderIdRow + " = o." + orderIdRow +
+	" AND b." + dispatchedAtRow + " IS NULL AND b." + outboxIdRow + " <= o." + outboxIdRow +
+	" AND b." + nextAttemptAtRow + " > @now)" +
+	" ORDER BY " + outboxIdRow + " LIMIT @limit)" +
+	" RETURNING " + outboxIdRow + ", " + payloadRow + ", " + attemptsRow
+
+const markDispatchedSQL = "UPDATE " + outboxTable + " SET " + dispatchedAtRow + " = @now, " +
+	attemptsRow + " = " + attemptsRow + " + 1 WHERE " + outboxIdRow + " = @eventId"
+
+const markFailedSQL = "UPDATE " + outboxTable + " SET " + attemptsRow + " = " + attemptsRow + " + 1, " +
+	nextAttemptAtRow + " = @nextAttemptAt, " + lastErrorRow + " = @lastError WHERE " + outboxIdRow + " = @eventId"
+
+// releaseSQL ends the lease of an event which was not published, its failed predecessor holds it back.
+const releaseSQL = "UPDATE " + outboxTable + " SET " + nextAttemptAtRow + " = @now WHERE " + outboxIdRow + " = @eventId"
+
+const purgeOutboxSQL = "DELETE FROM " + outboxTable + " WHERE " + dispatchedAtRow + " < @dispatchedBefore"
+
+type pendingEvent struct {
+	event    DomainEvent
+	attempts int
+}
+
+// DispatchPending dispatches a batch of pending events and returns the number of events read.
+// The events are claimed in a short transaction and published outside of it, so that a slow Sink
+// holds no lock. It returns zero without error when another server is claiming events.
+func (relay *OutboxRelay) DispatchPending(ctx context.Context) (int, error) {
+	batch, err := relay.claim(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	// An order whose event failed in this batch is held back until the retry.
+	failed := make(map[int64]bool)
+	for _, p := range batch {
+		if failed[p.event.OrderID] {
+			args := pgx.NamedArgs{"eventId": p.event.ID, "now": time.Now().UTC()}
+			if _, err := relay.Client.Exec(ctx, releaseSQL, args); err != nil {
+				return 0, fmt.Errorf("failed to release outbox event: %w", err)
+			}
+			continue
+		}
+
+		if err := relay.Sink.Publish(ctx, p.event); err != nil {
+			if ctx.Err() != nil {
+				return 0, ctx.Err()
+			}
+
+			failed[p.event.OrderID] = true
+			args := pgx.NamedArgs{
+				"eventId":       p.event.ID,
+				"nextAttemptAt": time.Now().UTC().Add(relay.backoff(p.attempts)),
+				"lastError":     err.Error(),
+			}
+			if _, err := relay.Client.Exec(ctx, markFailedSQL, args); err != nil {
+				return 0, fmt.Errorf("failed to record outbox failure: %w", err)
+			}
+			continue
+		}
+
+		args := pgx.NamedArgs{"eventId": p.event.ID, "now": time.Now().UTC()}
+		if _, err := relay.Client.Exec(ctx, markDispatchedSQL, args); err != nil {
+			return 0, fmt.Errorf("failed to mark outbox event dispatched: %w", err)
+		}
+	}
+
+	return len(batch), nil
+}
+
+// claim leases a batch of pending events, sorted in write order.
+func (relay *OutboxRelay) claim(ctx context.Context) ([]pendingEvent, error) {
+	tx, err := relay.Client.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction for outbox: %w", err)
+	}
+
+	// Rollback is a no-op once the transaction has been committed.
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	var locked bool
+	if err := tx.QueryRow(ctx, relayLockSQL).Scan(&locked); err != nil {
+		return nil, fmt.Errorf("failed to lock outbox: %w", err)
+	}
+
+	if !locked {
+		return nil, nil
+	}
+
+	now := time.Now().UTC()
+	args := pgx.NamedArgs{"now": now, "leasedUntil": now.Add(relay.Lease), "limit": relay.BatchSize}
+	rows, err := tx.Query(ctx, claimPendingSQL, args)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim outbox events: %w", err)
+	}
+	defer rows.Close()
+
+	var batch []pendingEvent
+	for rows.Next() {
+		var (
+			id       int64
+			payload  []byte
+			attempts int
+		)
+
+		if err := rows.Scan(&id, &payload, &attempts); err != nil {
+			return nil, fmt.Errorf("error scanning outbox row: %w", err)
+		}
+
+		var event DomainEvent
+		if err := json.Unmarshal(payload, &event); err != nil {
+			return nil, fmt.Errorf("failed to decode outbox event %d: %w", id, err)
+		}
+		event.ID = id
+
+		batch = append(batch, pendingEvent{event, attempts})
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error closing rows: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit outbox transaction %w", err)
+	}
+
+	// RETURNING does not keep the order of the claim.
+	sort.Slice(batch, func(i, j int) bool { return batch[i].event.ID < batch[j].event.ID })
+
+	return batch, nil
+}
+
+// Purge removes the events dispatched before dispatchedBefore and returns their number.
+func (relay *OutboxRelay) Purge(ctx context.Context, dispatchedBefore time.Time) (int64, error) {
+	tag, err := relay.Client.Exec(ctx, purgeOutboxSQL, pgx.NamedArgs{"dispatchedBefore": dispatchedBefore})
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge outbox: %w", err)
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+// Run dispatches the pending events every interval and right away while batches come full,
+// until ctx is done.
+func (relay *OutboxRelay) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			read, err := relay.DispatchPending(ctx)
+			if err != nil && !errors.Is(err, context.Canceled) {
+				fmt.Println("failed to dispatch outbox:", err)
+			}
+			if err != nil || uint(read) < relay.BatchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// backoff is the delay before the next attempt of an event already attempted attempts times.
+func (relay *OutboxRelay) backoff(attempts int) time.Duration {
+	delay := relay.MinBackoff
+	for i := 0; i < attempts && delay < relay.MaxBackoff; i++ {
+		delay *= 2
+	}
+
+	return min(delay, relay.MaxBackoff)
+}
@@ -2,14 +2,21 @@ package order
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"strings"
 	"time"
 )
 
 type PostgresRepo struct {
-	Client *pgx.Conn
+	Client *pgxpool.Pool
+	// States are the transitions enforced by Update, the default ones when nil.
+	States *StateMachine
 }
 
 const (
@@ -20,50 +27,105 @@ const (
 	createdAtRow   = "created_at"
 	shippedAtRow   = "shipped_at"
 	completedAtRow = "completed_at"
+	cancelledAtRow = "cancelled_at"
+	cancelCodeRow  = "cancel_reason"
+	cancelNoteRow  = "cancel_note"
+	subtotalRow    = "subtotal"
+	totalRow       = "total"
+	currencyRow    = "currency"
+	statusRow      = "status"
+	versionRow     = "version"
+	deletedAtRow   = "deleted_at"
 
 	lineItemTable = "line_item"
 
 	lineItemIdRow = "item_id"
 	quantityRow   = "quantity"
 	priceRow      = "price"
+
+	eventTable = "order_event"
+
+	eventIdRow    = "event_id"
+	eventTypeRow  = "type"
+	actorRow      = "actor"
+	occurredAtRow = "occurred_at"
+	changesRow    = "changes"
+
+	inventoryTable = "inventory"
+
+	availableRow = "available"
 )
 
 const insertIntoOrderSQL = "INSERT INTO " + orderTable +
-	" (" + orderIdRow + ", " + customerIdRow + ", " + createdAtRow + ")" +
-	" VALUES (@orderId, @customerId, @createdAt)"
+	" (" + orderIdRow + ", " + customerIdRow + ", " + statusRow + ", " + createdAtRow + ", " + shippedAtRow + ", " +
+	completedAtRow + ", " + cancelledAtRow + ", " + cancelCodeRow + ", " + cancelNoteRow + ", " + subtotalRow + ", " +
+	totalRow + ", " + currencyRow + ", " + versionRow + ", " + deletedAtRow + ")" +
+	" VALUES (@orderId, @customerId, @status, @createdAt, @shippedAt, @completedAt, @cancelledAt, @cancelCode," +
+	" @cancelNote, @subtotal, @total, @currency, @version, @deletedAt)"
 const insertIntoLineItemSQL = "INSERT INTO " + lineItemTable +
-	" (" + lineItemIdRow + ", " + quantityRow + ", " + priceRow + ", " + orderIdRow + ")" +
-	"VALUES ($1, $2, $3, $4)"
+	" (" + lineItemIdRow + ", " + quantityRow + ", " + priceRow + ", " + currencyRow + ", " + orderIdRow + ")" +
+	"VALUES ($1, $2, $3, $4, $5)"
+
+// uniqueViolationCode is the postgres error code of a duplicate key, the order id being the only unique column.
+const uniqueViolationCode = "23505"
+
+// foreignKeyViolationCode is the postgres error code of a missing referenced row, the customer of an inserted order.
+const foreignKeyViolationCode = "23503"
 
 func (p *PostgresRepo) Insert(ctx context.Context, order Order) error {
-	tx, err := p.Client.BeginTx(ctx, pgx.TxOptions{})
-	defer func(context.Context, pgx.Tx, error) {
-		if err != nil {
-			_ = tx.Rollback(ctx)
-		}
-	}(ctx, tx, err)
+	if err := order.ComputeTotals(); err != nil {
+		return err
+	}
 
+	tx, err := p.Client.BeginTx(ctx, pgx.TxOptions{})
 	if err != nil {
 		return fmt.Errorf("failed to begin transaction for order: %w", err)
 	}
 
+	// Rollback is a no-op once the transaction has been committed.
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
 	args := pgx.NamedArgs{
-		"orderId":    order.OrderID,
-		"customerId": order.CustomerID,
-		"createdAt":  order.CreatedAt,
+		"orderId":     order.OrderID,
+		"customerId":  order.CustomerID,
+		"status":      order.Status,
+		"createdAt":   order.CreatedAt,
+		"shippedAt":   order.ShippedAt,
+		"completedAt": order.CompletedAt,
+		"cancelledAt": order.CancelledAt,
+		"version":     order.Version,
+		"deletedAt":   order.DeletedAt,
+	}
+	setCancelReasonArgs(args, order.CancelReason)
+	setTotalsArgs(args, order)
+
+	// Orders without a customer store NULL, the column references the customers.
+	if order.CustomerID == uuid.Nil {
+		args["customerId"] = nil
 	}
 	_, err = tx.Exec(ctx, insertIntoOrderSQL, args)
 
-	if err != nil {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolationCode {
+		return fmt.Errorf("order %d: %w", order.OrderID, ErrAlreadyExists)
+	} else if errors.As(err, &pgErr) && pgErr.Code == foreignKeyViolationCode {
+		return fmt.Errorf("order %d of customer %s: %w", order.OrderID, order.CustomerID, ErrUnknownCustomer)
+	} else if err != nil {
 		return fmt.Errorf("failed to insert order: %w", err)
 	}
 
-	for _, item := range order.LineItems {
-		_, err := tx.Exec(ctx, insertIntoLineItemSQL, item.ItemID, item.Quantity, item.Price, order.OrderID)
+	if err := insertLineItems(ctx, tx, order); err != nil {
+		return err
+	}
 
-		if err != nil {
-			return fmt.Errorf("failed to insert line item: %w", err)
-		}
+	if err := reserveStock(ctx, tx, stockDelta(ctx, nil, &order)); err != nil {
+		return err
+	}
+
+	if err := recordEvent(ctx, tx, newEvent(ctx, EventCreated, nil, order), order); err != nil {
+		return err
 	}
 
 	err = tx.Commit(ctx)
@@ -74,97 +136,169 @@ func (p *PostgresRepo) Insert(ctx context.Context, order Order) error {
 	return nil
 }
 
-const selectLineItemSQL = "SELECT " + lineItemIdRow + ", " + quantityRow + ", " + priceRow +
-	" FROM " + lineItemTable + " WHERE " + orderIdRow + " = @orderId"
-const selectOrderSQL = "SELECT " + orderIdRow + ", " + customerIdRow + ", " + createdAtRow +
-	", " + shippedAtRow + ", " + completedAtRow + " " +
-	"FROM " + orderTable + " WHERE " + orderIdRow + " = @orderId"
+const selectOrderColumns = orderIdRow + ", " + customerIdRow + ", " + statusRow + ", " + createdAtRow + ", " +
+	shippedAtRow + ", " + completedAtRow + ", " + cancelledAtRow + ", " + cancelCodeRow + ", " + cancelNoteRow + ", " +
+	subtotalRow + ", " + totalRow + ", " + currencyRow + ", " + versionRow + ", " + deletedAtRow
+
+const selectOrderSQL = "SELECT " + selectOrderColumns + " FROM " + orderTable + " WHERE " + orderIdRow + " = @orderId"
+
+// liveCondition excludes the soft deleted orders.
+const liveCondition = deletedAtRow + " IS NULL"
+
+const selectLiveOrderSQL = selectOrderSQL + " AND " + liveCondition
 
 func (p *PostgresRepo) FindByID(ctx context.Context, id int64) (Order, error) {
+	return p.findByID(ctx, selectLiveOrderSQL, id)
+}
+
+func (p *PostgresRepo) FindAnyByID(ctx context.Context, id int64) (Order, error) {
+	return p.findByID(ctx, selectOrderSQL, id)
+}
+
+func (p *PostgresRepo) findByID(ctx context.Context, query string, id int64) (Order, error) {
 	args := pgx.NamedArgs{
 		"orderId": id,
 	}
 
-	rows, err := p.Client.Query(ctx, selectLineItemSQL, args)
-	defer func(pgx.Rows) {
-		rows.Close()
-	}(rows)
+	order, err := scanOrder(p.Client.QueryRow(ctx, query, args))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Order{}, ErrNotExist
+	} else if err != nil {
+		return Order{}, err
+	}
+
+	orders := []Order{order}
+	if err := fillLineItems(ctx, p.Client, orders); err != nil {
+		return Order{}, err
+	}
+
+	return orders[0], nil
+}
+
+// lockOrderSQL reads an order, soft deleted or not, and locks it until the end of the transaction,
+// so that the checks made on it hold when it is written.
+const lockOrderSQL = selectOrderSQL + " FOR UPDATE"
+
+func lockOrder(ctx context.Context, tx pgx.Tx, id int64) (Order, error) {
+	order, err := scanOrder(tx.QueryRow(ctx, lockOrderSQL, pgx.NamedArgs{"orderId": id}))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Order{}, ErrNotExist
+	} else if err != nil {
+		return Order{}, err
+	}
+
+	// The line items are part of the order published in the domain events.
+	orders := []Order{order}
+	if err := fillLineItems(ctx, tx, orders); err != nil {
+		return Order{}, err
+	}
+
+	return orders[0], nil
+}
+
+// deleteOrderSQL tombstones the order, its line items are kept until the purge.
+const deleteOrderSQL = "UPDATE " + orderTable + " SET " +
+	deletedAtRow + " = @deletedAt, " + versionRow + " = " + versionRow + " + 1" +
+	" WHERE " + orderIdRow + " = @orderId"
+
+func (p *PostgresRepo) DeleteByID(ctx context.Context, id int64, version int64) error {
+	tx, err := p.Client.BeginTx(ctx, pgx.TxOptions{})
 	if err != nil {
-		return Order{}, fmt.Errorf("failed to query line item: %w", err)
+		return fmt.Errorf("failed to begin transaction for order: %w", err)
 	}
 
-	var items []LineItem
-	for rows.Next() {
-		var itemID uuid.UUID
-		var quantity uint
-		var price uint
-		err = rows.Scan(&itemID, &quantity, &price)
-		if err != nil {
-			return Order{}, fmt.Errorf("error scanning line_item row: %w", err)
-		}
+	// Rollback is a no-op once the transaction has been committed.
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
 
-		items = append(items, LineItem{itemID, quantity, price})
+	existing, err := lockOrder(ctx, tx, id)
+	if err != nil {
+		return err
 	}
 
-	rows.Close()
-	if rows.Err() != nil {
-		return Order{}, fmt.Errorf("error when closing line_item rows %w", rows.Err())
+	if existing.DeletedAt != nil {
+		return ErrNotExist
 	}
 
-	row := p.Client.QueryRow(ctx, selectOrderSQL, args)
+	if version != 0 && existing.Version != version {
+		return ErrConflict
+	}
+
+	deleted := existing
+	now := time.Now().UTC()
+	deleted.DeletedAt = &now
+	deleted.Version++
+
+	args := pgx.NamedArgs{
+		"orderId":   id,
+		"deletedAt": deleted.DeletedAt,
+	}
 
-	var orderID int64
-	var customerID uuid.UUID
-	var createdAt *time.Time
-	var shippedAt *time.Time
-	var completedAt *time.Time
-	err = row.Scan(&orderID, &customerID, &createdAt, &shippedAt, &completedAt)
+	_, err = tx.Exec(ctx, deleteOrderSQL, args)
 	if err != nil {
-		return Order{}, fmt.Errorf("error scanning order row: %w", err)
+		return fmt.Errorf("failed to delete order: %w", err)
 	}
 
-	utc := time.Time.UTC(*createdAt)
-	createdAt = &utc
-	if shippedAt != nil {
-		utc = time.Time.UTC(*shippedAt)
-		shippedAt = &utc
+	if err := reserveStock(ctx, tx, stockDelta(ctx, &existing, &deleted)); err != nil {
+		return err
 	}
-	if completedAt != nil {
-		utc = time.Time.UTC(*completedAt)
-		completedAt = &utc
+
+	if err := recordEvent(ctx, tx, newEvent(ctx, EventDeleted, &existing, deleted), deleted); err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to commit order transaction %w", err)
 	}
 
-	return Order{orderID, customerID, items, createdAt, shippedAt, completedAt}, nil
+	return nil
 }
 
-const deleteLineItemSQL = "DELETE FROM " + lineItemTable + " WHERE " + orderIdRow + " = @orderId"
-const deleteOrderSQL = "DELETE FROM " + orderTable + " WHERE " + orderIdRow + " = @orderId"
+const restoreOrderSQL = "UPDATE " + orderTable + " SET " +
+	deletedAtRow + " = NULL, " + versionRow + " = " + versionRow + " + 1" +
+	" WHERE " + orderIdRow + " = @orderId"
 
-func (p *PostgresRepo) DeleteByID(ctx context.Context, id int64) error {
+func (p *PostgresRepo) Restore(ctx context.Context, id int64, version int64) error {
 	tx, err := p.Client.BeginTx(ctx, pgx.TxOptions{})
-	defer func(context.Context, pgx.Tx, error) {
-		if err != nil {
-			_ = tx.Rollback(ctx)
-		}
-	}(ctx, tx, err)
-
 	if err != nil {
 		return fmt.Errorf("failed to begin transaction for order: %w", err)
 	}
 
-	args := pgx.NamedArgs{
-		"orderId": id,
-	}
+	// Rollback is a no-op once the transaction has been committed.
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
 
-	_, err = tx.Exec(ctx, deleteLineItemSQL, args)
+	existing, err := lockOrder(ctx, tx, id)
 	if err != nil {
-		return fmt.Errorf("failed to delete associated line item: %w", err)
+		return err
 	}
 
-	_, err = tx.Exec(ctx, deleteOrderSQL, args)
+	if existing.DeletedAt == nil {
+		return ErrNotDeleted
+	}
+
+	if version != 0 && existing.Version != version {
+		return ErrConflict
+	}
 
+	_, err = tx.Exec(ctx, restoreOrderSQL, pgx.NamedArgs{"orderId": id})
 	if err != nil {
-		return fmt.Errorf("failed to delete order: %w", err)
+		return fmt.Errorf("failed to restore order: %w", err)
+	}
+
+	restored := existing
+	restored.DeletedAt = nil
+	restored.Version++
+
+	if err := reserveStock(ctx, tx, stockDelta(ctx, &existing, &restored)); err != nil {
+		return err
+	}
+
+	if err := recordEvent(ctx, tx, newEvent(ctx, EventRestored, &existing, restored), restored); err != nil {
+		return err
 	}
 
 	err = tx.Commit(ctx)
@@ -175,70 +309,451 @@ func (p *PostgresRepo) DeleteByID(ctx context.Context, id int64) error {
 	return nil
 }
 
-const updateOrderSQL = "UPDATE " + orderTable + " SET " +
-	createdAtRow + " = @createdAt, " + shippedAtRow + " = @shippedAt, " +
-	completedAtRow + " = @completedAt WHERE " + orderIdRow + " = @orderId"
+const purgeLineItemSQL = "DELETE FROM " + lineItemTable + " WHERE " + orderIdRow + " IN (SELECT " + orderIdRow +
+	" FROM " + orderTable + " WHERE " + deletedAtRow + " < @deletedBefore)"
+const purgeEventSQL = "DELETE FROM " + eventTable + " WHERE " + orderIdRow + " IN (SELECT " + orderIdRow +
+	" FROM " + orderTable + " WHERE " + deletedAtRow + " < @deletedBefore)"
+const purgeOrderSQL = "DELETE FROM " + orderTable + " WHERE " + deletedAtRow + " < @deletedBefore"
+
+func (p *PostgresRepo) Purge(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	tx, err := p.Client.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction for purge: %w", err)
+	}
+
+	// Rollback is a no-op once the transaction has been committed.
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	args := pgx.NamedArgs{
+		"deletedBefore": deletedBefore,
+	}
+
+	_, err = tx.Exec(ctx, purgeLineItemSQL, args)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge line items: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, purgeEventSQL, args)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge history: %w", err)
+	}
+
+	tag, err := tx.Exec(ctx, purgeOrderSQL, args)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge orders: %w", err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to commit purge transaction %w", err)
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+// updateOrderSQL leaves the creation time alone, it is set once by the insertion and orders the pages.
+const updateOrderSQL = "UPDATE " + orderTable + " SET " + statusRow + " = @status, " +
+	shippedAtRow + " = @shippedAt, " +
+	completedAtRow + " = @completedAt, " + cancelledAtRow + " = @cancelledAt, " + cancelCodeRow + " = @cancelCode, " +
+	cancelNoteRow + " = @cancelNote, " + subtotalRow + " = @subtotal, " + totalRow + " = @total, " +
+	currencyRow + " = @currency, " + versionRow + " = " + versionRow + " + 1" +
+	" WHERE " + orderIdRow + " = @orderId"
 
 func (p *PostgresRepo) Update(ctx context.Context, order Order) error {
+	if err := order.ComputeTotals(); err != nil {
+		return err
+	}
+
+	tx, err := p.Client.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction for order: %w", err)
+	}
+
+	// Rollback is a no-op once the transaction has been committed.
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	// The lock holds the version check until the commit.
+	existing, err := lockOrder(ctx, tx, order.OrderID)
+	if err != nil {
+		return err
+	}
+
+	if existing.DeletedAt != nil {
+		return ErrNotExist
+	}
+
+	if existing.Version != order.Version {
+		return ErrConflict
+	}
+
+	if err := checkTransition(ctx, p.States, existing.Status, order); err != nil {
+		return err
+	}
+
+	if err := checkLineItems(ctx, existing, order); err != nil {
+		return err
+	}
+
+	// The history records the creation time which stays stored.
+	order.CreatedAt = existing.CreatedAt
+
 	args := pgx.NamedArgs{
 		"orderId":     order.OrderID,
-		"createdAt":   order.CreatedAt,
+		"status":      order.Status,
 		"shippedAt":   order.ShippedAt,
 		"completedAt": order.CompletedAt,
+		"cancelledAt": order.CancelledAt,
 	}
-	_, err := p.Client.Exec(ctx, updateOrderSQL, args)
+	setCancelReasonArgs(args, order.CancelReason)
+	setTotalsArgs(args, order)
+	_, err = tx.Exec(ctx, updateOrderSQL, args)
 
 	if err != nil {
 		return fmt.Errorf("failed to update order: %w", err)
 	}
 
+	// The line items are replaced in the transaction of the order, only when they changed.
+	if lineItemsChanged(existing, order) {
+		if err := replaceLineItems(ctx, tx, order); err != nil {
+			return err
+		}
+	}
+
+	stored := order
+	stored.Version++
+	stored.DeletedAt = nil
+
+	if err := reserveStock(ctx, tx, stockDelta(ctx, &existing, &stored)); err != nil {
+		return err
+	}
+
+	if err := recordEvent(ctx, tx, newEvent(ctx, EventUpdated, &existing, stored), stored); err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to commit order transaction %w", err)
+	}
+
+	return nil
+}
+
+const deleteLineItemsSQL = "DELETE FROM " + lineItemTable + " WHERE " + orderIdRow + " = @orderId"
+
+func replaceLineItems(ctx context.Context, tx pgx.Tx, order Order) error {
+	if _, err := tx.Exec(ctx, deleteLineItemsSQL, pgx.NamedArgs{"orderId": order.OrderID}); err != nil {
+		return fmt.Errorf("failed to delete line items: %w", err)
+	}
+
+	return insertLineItems(ctx, tx, order)
+}
+
+func insertLineItems(ctx context.Context, tx pgx.Tx, order Order) error {
+	for _, item := range order.LineItems {
+		_, err := tx.Exec(ctx, insertIntoLineItemSQL, item.ItemID, item.Quantity, item.Price.Amount,
+			item.Price.Currency, order.OrderID)
+
+		if err != nil {
+			return fmt.Errorf("failed to insert line item: %w", err)
+		}
+	}
+
 	return nil
 }
 
-const findAllSQL = "SELECT os." + orderIdRow + ", " + customerIdRow + ", " + createdAtRow + ", " + shippedAtRow +
-	", " + completedAtRow + ", " + lineItemIdRow + ", " + quantityRow + ", " + priceRow + " " +
-	"FROM " + orderTable + " AS os JOIN " + lineItemTable + " AS li " +
-	"ON os." + orderIdRow + " = li." + orderIdRow + " OFFSET $1 LIMIT $2"
+const lockStockSQL = "SELECT " + lineItemIdRow + ", " + availableRow + " FROM " + inventoryTable +
+	" WHERE " + lineItemIdRow + " = ANY(@itemIds) ORDER BY " + lineItemIdRow + " FOR UPDATE"
+const reserveStockSQL = "UPDATE " + inventoryTable + " SET " + availableRow + " = " + availableRow + " - @quantity" +
+	" WHERE " + lineItemIdRow + " = @itemId"
 
-func (p *PostgresRepo) FindAll(ctx context.Context, page FindAllPage) (FindResult, error) {
-	rows, err := p.Client.Query(ctx, findAllSQL, page.Offset, page.Size)
-	defer func(pgx.Rows) {
-		rows.Close()
-	}(rows)
+// reserveStock applies delta to the stock within the transaction of the order. The stock rows are locked
+// in item order until the commit, so that concurrent orders of the same items wait for each other.
+func reserveStock(ctx context.Context, tx pgx.Tx, delta map[uuid.UUID]int64) error {
+	if len(delta) == 0 {
+		return nil
+	}
 
+	rows, err := tx.Query(ctx, lockStockSQL, pgx.NamedArgs{"itemIds": deltaItemIDs(delta)})
 	if err != nil {
-		return FindResult{}, fmt.Errorf("failed to query line item: %w", err)
+		return fmt.Errorf("failed to lock stock: %w", err)
 	}
+	defer rows.Close()
 
-	var orders []Order
-	const invalidOrderID int64 = -1
-	var lastOrderID int64 = -1
+	stock := make(map[uuid.UUID]int64)
 	for rows.Next() {
-		var (
-			orderID     int64
-			customerID  uuid.UUID
-			createdAt   *time.Time
-			shippedAt   *time.Time
-			completedAt *time.Time
-		)
-		var (
-			itemID   uuid.UUID
-			quantity uint
-			price    uint
-		)
+		var itemID uuid.UUID
+		var available int64
 
-		err = rows.Scan(&orderID, &customerID, &createdAt, &shippedAt, &completedAt, &itemID, &quantity, &price)
+		if err := rows.Scan(&itemID, &available); err != nil {
+			return fmt.Errorf("error scanning inventory row: %w", err)
+		}
 
+		stock[itemID] = available
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error closing rows: %w", err)
+	}
+
+	if err := checkStock(delta, stock); err != nil {
+		return err
+	}
+
+	for itemID := range stock {
+		args := pgx.NamedArgs{
+			"itemId":   itemID,
+			"quantity": delta[itemID],
+		}
+
+		if _, err := tx.Exec(ctx, reserveStockSQL, args); err != nil {
+			return fmt.Errorf("failed to reserve stock: %w", err)
+		}
+	}
+
+	return nil
+}
+
+const upsertStockSQL = "INSERT INTO " + inventoryTable + " (" + lineItemIdRow + ", " + availableRow + ")" +
+	" VALUES (@itemId, @available) ON CONFLICT (" + lineItemIdRow + ") DO UPDATE SET " + availableRow +
+	" = EXCLUDED." + availableRow
+const selectStockSQL = "SELECT " + availableRow + " FROM " + inventoryTable + " WHERE " + lineItemIdRow + " = @itemId"
+
+func (p *PostgresRepo) SetStock(ctx context.Context, itemID uuid.UUID, available int64) error {
+	if available < 0 {
+		return ErrNegativeStock
+	}
+
+	args := pgx.NamedArgs{
+		"itemId":    itemID,
+		"available": available,
+	}
+
+	if _, err := p.Client.Exec(ctx, upsertStockSQL, args); err != nil {
+		return fmt.Errorf("failed to set stock: %w", err)
+	}
+
+	return nil
+}
+
+func (p *PostgresRepo) Stock(ctx context.Context, itemID uuid.UUID) (int64, bool, error) {
+	var available int64
+
+	err := p.Client.QueryRow(ctx, selectStockSQL, pgx.NamedArgs{"itemId": itemID}).Scan(&available)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, fmt.Errorf("failed to find stock: %w", err)
+	}
+
+	return available, true, nil
+}
+
+// recordEvent appends event to the history and queues its domain events in the outbox,
+// current is the order after the write.
+func recordEvent(ctx context.Context, tx pgx.Tx, event Event, current Order) error {
+	if err := insertEvent(ctx, tx, event); err != nil {
+		return err
+	}
+
+	return insertOutbox(ctx, tx, event, current)
+}
+
+const insertEventSQL = "INSERT INTO " + eventTable +
+	" (" + orderIdRow + ", " + eventTypeRow + ", " + actorRow + ", " + occurredAtRow + ", " + versionRow + ", " + changesRow + ")" +
+	" VALUES (@orderId, @type, @actor, @occurredAt, @version, @changes)"
+
+// insertEvent appends event to the history within the transaction of the write it describes.
+func insertEvent(ctx context.Context, tx pgx.Tx, event Event) error {
+	changes, err := json.Marshal(event.Changes)
+	if err != nil {
+		return fmt.Errorf("failed to encode event changes: %w", err)
+	}
+
+	args := pgx.NamedArgs{
+		"orderId":    event.OrderID,
+		"type":       event.Type,
+		"actor":      event.Actor,
+		"occurredAt": event.OccurredAt,
+		"version":    event.Version,
+		"changes":    string(changes),
+	}
+
+	_, err = tx.Exec(ctx, insertEventSQL, args)
+	if err != nil {
+		return fmt.Errorf("failed to insert event: %w", err)
+	}
+
+	return nil
+}
+
+const selectEventSQL = "SELECT " + eventTypeRow + ", " + actorRow + ", " + occurredAtRow + ", " + versionRow + ", " +
+	changesRow + " FROM " + eventTable + " WHERE " + orderIdRow + " = @orderId ORDER BY " + eventIdRow
+const existOrderSQL = "SELECT EXISTS (SELECT 1 FROM " + orderTable + " WHERE " + orderIdRow + " = @orderId)"
+
+func (p *PostgresRepo) History(ctx context.Context, id int64) ([]Event, error) {
+	args := pgx.NamedArgs{
+		"orderId": id,
+	}
+
+	var exist bool
+	if err := p.Client.QueryRow(ctx, existOrderSQL, args).Scan(&exist); err != nil {
+		return nil, fmt.Errorf("failed to check order existence: %w", err)
+	}
+
+	if !exist {
+		return nil, ErrNotExist
+	}
+
+	rows, err := p.Client.Query(ctx, selectEventSQL, args)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query history: %w", err)
+	}
+	defer rows.Close()
+
+	history := []Event{}
+	for rows.Next() {
+		event := Event{OrderID: id}
+		var changes []byte
+
+		err := rows.Scan(&event.Type, &event.Actor, &event.OccurredAt, &event.Version, &changes)
 		if err != nil {
-			return FindResult{}, fmt.Errorf("error scanning orders join on line_item row: %w", err)
+			return nil, fmt.Errorf("error scanning order_event row: %w", err)
 		}
 
-		if orderID == invalidOrderID || orderID != lastOrderID {
-			lastOrderID = orderID
-			orders = append(orders, Order{orderID, customerID, []LineItem{}, createdAt, shippedAt, completedAt})
+		if err := json.Unmarshal(changes, &event.Changes); err != nil {
+			return nil, fmt.Errorf("failed to decode event changes: %w", err)
 		}
+		event.OccurredAt = event.OccurredAt.UTC()
 
-		orders[len(orders)-1].LineItems = append(orders[len(orders)-1].LineItems, LineItem{itemID, quantity, price})
+		history = append(history, event)
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error closing rows: %w", err)
+	}
+
+	return history, nil
+}
+
+const selectPageLineItemSQL = "SELECT " + orderIdRow + ", " + lineItemIdRow + ", " + quantityRow + ", " + priceRow +
+	", " + currencyRow + " FROM " + lineItemTable + " WHERE " + orderIdRow + " = ANY(@orderIds)"
+
+// findAllSQL builds the query of a page of orders matching filter along with its arguments.
+// Orders are paged by (created_at, order_id) so that a page boundary stays valid when orders are
+// inserted or deleted, and never splits the line items of an order.
+func findAllSQL(filter FindAllFilter, page FindAllPage) (string, pgx.NamedArgs, error) {
+	// One more order than requested is fetched to know whether a next page exists.
+	args := pgx.NamedArgs{
+		"limit": page.Size + 1,
+	}
+	var conditions []string
+
+	if filter.CustomerID != uuid.Nil {
+		conditions = append(conditions, customerIdRow+" = @customerId")
+		args["customerId"] = filter.CustomerID
+	}
+
+	if filter.Status != "" {
+		conditions = append(conditions, statusRow+" = @status")
+		args["status"] = filter.Status
+	}
+
+	if filter.excludesCancelled() {
+		conditions = append(conditions, statusRow+" <> @cancelled")
+		args["cancelled"] = StatusCancelled
+	}
+
+	switch filter.Deleted {
+	case DeletedExclude:
+		conditions = append(conditions, liveCondition)
+	case DeletedInclude:
+	case DeletedOnly:
+		conditions = append(conditions, deletedAtRow+" IS NOT NULL")
+	default:
+		return "", nil, fmt.Errorf("unknown deleted filter %q", filter.Deleted)
+	}
+
+	if filter.ItemID != uuid.Nil {
+		conditions = append(conditions, "EXISTS (SELECT 1 FROM "+lineItemTable+" AS li WHERE li."+orderIdRow+
+			" = "+orderTable+"."+orderIdRow+" AND li."+lineItemIdRow+" = @itemId)")
+		args["itemId"] = filter.ItemID
+	}
+
+	rangeConditions := []struct {
+		row  string
+		op   string
+		arg  string
+		time *time.Time
+	}{
+		{createdAtRow, ">=", "createdAfter", filter.CreatedAfter},
+		{createdAtRow, "<", "createdBefore", filter.CreatedBefore},
+		{shippedAtRow, ">=", "shippedAfter", filter.ShippedAfter},
+		{shippedAtRow, "<", "shippedBefore", filter.ShippedBefore},
+	}
+	for _, condition := range rangeConditions {
+		if condition.time != nil {
+			conditions = append(conditions, condition.row+" "+condition.op+" @"+condition.arg)
+			args[condition.arg] = *condition.time
+		}
+	}
+
+	direction, seek := "ASC", ">"
+	if page.Sort == SortCreatedDesc {
+		direction, seek = "DESC", "<"
+	}
+
+	if page.Cursor != "" {
+		cursor, err := decodeKeysetCursor(page.Cursor)
+		if err != nil {
+			return "", nil, err
+		}
+
+		conditions = append(conditions, "("+createdAtRow+", "+orderIdRow+") "+seek+" (@cursorCreatedAt, @cursorOrderId)")
+		args["cursorCreatedAt"] = cursor.createdAt
+		args["cursorOrderId"] = cursor.orderID
+	}
+
+	query := "SELECT " + selectOrderColumns + " FROM " + orderTable
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY " + createdAtRow + " " + direction + ", " + orderIdRow + " " + direction + " LIMIT @limit"
+
+	return query, args, nil
+}
+
+func (p *PostgresRepo) FindAll(ctx context.Context, filter FindAllFilter, page FindAllPage) (FindResult, error) {
+	query, args, err := findAllSQL(filter, page)
+	if err != nil {
+		return FindResult{}, err
+	}
+
+	// An empty page has no last order to resume after.
+	if page.Size == 0 {
+		return FindResult{Orders: []Order{}}, nil
+	}
+
+	rows, err := p.Client.Query(ctx, query, args)
+	if err != nil {
+		return FindResult{}, fmt.Errorf("failed to query orders: %w", err)
+	}
+	defer rows.Close()
+
+	orders := []Order{}
+	for rows.Next() {
+		order, err := scanOrder(rows)
+		if err != nil {
+			return FindResult{}, err
+		}
+
+		orders = append(orders, order)
 	}
 	rows.Close()
 
@@ -246,5 +761,157 @@ func (p *PostgresRepo) FindAll(ctx context.Context, page FindAllPage) (FindResul
 		return FindResult{}, fmt.Errorf("error closing rows: %w", err)
 	}
 
-	return FindResult{Orders: orders, Cursor: page.Offset + uint64(page.Size)}, nil
+	var cursor string
+	if uint(len(orders)) > page.Size {
+		orders = orders[:page.Size]
+		last := orders[len(orders)-1]
+		cursor = cursorOf(last).encode()
+	}
+
+	if err := fillLineItems(ctx, p.Client, orders); err != nil {
+		return FindResult{}, err
+	}
+
+	return FindResult{Orders: orders, Cursor: cursor}, nil
+}
+
+// scanOrder reads an order row selected with selectOrderColumns, line items are left empty.
+func scanOrder(row pgx.Row) (Order, error) {
+	var (
+		orderID     int64
+		customerID  *uuid.UUID
+		status      Status
+		createdAt   *time.Time
+		shippedAt   *time.Time
+		completedAt *time.Time
+		cancelledAt *time.Time
+		cancelCode  *string
+		cancelNote  *string
+		subtotal    int64
+		total       int64
+		currency    *string
+		version     int64
+		deletedAt   *time.Time
+	)
+
+	err := row.Scan(&orderID, &customerID, &status, &createdAt, &shippedAt, &completedAt, &cancelledAt, &cancelCode,
+		&cancelNote, &subtotal, &total, &currency, &version, &deletedAt)
+	if err != nil {
+		return Order{}, fmt.Errorf("error scanning order row: %w", err)
+	}
+
+	var reason *CancelReason
+	if cancelCode != nil {
+		reason = &CancelReason{Code: CancelReasonCode(*cancelCode)}
+		if cancelNote != nil {
+			reason.Note = *cancelNote
+		}
+	}
+
+	// Orders without a customer have none.
+	var customer uuid.UUID
+	if customerID != nil {
+		customer = *customerID
+	}
+
+	// Orders without line items have no currency.
+	var currencyCode string
+	if currency != nil {
+		currencyCode = *currency
+	}
+
+	return Order{
+		OrderID:      orderID,
+		CustomerID:   customer,
+		Status:       status,
+		LineItems:    []LineItem{},
+		CreatedAt:    toUTC(createdAt),
+		ShippedAt:    toUTC(shippedAt),
+		CompletedAt:  toUTC(completedAt),
+		CancelledAt:  toUTC(cancelledAt),
+		CancelReason: reason,
+		Subtotal:     Money{Amount: subtotal, Currency: currencyCode},
+		Total:        Money{Amount: total, Currency: currencyCode},
+		Version:      version,
+		DeletedAt:    toUTC(deletedAt),
+	}, nil
+}
+
+// setTotalsArgs sets the subtotal, total and currency arguments, the currency being NULL without line items.
+func setTotalsArgs(args pgx.NamedArgs, order Order) {
+	args["subtotal"] = order.Subtotal.Amount
+	args["total"] = order.Total.Amount
+	args["currency"] = nil
+
+	if order.Subtotal.Currency != "" {
+		args["currency"] = order.Subtotal.Currency
+	}
+}
+
+// setCancelReasonArgs sets the cancelCode and cancelNote arguments, both NULL without a reason.
+func setCancelReasonArgs(args pgx.NamedArgs, reason *CancelReason) {
+	args["cancelCode"] = nil
+	args["cancelNote"] = nil
+
+	if reason != nil {
+		args["cancelCode"] = string(reason.Code)
+		args["cancelNote"] = reason.Note
+	}
+}
+
+func toUTC(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+
+	utc := t.UTC()
+	return &utc
+}
+
+// querier is implemented by both the pool and transactions.
+type querier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}
+
+// fillLineItems loads the line items of every given order with a single query.
+func fillLineItems(ctx context.Context, q querier, orders []Order) error {
+	if len(orders) == 0 {
+		return nil
+	}
+
+	indexByID := make(map[int64]int, len(orders))
+	orderIDs := make([]int64, len(orders))
+	for i, order := range orders {
+		indexByID[order.OrderID] = i
+		orderIDs[i] = order.OrderID
+	}
+
+	rows, err := q.Query(ctx, selectPageLineItemSQL, pgx.NamedArgs{"orderIds": orderIDs})
+	if err != nil {
+		return fmt.Errorf("failed to query line items: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			orderID  int64
+			itemID   uuid.UUID
+			quantity uint
+			price    Money
+		)
+
+		if err := rows.Scan(&orderID, &itemID, &quantity, &price.Amount, &price.Currency); err != nil {
+			return fmt.Errorf("error scanning line_item row: %w", err)
+		}
+
+		i := indexByID[orderID]
+		orders[i].LineItems = append(orders[i].LineItems, LineItem{itemID, quantity, price})
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error closing rows: %w", err)
+	}
+
+	return nil
 }
@@ -0,0 +1,63 @@
+package order_test
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"first-little-server/migration"
+	"first-little-server/order"
+	"first-little-server/order/repotest"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// postgresDSNEnv names a disposable postgres database the suite migrates and truncates,
+// the postgres tests are skipped when it is not set.
+const postgresDSNEnv = "GOSERVER_TEST_POSTGRES_DSN"
+
+func TestPostgresRepo(t *testing.T) {
+	dsn, exist := os.LookupEnv(postgresDSNEnv)
+	if !exist {
+		t.Skipf("%s is not set", postgresDSNEnv)
+	}
+
+	ctx := context.Background()
+	client, err := pgxpool.New(ctx, dsn)
+	if err != nil {
+		t.Fatalf("failed to connect to postgres: %v", err)
+	}
+	t.Cleanup(client.Close)
+
+	if _, err := (&migration.Migrator{Client: client}).Up(ctx); err != nil {
+		t.Fatalf("failed to migrate postgres: %v", err)
+	}
+
+	repotest.Run(t, func(t *testing.T) order.Repository {
+		_, err := client.Exec(ctx, "TRUNCATE order_store, line_item, order_event, order_outbox, inventory, customer")
+		if err != nil {
+			t.Fatalf("failed to clear postgres: %v", err)
+		}
+
+		return &customerRegisteringRepo{PostgresRepo: &order.PostgresRepo{Client: client}}
+	})
+}
+
+// customerRegisteringRepo registers the customers the suite makes up before inserting their orders,
+// which reference them.
+type customerRegisteringRepo struct {
+	*order.PostgresRepo
+}
+
+func (repo *customerRegisteringRepo) Insert(ctx context.Context, o order.Order) error {
+	if o.CustomerID != uuid.Nil {
+		_, err := repo.Client.Exec(ctx, "INSERT INTO customer (customer_id, name, email, created_at, updated_at)"+
+			" VALUES ($1, '', '', now(), now()) ON CONFLICT DO NOTHING", o.CustomerID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return repo.PostgresRepo.Insert(ctx, o)
+}
@@ -5,56 +5,153 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strconv"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/redis/go-redis/v9"
 )
 
 type RedisRepo struct {
 	Client *redis.Client
+	// Stream is the redis stream the domain events of the writes are added to, none when empty.
+	Stream string
+	// StreamMaxLen approximately caps the entries of the stream, zero keeps them all.
+	StreamMaxLen int64
+	// States are the transitions enforced by Update, the default ones when nil.
+	States *StateMachine
 }
 
 func orderIdKey(id int64) string {
 	return fmt.Sprintf("order:%d", id)
 }
 
-// Insert an order in the redis database.
-func (repo *RedisRepo) Insert(ctx context.Context, order Order) error {
-	data, err := json.Marshal(order)
+// Secondary indexes are sorted sets scored by the creation time of the orders in microseconds,
+// so that listings page through them in time order.
+const createdIndexKey = "orders:by_created"
+
+func customerIndexKey(customerID uuid.UUID) string {
+	return fmt.Sprintf("orders:by_customer:%s", customerID)
+}
+
+func statusIndexKey(status Status) string {
+	return fmt.Sprintf("orders:by_status:%s", status)
+}
+
+func itemIndexKey(itemID uuid.UUID) string {
+	return fmt.Sprintf("orders:by_item:%s", itemID)
+}
+
+// historyKey is a list of the events of an order, oldest first. It is outside of the order:* keys
+// which all hold orders.
+func historyKey(id int64) string {
+	return fmt.Sprintf("order_history:%d", id)
+}
 
+// recordEvent queues the append of event to the history of its order and the addition of its
+// domain events to the stream, within the transaction of the write. current is the order after the write.
+func (repo *RedisRepo) recordEvent(ctx context.Context, pipe redis.Pipeliner, event Event, current Order) error {
+	commands, err := repo.eventCommands(event, current)
 	if err != nil {
-		return fmt.Errorf("failed to encode order: %w", err)
+		return err
 	}
 
-	key := orderIdKey(order.OrderID)
+	for _, command := range commands {
+		args := make([]any, len(command))
+		for i, arg := range command {
+			args[i] = arg
+		}
 
-	txPipe := repo.Client.TxPipeline()
+		pipe.Do(ctx, args...)
+	}
 
-	// Set overwrites data when it exists already, thus the usage of SetNX instead.
-	res := txPipe.SetNX(ctx, key, string(data), 0)
+	return nil
+}
 
-	if res.Err() != nil {
-		txPipe.Discard()
-		return fmt.Errorf("failed to set: %w", res.Err())
+// eventCommands returns the commands recording event, which run in the transactions of the writes
+// as well as in the scripts.
+func (repo *RedisRepo) eventCommands(event Event, current Order) ([][]string, error) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode event: %w", err)
 	}
 
-	if err := txPipe.SAdd(ctx, "orders", key).Err(); err != nil {
-		txPipe.Discard()
-		return fmt.Errorf("failed to add to orders set: %w", err)
+	commands := [][]string{{"RPUSH", historyKey(event.OrderID), string(data)}}
+
+	if repo.Stream == "" {
+		return commands, nil
 	}
 
-	if _, err := txPipe.Exec(ctx); err != nil {
-		return fmt.Errorf("failed to exec insertion: %w", err)
+	for _, domainEvent := range domainEvents(event, current) {
+		payload, err := json.Marshal(domainEvent)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode domain event: %w", err)
+		}
+
+		command := []string{"XADD", repo.Stream}
+		if repo.StreamMaxLen > 0 {
+			command = append(command, "MAXLEN", "~", strconv.FormatInt(repo.StreamMaxLen, 10))
+		}
+
+		// The type and schema version are fields of their own, so that consumers route entries
+		// without decoding the payload.
+		commands = append(commands, append(command, "*",
+			"type", string(domainEvent.Type),
+			"schema_version", strconv.Itoa(domainEvent.SchemaVersion),
+			"payload", string(payload),
+		))
 	}
 
-	return nil
+	return commands, nil
 }
 
-var ErrNotExist = errors.New("order does not exist")
+// deletedIndexKey is a sorted set of the soft deleted orders scored by their deletion time in microseconds,
+// the purge reads it. Soft deleted orders stay in the other indexes, listings filter them out.
+const deletedIndexKey = "orders:deleted"
 
-func (repo *RedisRepo) FindByID(ctx context.Context, id int64) (Order, error) {
-	key := orderIdKey(id)
+func deletedEntry(order Order) redis.Z {
+	return redis.Z{Score: float64(order.DeletedAt.UnixMicro()), Member: indexMember(order.OrderID)}
+}
+
+// indexMember is the member of an order in the indexes. Ids are zero padded so that members
+// sharing a score sort by ascending id, like the keyset cursor does.
+func indexMember(id int64) string {
+	return fmt.Sprintf("%019d", id)
+}
 
-	value, err := repo.Client.Get(ctx, key).Result()
+func indexEntry(order Order) redis.Z {
+	return redis.Z{Score: float64(cursorOf(order).createdAt.UnixMicro()), Member: indexMember(order.OrderID)}
+}
+
+// indexKeys returns the secondary indexes the order belongs to.
+func indexKeys(order Order) []string {
+	keys := []string{createdIndexKey, customerIndexKey(order.CustomerID), statusIndexKey(order.Status)}
+
+	for _, item := range order.LineItems {
+		keys = append(keys, itemIndexKey(item.ItemID))
+	}
+
+	return keys
+}
+
+// maxWatchRetries bounds the optimistic transaction retries when the watched order keeps changing.
+const maxWatchRetries = 5
+
+// watch runs fn in an optimistic transaction on the order key, retrying when the key changed under it.
+func (repo *RedisRepo) watch(ctx context.Context, key string, fn func(tx *redis.Tx) error) error {
+	for i := 0; i < maxWatchRetries; i++ {
+		err := repo.Client.Watch(ctx, fn, key)
+		if !errors.Is(err, redis.TxFailedErr) {
+			return err
+		}
+	}
+
+	return fmt.Errorf("order %s kept changing during the transaction", key)
+}
+
+// getOrder reads and decodes the order stored at key.
+func getOrder(ctx context.Context, client redis.Cmdable, key string) (Order, error) {
+	value, err := client.Get(ctx, key).Result()
 
 	if errors.Is(err, redis.Nil) {
 		return Order{}, ErrNotExist
@@ -72,36 +169,416 @@ func (repo *RedisRepo) FindByID(ctx context.Context, id int64) (Order, error) {
 	return order, nil
 }
 
-func (repo *RedisRepo) DeleteByID(ctx context.Context, id int64) error {
-	key := orderIdKey(id)
+func stockKey(itemID uuid.UUID) string {
+	return fmt.Sprintf("inventory:%s", itemID)
+}
+
+// insertScript writes an order along with the reservation of its stock. KEYS are the order, the stock
+// of its items and the other keys the order is written to. ARGV are the commands writing the order
+// as a json array followed by the quantities of the items, the commands refer to their key by its index in KEYS.
+// Untracked items have no stock key, the script only reserves the existing ones.
+var insertScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 1 then
+	return {'exists'}
+end
+
+local stock = {}
+local short = {}
+for i = 2, #ARGV do
+	stock[i] = redis.call('GET', KEYS[i])
+	if stock[i] and tonumber(stock[i]) < tonumber(ARGV[i]) then
+		table.insert(short, tostring(i - 2))
+		table.insert(short, stock[i])
+	end
+end
+
+if #short > 0 then
+	return {'short', unpack(short)}
+end
+
+for i = 2, #ARGV do
+	if stock[i] then
+		redis.call('DECRBY', KEYS[i], ARGV[i])
+	end
+end
+
+for _, command in ipairs(cjson.decode(ARGV[1])) do
+	redis.call(command.name, KEYS[command.key], unpack(command.args))
+end
+
+return {'ok'}
+`)
+
+// scriptCommand is a command run by a script, whose key is declared in the KEYS of the script
+// as cluster and replication require.
+type scriptCommand struct {
+	Name string `json:"name"`
+	// Key is the index of the key of the command in KEYS, from 1.
+	Key  int      `json:"key"`
+	Args []string `json:"args"`
+}
+
+// scriptCommands appends the key of every command, its first argument, to keys unless already there,
+// and returns the keys along with the commands referring to them.
+func scriptCommands(keys []string, commands [][]string) ([]string, []scriptCommand) {
+	indexes := make(map[string]int, len(keys))
+	for i, key := range keys {
+		indexes[key] = i + 1
+	}
+
+	scripted := make([]scriptCommand, 0, len(commands))
+	for _, command := range commands {
+		index, exist := indexes[command[1]]
+		if !exist {
+			keys = append(keys, command[1])
+			index = len(keys)
+			indexes[command[1]] = index
+		}
+
+		scripted = append(scripted, scriptCommand{Name: command[0], Key: index, Args: command[2:]})
+	}
+
+	return keys, scripted
+}
+
+// Insert an order in the redis database. The script makes the existence check, the stock reservation
+// and the write atomic, Set overwriting data when it exists already and indexes having to point to this order.
+func (repo *RedisRepo) Insert(ctx context.Context, order Order) error {
+	if err := order.ComputeTotals(); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(order)
+
+	if err != nil {
+		return fmt.Errorf("failed to encode order: %w", err)
+	}
+
+	key := orderIdKey(order.OrderID)
+
+	commands := [][]string{{"SET", key, string(data)}}
+
+	for _, indexKey := range indexKeys(order) {
+		commands = append(commands, zaddCommand(indexKey, indexEntry(order)))
+	}
+
+	if order.DeletedAt != nil {
+		commands = append(commands, zaddCommand(deletedIndexKey, deletedEntry(order)))
+	}
+
+	eventCommands, err := repo.eventCommands(newEvent(ctx, EventCreated, nil, order), order)
+	if err != nil {
+		return err
+	}
+
+	delta := stockDelta(ctx, nil, &order)
+	itemIDs := deltaItemIDs(delta)
+
+	keys := []string{key}
+	quantities := make([]any, 0, len(itemIDs))
+	for _, itemID := range itemIDs {
+		keys = append(keys, stockKey(itemID))
+		quantities = append(quantities, delta[itemID])
+	}
 
-	txPipe := repo.Client.TxPipeline()
+	keys, scripted := scriptCommands(keys, append(commands, eventCommands...))
+	encoded, err := json.Marshal(scripted)
+	if err != nil {
+		return fmt.Errorf("failed to encode insertion: %w", err)
+	}
+	args := append([]any{string(encoded)}, quantities...)
 
-	err := txPipe.Del(ctx, key).Err()
+	res, err := insertScript.Run(ctx, repo.Client, keys, args...).StringSlice()
+	if err != nil {
+		return fmt.Errorf("failed to exec insertion: %w", err)
+	}
+
+	switch res[0] {
+	case "exists":
+		return fmt.Errorf("order %d: %w", order.OrderID, ErrAlreadyExists)
+	case "short":
+		var shortages []Shortage
+		for i := 1; i+1 < len(res); i += 2 {
+			index, err := strconv.Atoi(res[i])
+			if err != nil {
+				return fmt.Errorf("failed to decode shortage: %w", err)
+			}
+
+			available, err := strconv.ParseInt(res[i+1], 10, 64)
+			if err != nil {
+				return fmt.Errorf("failed to decode shortage: %w", err)
+			}
+
+			itemID := itemIDs[index]
+			shortages = append(shortages, Shortage{ItemID: itemID, Requested: delta[itemID], Available: available})
+		}
+
+		return &InsufficientStockError{Shortages: shortages}
+	}
+
+	return nil
+}
+
+// watchStock watches the stock of the items of delta, so that the transaction fails when it changes,
+// and returns the stock of the tracked items once checked to cover delta.
+func watchStock(ctx context.Context, tx *redis.Tx, delta map[uuid.UUID]int64) (map[uuid.UUID]int64, error) {
+	if len(delta) == 0 {
+		return nil, nil
+	}
+
+	itemIDs := deltaItemIDs(delta)
+	keys := make([]string, len(itemIDs))
+	for i, itemID := range itemIDs {
+		keys[i] = stockKey(itemID)
+	}
+
+	if err := tx.Watch(ctx, keys...).Err(); err != nil {
+		return nil, fmt.Errorf("failed to watch stock: %w", err)
+	}
+
+	values, err := tx.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read stock: %w", err)
+	}
+
+	stock := make(map[uuid.UUID]int64)
+	for i, value := range values {
+		if value == nil {
+			continue
+		}
+
+		available, err := strconv.ParseInt(value.(string), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode stock: %w", err)
+		}
 
+		stock[itemIDs[i]] = available
+	}
+
+	if err := checkStock(delta, stock); err != nil {
+		return nil, err
+	}
+
+	return stock, nil
+}
+
+// queueStock queues the reservation of delta on the tracked items of stock.
+func queueStock(ctx context.Context, pipe redis.Pipeliner, delta map[uuid.UUID]int64, stock map[uuid.UUID]int64) {
+	for itemID := range stock {
+		pipe.DecrBy(ctx, stockKey(itemID), delta[itemID])
+	}
+}
+
+func (repo *RedisRepo) SetStock(ctx context.Context, itemID uuid.UUID, available int64) error {
+	if available < 0 {
+		return ErrNegativeStock
+	}
+
+	if err := repo.Client.Set(ctx, stockKey(itemID), available, 0).Err(); err != nil {
+		return fmt.Errorf("failed to set stock: %w", err)
+	}
+
+	return nil
+}
+
+func (repo *RedisRepo) Stock(ctx context.Context, itemID uuid.UUID) (int64, bool, error) {
+	available, err := repo.Client.Get(ctx, stockKey(itemID)).Int64()
 	if errors.Is(err, redis.Nil) {
-		txPipe.Discard()
-		return ErrNotExist
+		return 0, false, nil
 	} else if err != nil {
-		txPipe.Discard()
-		return fmt.Errorf("failed to delete order: %w", err)
+		return 0, false, fmt.Errorf("failed to find stock: %w", err)
 	}
 
-	if err := txPipe.SRem(ctx, "orders", key).Err(); err != nil {
-		txPipe.Discard()
-		return fmt.Errorf("failed to remove from orders set %w", err)
+	return available, true, nil
+}
+
+func zaddCommand(key string, entry redis.Z) []string {
+	return []string{"ZADD", key, strconv.FormatFloat(entry.Score, 'f', -1, 64), entry.Member.(string)}
+}
+
+var ErrNotExist = errors.New("order does not exist")
+
+func (repo *RedisRepo) FindByID(ctx context.Context, id int64) (Order, error) {
+	order, err := getOrder(ctx, repo.Client, orderIdKey(id))
+	if err != nil {
+		return Order{}, err
 	}
 
-	if _, err := txPipe.Exec(ctx); err != nil {
-		txPipe.Discard()
-		return fmt.Errorf("failed to exec delete: %w", err)
+	if order.DeletedAt != nil {
+		return Order{}, ErrNotExist
 	}
 
-	return nil
+	return order, nil
+}
+
+func (repo *RedisRepo) FindAnyByID(ctx context.Context, id int64) (Order, error) {
+	return getOrder(ctx, repo.Client, orderIdKey(id))
+}
+
+func (repo *RedisRepo) DeleteByID(ctx context.Context, id int64, version int64) error {
+	key := orderIdKey(id)
+
+	return repo.watch(ctx, key, func(tx *redis.Tx) error {
+		existing, err := getOrder(ctx, tx, key)
+		if err != nil {
+			return err
+		}
+
+		if existing.DeletedAt != nil {
+			return ErrNotExist
+		}
+
+		if version != 0 && existing.Version != version {
+			return ErrConflict
+		}
+
+		deleted := existing
+		now := time.Now().UTC()
+		deleted.DeletedAt = &now
+		deleted.Version++
+
+		delta := stockDelta(ctx, &existing, &deleted)
+		stock, err := watchStock(ctx, tx, delta)
+		if err != nil {
+			return err
+		}
+
+		data, err := json.Marshal(deleted)
+		if err != nil {
+			return fmt.Errorf("failed to encode order: %w", err)
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, key, string(data), 0)
+			pipe.ZAdd(ctx, deletedIndexKey, deletedEntry(deleted))
+			queueStock(ctx, pipe, delta, stock)
+			return repo.recordEvent(ctx, pipe, newEvent(ctx, EventDeleted, &existing, deleted), deleted)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to exec delete: %w", err)
+		}
+
+		return nil
+	})
+}
+
+func (repo *RedisRepo) Restore(ctx context.Context, id int64, version int64) error {
+	key := orderIdKey(id)
+
+	return repo.watch(ctx, key, func(tx *redis.Tx) error {
+		existing, err := getOrder(ctx, tx, key)
+		if err != nil {
+			return err
+		}
+
+		if existing.DeletedAt == nil {
+			return ErrNotDeleted
+		}
+
+		if version != 0 && existing.Version != version {
+			return ErrConflict
+		}
+
+		restored := existing
+		restored.DeletedAt = nil
+		restored.Version++
+
+		delta := stockDelta(ctx, &existing, &restored)
+		stock, err := watchStock(ctx, tx, delta)
+		if err != nil {
+			return err
+		}
+
+		data, err := json.Marshal(restored)
+		if err != nil {
+			return fmt.Errorf("failed to encode order: %w", err)
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, key, string(data), 0)
+			pipe.ZRem(ctx, deletedIndexKey, indexMember(id))
+			queueStock(ctx, pipe, delta, stock)
+			return repo.recordEvent(ctx, pipe, newEvent(ctx, EventRestored, &existing, restored), restored)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to exec restore: %w", err)
+		}
+
+		return nil
+	})
+}
+
+// Purge removes the orders of the deleted index scored before deletedBefore, with their index entries.
+func (repo *RedisRepo) Purge(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	members, err := repo.Client.ZRangeArgs(ctx, redis.ZRangeArgs{
+		Key:     deletedIndexKey,
+		Start:   "-inf",
+		Stop:    "(" + strconv.FormatInt(deletedBefore.UnixMicro(), 10),
+		ByScore: true,
+	}).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read index %s: %w", deletedIndexKey, err)
+	}
+
+	var purged int64
+	for _, member := range members {
+		const decimal = 10
+		const bitSize = 64
+		id, err := strconv.ParseInt(member, decimal, bitSize)
+		if err != nil {
+			return purged, fmt.Errorf("invalid member in index %s: %w", deletedIndexKey, err)
+		}
+
+		key := orderIdKey(id)
+		err = repo.watch(ctx, key, func(tx *redis.Tx) error {
+			existing, err := getOrder(ctx, tx, key)
+			if errors.Is(err, ErrNotExist) {
+				// A dangling entry, nothing else references the order.
+				return tx.ZRem(ctx, deletedIndexKey, member).Err()
+			} else if err != nil {
+				return err
+			}
+
+			// The order may have been restored since the index read.
+			if existing.DeletedAt == nil || !existing.DeletedAt.Before(deletedBefore) {
+				return nil
+			}
+
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.Del(ctx, key, historyKey(id))
+				pipe.ZRem(ctx, deletedIndexKey, member)
+
+				for _, indexKey := range indexKeys(existing) {
+					pipe.ZRem(ctx, indexKey, member)
+				}
+
+				return nil
+			})
+			if err != nil {
+				return fmt.Errorf("failed to exec purge: %w", err)
+			}
+
+			purged++
+			return nil
+		})
+		if err != nil {
+			return purged, err
+		}
+	}
+
+	return purged, nil
 }
 
 func (repo *RedisRepo) Update(ctx context.Context, order Order) error {
-	data, err := json.Marshal(order)
+	if err := order.ComputeTotals(); err != nil {
+		return err
+	}
+
+	stored := order
+	stored.Version++
+	// Update never deletes, DeleteByID does.
+	stored.DeletedAt = nil
+	data, err := json.Marshal(stored)
 
 	if err != nil {
 		return fmt.Errorf("failed to encode order: %w", err)
@@ -109,49 +586,285 @@ func (repo *RedisRepo) Update(ctx context.Context, order Order) error {
 
 	key := orderIdKey(order.OrderID)
 
-	// Update only existing records.
-	err = repo.Client.SetXX(ctx, key, string(data), 0).Err()
+	// The version check runs under watch, a concurrent update makes the transaction fail and
+	// the retry then sees the new version.
+	return repo.watch(ctx, key, func(tx *redis.Tx) error {
+		// Update only existing records, the stored order tells which indexes must change.
+		existing, err := getOrder(ctx, tx, key)
+		if err != nil {
+			return err
+		}
 
-	if errors.Is(err, redis.Nil) {
-		return ErrNotExist
-	} else if err != nil {
-		return fmt.Errorf("failed to update order: %w", err)
-	}
+		if existing.DeletedAt != nil {
+			return ErrNotExist
+		}
 
-	return nil
+		if existing.Version != order.Version {
+			return ErrConflict
+		}
+
+		if err := checkTransition(ctx, repo.States, existing.Status, order); err != nil {
+			return err
+		}
+
+		if err := checkLineItems(ctx, existing, order); err != nil {
+			return err
+		}
+
+		delta := stockDelta(ctx, &existing, &stored)
+		stock, err := watchStock(ctx, tx, delta)
+		if err != nil {
+			return err
+		}
+
+		newIndexKeys := make(map[string]bool)
+		for _, indexKey := range indexKeys(order) {
+			newIndexKeys[indexKey] = true
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, key, string(data), 0)
+
+			for _, indexKey := range indexKeys(existing) {
+				if !newIndexKeys[indexKey] {
+					pipe.ZRem(ctx, indexKey, indexMember(order.OrderID))
+				}
+			}
+
+			// ZAdd also moves the order when its creation time changed.
+			for indexKey := range newIndexKeys {
+				pipe.ZAdd(ctx, indexKey, indexEntry(order))
+			}
+
+			queueStock(ctx, pipe, delta, stock)
+
+			return repo.recordEvent(ctx, pipe, newEvent(ctx, EventUpdated, &existing, stored), stored)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to update order: %w", err)
+		}
+
+		return nil
+	})
 }
 
-func (repo *RedisRepo) FindAll(ctx context.Context, page FindAllPage) (FindResult, error) {
-	res := repo.Client.SScan(ctx, "orders", page.Offset, "*", int64(page.Size))
+func (repo *RedisRepo) History(ctx context.Context, id int64) ([]Event, error) {
+	// The order is read first, a purged order leaves no history behind.
+	if _, err := repo.FindAnyByID(ctx, id); err != nil {
+		return nil, err
+	}
 
-	keys, cursor, err := res.Result()
+	values, err := repo.Client.LRange(ctx, historyKey(id), 0, -1).Result()
 	if err != nil {
-		return FindResult{}, fmt.Errorf("failed to get order ids: %w", err)
+		return nil, fmt.Errorf("failed to read history: %w", err)
+	}
+
+	history := make([]Event, 0, len(values))
+	for _, value := range values {
+		var event Event
+		if err := json.Unmarshal([]byte(value), &event); err != nil {
+			return nil, fmt.Errorf("failed to decode event json: %w", err)
+		}
+
+		history = append(history, event)
+	}
+
+	return history, nil
+}
+
+// FindAll pages through the most selective index matching filter, the remaining criteria are
+// applied on the decoded orders.
+func (repo *RedisRepo) FindAll(ctx context.Context, filter FindAllFilter, page FindAllPage) (FindResult, error) {
+	indexKey := createdIndexKey
+
+	switch {
+	case filter.CustomerID != uuid.Nil:
+		indexKey = customerIndexKey(filter.CustomerID)
+	case filter.ItemID != uuid.Nil:
+		indexKey = itemIndexKey(filter.ItemID)
+	case filter.Status != "":
+		indexKey = statusIndexKey(filter.Status)
+	}
+
+	return repo.pageIndex(ctx, indexKey, filter, page)
+}
+
+// FindByCustomer pages through the orders of a customer in time order.
+func (repo *RedisRepo) FindByCustomer(ctx context.Context, customerID uuid.UUID, page FindAllPage) (FindResult, error) {
+	return repo.pageIndex(ctx, customerIndexKey(customerID), FindAllFilter{CustomerID: customerID}, page)
+}
+
+// FindByStatus pages through the orders with the given status in time order.
+func (repo *RedisRepo) FindByStatus(ctx context.Context, status Status, page FindAllPage) (FindResult, error) {
+	return repo.pageIndex(ctx, statusIndexKey(status), FindAllFilter{Status: status}, page)
+}
+
+// FindCreatedBetween pages through the orders created within [after, before) in time order.
+// A nil bound leaves the range open on that side.
+func (repo *RedisRepo) FindCreatedBetween(ctx context.Context, after, before *time.Time, page FindAllPage) (FindResult, error) {
+	filter := FindAllFilter{CreatedAfter: after, CreatedBefore: before}
+	return repo.pageIndex(ctx, createdIndexKey, filter, page)
+}
+
+// pageIndex walks the index from the page cursor in time order and collects the orders matching filter.
+func (repo *RedisRepo) pageIndex(ctx context.Context, indexKey string, filter FindAllFilter, page FindAllPage) (FindResult, error) {
+	var cursor *keysetCursor
+	if page.Cursor != "" {
+		decoded, err := decodeKeysetCursor(page.Cursor)
+		if err != nil {
+			return FindResult{}, err
+		}
+		cursor = &decoded
+	}
+
+	if page.Size == 0 {
+		return FindResult{Orders: []Order{}}, nil
+	}
+
+	descending := page.Sort == SortCreatedDesc
+
+	// The creation time range and the cursor bound the scores to read.
+	minScore, maxScore := "-inf", "+inf"
+	if filter.CreatedAfter != nil {
+		minScore = strconv.FormatInt(filter.CreatedAfter.UnixMicro(), 10)
 	}
+	if filter.CreatedBefore != nil {
+		maxScore = "(" + strconv.FormatInt(filter.CreatedBefore.UnixMicro(), 10)
+	}
+	if cursor != nil {
+		// Entries sharing the cursor score are read again and skipped below.
+		cursorScore := strconv.FormatInt(cursor.createdAt.UnixMicro(), 10)
+		if descending && (filter.CreatedBefore == nil || cursor.createdAt.Before(*filter.CreatedBefore)) {
+			maxScore = cursorScore
+		} else if !descending && (filter.CreatedAfter == nil || !cursor.createdAt.Before(*filter.CreatedAfter)) {
+			minScore = cursorScore
+		}
+	}
+
+	// One more order than requested is collected to know whether a next page exists.
+	batch := int64(page.Size) + 1
+	orders := make([]Order, 0, batch)
+
+	for offset := int64(0); uint(len(orders)) <= page.Size; offset += batch {
+		entries, err := repo.Client.ZRangeArgsWithScores(ctx, redis.ZRangeArgs{
+			Key:     indexKey,
+			Start:   minScore,
+			Stop:    maxScore,
+			ByScore: true,
+			Rev:     descending,
+			Offset:  offset,
+			Count:   batch,
+		}).Result()
+		if err != nil {
+			return FindResult{}, fmt.Errorf("failed to read index %s: %w", indexKey, err)
+		}
+
+		keys := make([]string, 0, len(entries))
+		for _, entry := range entries {
+			const decimal = 10
+			const bitSize = 64
+			id, err := strconv.ParseInt(entry.Member.(string), decimal, bitSize)
+			if err != nil {
+				return FindResult{}, fmt.Errorf("invalid member in index %s: %w", indexKey, err)
+			}
+
+			position := keysetCursor{createdAt: time.UnixMicro(int64(entry.Score)).UTC(), orderID: id}
+			if cursor != nil && ((!descending && !cursor.before(position)) || (descending && !position.before(*cursor))) {
+				continue
+			}
+
+			keys = append(keys, orderIdKey(id))
+		}
+
+		found, err := repo.getOrders(ctx, keys)
+		if err != nil {
+			return FindResult{}, err
+		}
+
+		for _, order := range found {
+			if filter.matches(order) {
+				orders = append(orders, order)
+			}
+		}
+
+		if int64(len(entries)) < batch {
+			break
+		}
+	}
+
+	var next string
+	if uint(len(orders)) > page.Size {
+		orders = orders[:page.Size]
+		next = cursorOf(orders[len(orders)-1]).encode()
+	}
+
+	return FindResult{Orders: orders, Cursor: next}, nil
+}
 
-	// Early return when no keys have been found.
+// getOrders reads and decodes the orders stored at keys, skipping the ones that no longer exist.
+func (repo *RedisRepo) getOrders(ctx context.Context, keys []string) ([]Order, error) {
 	if len(keys) == 0 {
-		return FindResult{Orders: []Order{}, Cursor: cursor}, nil
+		return nil, nil
 	}
 
 	unmarshedOrders, err := repo.Client.MGet(ctx, keys...).Result()
 	if err != nil {
-		return FindResult{}, fmt.Errorf("failed to get order ids: %w", err)
+		return nil, fmt.Errorf("failed to get orders: %w", err)
 	}
 
-	orders := make([]Order, len(unmarshedOrders))
+	orders := make([]Order, 0, len(unmarshedOrders))
 
-	for i, uOrder := range unmarshedOrders {
-		uOrder := uOrder.(string)
+	for _, uOrder := range unmarshedOrders {
+		// The order may have been deleted between the index read and the get.
+		uOrder, ok := uOrder.(string)
+		if !ok {
+			continue
+		}
 		var order Order
 
 		err := json.Unmarshal([]byte(uOrder), &order)
 		if err != nil {
-			return FindResult{}, fmt.Errorf("failed to decode order json: %w", err)
+			return nil, fmt.Errorf("failed to decode order json: %w", err)
 		}
 
-		orders[i] = order
+		orders = append(orders, order)
 	}
 
-	return FindResult{Orders: orders, Cursor: cursor}, nil
+	return orders, nil
+}
+
+// Reindex rebuilds the secondary indexes of every stored order. It serves orders written before
+// the indexes existed, which listings would not return otherwise.
+func (repo *RedisRepo) Reindex(ctx context.Context) error {
+	iter := repo.Client.Scan(ctx, 0, "order:*", 0).Iterator()
+
+	for iter.Next(ctx) {
+		key := iter.Val()
+
+		order, err := getOrder(ctx, repo.Client, key)
+		if errors.Is(err, ErrNotExist) {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		_, err = repo.Client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			for _, indexKey := range indexKeys(order) {
+				pipe.ZAdd(ctx, indexKey, indexEntry(order))
+			}
+			if order.DeletedAt != nil {
+				pipe.ZAdd(ctx, deletedIndexKey, deletedEntry(order))
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to index order %s: %w", key, err)
+		}
+	}
+
+	if err := iter.Err(); err != nil {
+		return fmt.Errorf("failed to scan orders: %w", err)
+	}
+
+	return nil
 }
@@ -0,0 +1,29 @@
+package order_test
+
+import (
+	"testing"
+
+	"first-little-server/order"
+	"first-little-server/order/repotest"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// newRedisClient returns a client of a redis server of its own, which lives as long as the test.
+func newRedisClient(t *testing.T) *redis.Client {
+	server := miniredis.RunT(t)
+
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() {
+		_ = client.Close()
+	})
+
+	return client
+}
+
+func TestRedisRepo(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) order.Repository {
+		return &order.RedisRepo{Client: newRedisClient(t), Stream: "orders:events", StreamMaxLen: 1000}
+	})
+}
@@ -0,0 +1,1251 @@
+// Package repotest provides the conformance suite that every order.Repository implementation must pass.
+//
+// A backend runs the suite from its own tests:
+//
+//	func TestMemoryRepo(t *testing.T) {
+//		repotest.Run(t, func(t *testing.T) order.Repository {
+//			return &order.MemoryRepo{}
+//		})
+//	}
+package repotest
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"testing"
+	"time"
+
+	"first-little-server/order"
+
+	"github.com/google/uuid"
+)
+
+// Factory returns an empty repository. It is called once per scenario,
+// backends sharing an external service must clear it before returning.
+type Factory func(t *testing.T) order.Repository
+
+// Run executes every scenario of the suite against the repositories built by newRepo.
+func Run(t *testing.T, newRepo Factory) {
+	scenarios := []struct {
+		name string
+		run  func(t *testing.T, repo order.Repository)
+	}{
+		{"InsertThenFind", testInsertThenFind},
+		{"InsertWithoutLineItems", testInsertWithoutLineItems},
+		{"InsertDuplicate", testInsertDuplicate},
+		{"InsertCompleted", testInsertCompleted},
+		{"InsertMixedCurrencies", testInsertMixedCurrencies},
+		{"FindUnknown", testFindUnknown},
+		{"Update", testUpdate},
+		{"UpdateUnknown", testUpdateUnknown},
+		{"UpdateInvalidTransition", testUpdateInvalidTransition},
+		{"UpdateGuardedTransition", testUpdateGuardedTransition},
+		{"UpdateLineItems", testUpdateLineItems},
+		{"UpdateLineItemsShipped", testUpdateLineItemsShipped},
+		{"Cancel", testCancel},
+		{"CancelShipped", testCancelShipped},
+		{"Delete", testDelete},
+		{"DeleteUnknown", testDeleteUnknown},
+		{"UpdateStaleVersion", testUpdateStaleVersion},
+		{"DeleteStaleVersion", testDeleteStaleVersion},
+		{"DeleteAnyVersion", testDeleteAnyVersion},
+		{"FindAllEmpty", testFindAllEmpty},
+		{"FindAllWalk", testFindAllWalk},
+		{"FindAllZeroSize", testFindAllZeroSize},
+		{"FindAllInvalidCursor", testFindAllInvalidCursor},
+		{"FindAllFilters", testFindAllFilters},
+		{"FindAllSort", testFindAllSort},
+		{"FindAllDeleted", testFindAllDeleted},
+		{"FindAllCancelled", testFindAllCancelled},
+		{"DeleteTwice", testDeleteTwice},
+		{"UpdateDeleted", testUpdateDeleted},
+		{"Restore", testRestore},
+		{"RestoreNotDeleted", testRestoreNotDeleted},
+		{"RestoreUnknown", testRestoreUnknown},
+		{"RestoreStaleVersion", testRestoreStaleVersion},
+		{"Purge", testPurge},
+		{"History", testHistory},
+		{"HistoryUnknown", testHistoryUnknown},
+		{"InsertReservesStock", testInsertReservesStock},
+		{"InsertInsufficientStock", testInsertInsufficientStock},
+		{"UpdateLineItemsStock", testUpdateLineItemsStock},
+		{"CancelReleasesStock", testCancelReleasesStock},
+		{"DeleteReleasesStock", testDeleteReleasesStock},
+	}
+
+	for _, scenario := range scenarios {
+		t.Run(scenario.name, func(t *testing.T) {
+			scenario.run(t, newRepo(t))
+		})
+	}
+}
+
+// NewOrder builds a pending order with the given id and number of line items priced in USD, along with its totals.
+// Timestamps are truncated to the microsecond, the finest precision every backend stores.
+func NewOrder(id int64, lineItems int) order.Order {
+	createdAt := time.Now().UTC().Truncate(time.Microsecond)
+
+	items := make([]order.LineItem, lineItems)
+	for i := range items {
+		items[i] = order.LineItem{
+			ItemID:   uuid.New(),
+			Quantity: uint(i + 1),
+			Price:    order.Money{Amount: int64(100 * (i + 1)), Currency: "USD"},
+		}
+	}
+
+	created := order.Order{
+		OrderID:    id,
+		CustomerID: uuid.New(),
+		Status:     order.StatusPending,
+		LineItems:  items,
+		CreatedAt:  &createdAt,
+		Version:    1,
+	}
+	_ = created.ComputeTotals()
+
+	return created
+}
+
+func testInsertThenFind(t *testing.T, repo order.Repository) {
+	ctx := context.Background()
+	want := NewOrder(1, 3)
+
+	mustInsert(t, repo, want)
+
+	got, err := repo.FindByID(ctx, want.OrderID)
+	if err != nil {
+		t.Fatalf("FindByID(%d): %v", want.OrderID, err)
+	}
+	AssertOrderEqual(t, want, got)
+}
+
+func testInsertWithoutLineItems(t *testing.T, repo order.Repository) {
+	ctx := context.Background()
+	want := NewOrder(1, 0)
+
+	mustInsert(t, repo, want)
+
+	got, err := repo.FindByID(ctx, want.OrderID)
+	if err != nil {
+		t.Fatalf("FindByID(%d): %v", want.OrderID, err)
+	}
+	AssertOrderEqual(t, want, got)
+
+	res, err := repo.FindAll(ctx, order.FindAllFilter{}, order.FindAllPage{Size: 10})
+	if err != nil {
+		t.Fatalf("FindAll: %v", err)
+	}
+	if len(res.Orders) != 1 {
+		t.Fatalf("FindAll returned %d orders, want 1", len(res.Orders))
+	}
+	AssertOrderEqual(t, want, res.Orders[0])
+}
+
+func testInsertDuplicate(t *testing.T, repo order.Repository) {
+	ctx := context.Background()
+	original := NewOrder(1, 2)
+	duplicate := NewOrder(1, 1)
+
+	mustInsert(t, repo, original)
+
+	if err := repo.Insert(ctx, duplicate); !errors.Is(err, order.ErrAlreadyExists) {
+		t.Fatalf("Insert of duplicate id %d: got %v, want ErrAlreadyExists", duplicate.OrderID, err)
+	}
+
+	got, err := repo.FindByID(ctx, original.OrderID)
+	if err != nil {
+		t.Fatalf("FindByID(%d): %v", original.OrderID, err)
+	}
+	AssertOrderEqual(t, original, got)
+}
+
+func testInsertCompleted(t *testing.T, repo order.Repository) {
+	ctx := context.Background()
+	want := NewOrder(1, 2)
+	shippedAt := want.CreatedAt.Add(time.Hour)
+	completedAt := shippedAt.Add(time.Hour)
+	want.ShippedAt = &shippedAt
+	want.CompletedAt = &completedAt
+	want.Status = order.StatusCompleted
+
+	mustInsert(t, repo, want)
+
+	got, err := repo.FindByID(ctx, want.OrderID)
+	if err != nil {
+		t.Fatalf("FindByID(%d): %v", want.OrderID, err)
+	}
+	AssertOrderEqual(t, want, got)
+}
+
+func testInsertMixedCurrencies(t *testing.T, repo order.Repository) {
+	ctx := context.Background()
+	mixed := NewOrder(1, 2)
+	mixed.LineItems[1].Price.Currency = "EUR"
+
+	if err := repo.Insert(ctx, mixed); !errors.Is(err, order.ErrMixedCurrencies) {
+		t.Fatalf("Insert of an order mixing currencies returned %v, want %v", err, order.ErrMixedCurrencies)
+	}
+
+	if _, err := repo.FindByID(ctx, mixed.OrderID); !errors.Is(err, order.ErrNotExist) {
+		t.Fatalf("FindByID(%d) returned %v, want %v", mixed.OrderID, err, order.ErrNotExist)
+	}
+}
+
+func testFindUnknown(t *testing.T, repo order.Repository) {
+	_, err := repo.FindByID(context.Background(), 42)
+	if !errors.Is(err, order.ErrNotExist) {
+		t.Fatalf("FindByID of unknown id returned %v, want %v", err, order.ErrNotExist)
+	}
+}
+
+func testUpdate(t *testing.T, repo order.Repository) {
+	ctx := context.Background()
+	want := NewOrder(1, 2)
+
+	mustInsert(t, repo, want)
+
+	want.Status = order.StatusPaid
+	if err := repo.Update(ctx, want); err != nil {
+		t.Fatalf("Update paid: %v", err)
+	}
+	want.Version++
+
+	shippedAt := want.CreatedAt.Add(time.Hour)
+	want.ShippedAt = &shippedAt
+	want.Status = order.StatusShipped
+	if err := repo.Update(ctx, want); err != nil {
+		t.Fatalf("Update shipped: %v", err)
+	}
+	want.Version++
+
+	completedAt := shippedAt.Add(time.Hour)
+	want.CompletedAt = &completedAt
+	want.Status = order.StatusCompleted
+	if err := repo.Update(ctx, want); err != nil {
+		t.Fatalf("Update completed: %v", err)
+	}
+	want.Version++
+
+	got, err := repo.FindByID(ctx, want.OrderID)
+	if err != nil {
+		t.Fatalf("FindByID(%d): %v", want.OrderID, err)
+	}
+	AssertOrderEqual(t, want, got)
+}
+
+func testUpdateUnknown(t *testing.T, repo order.Repository) {
+	ctx := context.Background()
+
+	err := repo.Update(ctx, NewOrder(42, 1))
+	if !errors.Is(err, order.ErrNotExist) {
+		t.Fatalf("Update of unknown id returned %v, want %v", err, order.ErrNotExist)
+	}
+
+	if _, err := repo.FindByID(ctx, 42); !errors.Is(err, order.ErrNotExist) {
+		t.Fatalf("Update of unknown id created the order, FindByID returned %v", err)
+	}
+}
+
+func testDelete(t *testing.T, repo order.Repository) {
+	ctx := context.Background()
+	kept := NewOrder(1, 1)
+	deleted := NewOrder(2, 2)
+
+	mustInsert(t, repo, kept)
+	mustInsert(t, repo, deleted)
+
+	if err := repo.DeleteByID(ctx, deleted.OrderID, deleted.Version); err != nil {
+		t.Fatalf("DeleteByID(%d): %v", deleted.OrderID, err)
+	}
+
+	if _, err := repo.FindByID(ctx, deleted.OrderID); !errors.Is(err, order.ErrNotExist) {
+		t.Fatalf("FindByID of deleted order returned %v, want %v", err, order.ErrNotExist)
+	}
+
+	got := walk(t, repo, order.FindAllFilter{}, order.FindAllPage{Size: 10})
+	if len(got) != 1 {
+		t.Fatalf("FindAll returned %d orders after delete, want 1", len(got))
+	}
+	AssertOrderEqual(t, kept, got[0])
+
+	// The order is soft deleted, its tombstone bumped the version.
+	tombstone, err := repo.FindAnyByID(ctx, deleted.OrderID)
+	if err != nil {
+		t.Fatalf("FindAnyByID(%d) of deleted order: %v", deleted.OrderID, err)
+	}
+	if tombstone.DeletedAt == nil {
+		t.Fatalf("order %d: deleted_at is nil after delete", deleted.OrderID)
+	}
+	deleted.Version++
+	deleted.DeletedAt = tombstone.DeletedAt
+	AssertOrderEqual(t, deleted, tombstone)
+}
+
+func testDeleteTwice(t *testing.T, repo order.Repository) {
+	ctx := context.Background()
+	want := NewOrder(1, 1)
+
+	mustInsert(t, repo, want)
+
+	if err := repo.DeleteByID(ctx, want.OrderID, 0); err != nil {
+		t.Fatalf("DeleteByID: %v", err)
+	}
+
+	if err := repo.DeleteByID(ctx, want.OrderID, 0); !errors.Is(err, order.ErrNotExist) {
+		t.Fatalf("DeleteByID of deleted order returned %v, want %v", err, order.ErrNotExist)
+	}
+}
+
+func testUpdateInvalidTransition(t *testing.T, repo order.Repository) {
+	ctx := context.Background()
+	want := NewOrder(1, 1)
+
+	mustInsert(t, repo, want)
+
+	// A pending order must be paid before being shipped.
+	shipped := want
+	shippedAt := want.CreatedAt.Add(time.Hour)
+	shipped.ShippedAt = &shippedAt
+	shipped.Status = order.StatusShipped
+	if err := repo.Update(ctx, shipped); !errors.Is(err, order.ErrInvalidTransition) {
+		t.Fatalf("Update from pending to shipped returned %v, want %v", err, order.ErrInvalidTransition)
+	}
+
+	got, err := repo.FindByID(ctx, want.OrderID)
+	if err != nil {
+		t.Fatalf("FindByID(%d): %v", want.OrderID, err)
+	}
+	AssertOrderEqual(t, want, got)
+}
+
+func testUpdateGuardedTransition(t *testing.T, repo order.Repository) {
+	ctx := context.Background()
+	want := NewOrder(1, 0)
+
+	mustInsert(t, repo, want)
+
+	// The default transitions only let orders with line items be paid.
+	paid := want
+	paid.Status = order.StatusPaid
+	if err := repo.Update(ctx, paid); !errors.Is(err, order.ErrInvalidTransition) {
+		t.Fatalf("Update of an order without line items to paid returned %v, want %v", err, order.ErrInvalidTransition)
+	}
+
+	got, err := repo.FindByID(ctx, want.OrderID)
+	if err != nil {
+		t.Fatalf("FindByID(%d): %v", want.OrderID, err)
+	}
+	AssertOrderEqual(t, want, got)
+}
+
+func testUpdateLineItems(t *testing.T, repo order.Repository) {
+	ctx := context.Background()
+	want := NewOrder(1, 2)
+
+	mustInsert(t, repo, want)
+
+	removed := want.LineItems[0].ItemID
+	added := order.LineItem{ItemID: uuid.New(), Quantity: 3, Price: order.Money{Amount: 250, Currency: "USD"}}
+	if err := order.RemoveLineItem(&want, removed); err != nil {
+		t.Fatalf("RemoveLineItem: %v", err)
+	}
+	if err := order.SetLineItemQuantity(&want, want.LineItems[0].ItemID, 7); err != nil {
+		t.Fatalf("SetLineItemQuantity: %v", err)
+	}
+	if err := order.AddLineItem(&want, added); err != nil {
+		t.Fatalf("AddLineItem: %v", err)
+	}
+
+	if err := repo.Update(ctx, want); err != nil {
+		t.Fatalf("Update(%d) of the line items: %v", want.OrderID, err)
+	}
+	want.Version++
+
+	got, err := repo.FindByID(ctx, want.OrderID)
+	if err != nil {
+		t.Fatalf("FindByID(%d): %v", want.OrderID, err)
+	}
+	AssertOrderEqual(t, want, got)
+
+	// The item filter follows the edits.
+	for _, c := range []struct {
+		itemID uuid.UUID
+		want   []int64
+	}{
+		{removed, nil},
+		{added.ItemID, []int64{1}},
+	} {
+		found := walk(t, repo, order.FindAllFilter{ItemID: c.itemID}, order.FindAllPage{Size: 1})
+		if !equalIDs(ids(found), c.want) {
+			t.Fatalf("FindAll of item %s returned ids %v, want %v", c.itemID, ids(found), c.want)
+		}
+	}
+}
+
+func testUpdateLineItemsShipped(t *testing.T, repo order.Repository) {
+	ctx := context.Background()
+	want := NewOrder(1, 1)
+	shippedAt := want.CreatedAt.Add(time.Hour)
+	want.ShippedAt = &shippedAt
+
+	mustInsert(t, repo, want)
+
+	// Line items are frozen once the order has been shipped.
+	edited := want
+	if err := order.AddLineItem(&edited, order.LineItem{ItemID: uuid.New(), Quantity: 1, Price: order.Money{Amount: 100, Currency: "USD"}}); err != nil {
+		t.Fatalf("AddLineItem: %v", err)
+	}
+	if err := repo.Update(ctx, edited); !errors.Is(err, order.ErrLineItemsFrozen) {
+		t.Fatalf("Update of the line items of a shipped order returned %v, want %v", err, order.ErrLineItemsFrozen)
+	}
+
+	got, err := repo.FindByID(ctx, want.OrderID)
+	if err != nil {
+		t.Fatalf("FindByID(%d): %v", want.OrderID, err)
+	}
+	AssertOrderEqual(t, want, got)
+}
+
+func testCancel(t *testing.T, repo order.Repository) {
+	ctx := context.Background()
+	want := NewOrder(1, 1)
+
+	mustInsert(t, repo, want)
+
+	cancelledAt := want.CreatedAt.Add(time.Hour)
+	want.Status = order.StatusCancelled
+	want.CancelledAt = &cancelledAt
+	want.CancelReason = &order.CancelReason{Code: order.CancelCustomerRequest, Note: "ordered twice"}
+	if err := repo.Update(ctx, want); err != nil {
+		t.Fatalf("Update(%d) to cancelled: %v", want.OrderID, err)
+	}
+	want.Version++
+
+	got, err := repo.FindByID(ctx, want.OrderID)
+	if err != nil {
+		t.Fatalf("FindByID(%d): %v", want.OrderID, err)
+	}
+	AssertOrderEqual(t, want, got)
+}
+
+func testCancelShipped(t *testing.T, repo order.Repository) {
+	ctx := context.Background()
+	want := NewOrder(1, 1)
+	shippedAt := want.CreatedAt.Add(time.Hour)
+	want.ShippedAt = &shippedAt
+
+	mustInsert(t, repo, want)
+	mustAdvance(t, repo, want, order.StatusPaid, order.StatusShipped)
+	want.Status = order.StatusShipped
+	want.Version += 2
+
+	// Orders may only be cancelled before shipment.
+	cancelled := want
+	cancelledAt := shippedAt.Add(time.Hour)
+	cancelled.Status = order.StatusCancelled
+	cancelled.CancelledAt = &cancelledAt
+	cancelled.CancelReason = &order.CancelReason{Code: order.CancelOther}
+	if err := repo.Update(ctx, cancelled); !errors.Is(err, order.ErrInvalidTransition) {
+		t.Fatalf("Update from shipped to cancelled returned %v, want %v", err, order.ErrInvalidTransition)
+	}
+
+	got, err := repo.FindByID(ctx, want.OrderID)
+	if err != nil {
+		t.Fatalf("FindByID(%d): %v", want.OrderID, err)
+	}
+	AssertOrderEqual(t, want, got)
+}
+
+func testUpdateDeleted(t *testing.T, repo order.Repository) {
+	ctx := context.Background()
+	want := NewOrder(1, 1)
+
+	mustInsert(t, repo, want)
+
+	if err := repo.DeleteByID(ctx, want.OrderID, want.Version); err != nil {
+		t.Fatalf("DeleteByID: %v", err)
+	}
+
+	shippedAt := want.CreatedAt.Add(time.Hour)
+	want.ShippedAt = &shippedAt
+	want.Version++
+	if err := repo.Update(ctx, want); !errors.Is(err, order.ErrNotExist) {
+		t.Fatalf("Update of deleted order returned %v, want %v", err, order.ErrNotExist)
+	}
+}
+
+func testRestore(t *testing.T, repo order.Repository) {
+	ctx := context.Background()
+	want := NewOrder(1, 2)
+
+	mustInsert(t, repo, want)
+
+	if err := repo.DeleteByID(ctx, want.OrderID, want.Version); err != nil {
+		t.Fatalf("DeleteByID: %v", err)
+	}
+	want.Version++
+
+	if err := repo.Restore(ctx, want.OrderID, want.Version); err != nil {
+		t.Fatalf("Restore(%d): %v", want.OrderID, err)
+	}
+	want.Version++
+
+	got, err := repo.FindByID(ctx, want.OrderID)
+	if err != nil {
+		t.Fatalf("FindByID(%d) after restore: %v", want.OrderID, err)
+	}
+	AssertOrderEqual(t, want, got)
+
+	listed := walk(t, repo, order.FindAllFilter{}, order.FindAllPage{Size: 10})
+	if !equalIDs(ids(listed), []int64{want.OrderID}) {
+		t.Fatalf("FindAll after restore returned ids %v, want [%d]", ids(listed), want.OrderID)
+	}
+}
+
+func testRestoreNotDeleted(t *testing.T, repo order.Repository) {
+	want := NewOrder(1, 1)
+
+	mustInsert(t, repo, want)
+
+	err := repo.Restore(context.Background(), want.OrderID, 0)
+	if !errors.Is(err, order.ErrNotDeleted) {
+		t.Fatalf("Restore of live order returned %v, want %v", err, order.ErrNotDeleted)
+	}
+}
+
+func testRestoreUnknown(t *testing.T, repo order.Repository) {
+	err := repo.Restore(context.Background(), 42, 0)
+	if !errors.Is(err, order.ErrNotExist) {
+		t.Fatalf("Restore of unknown id returned %v, want %v", err, order.ErrNotExist)
+	}
+}
+
+func testRestoreStaleVersion(t *testing.T, repo order.Repository) {
+	ctx := context.Background()
+	want := NewOrder(1, 1)
+
+	mustInsert(t, repo, want)
+
+	if err := repo.DeleteByID(ctx, want.OrderID, want.Version); err != nil {
+		t.Fatalf("DeleteByID: %v", err)
+	}
+
+	// The version read before the delete is stale.
+	if err := repo.Restore(ctx, want.OrderID, want.Version); !errors.Is(err, order.ErrConflict) {
+		t.Fatalf("Restore with a stale version returned %v, want %v", err, order.ErrConflict)
+	}
+}
+
+func testPurge(t *testing.T, repo order.Repository) {
+	ctx := context.Background()
+	kept := NewOrder(1, 1)
+	deleted := NewOrder(2, 2)
+
+	mustInsert(t, repo, kept)
+	mustInsert(t, repo, deleted)
+
+	if err := repo.DeleteByID(ctx, deleted.OrderID, 0); err != nil {
+		t.Fatalf("DeleteByID: %v", err)
+	}
+
+	// Orders deleted after the bound stay.
+	purged, err := repo.Purge(ctx, time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("Purge: %v", err)
+	}
+	if purged != 0 {
+		t.Fatalf("Purge before the deletion removed %d orders, want 0", purged)
+	}
+
+	purged, err = repo.Purge(ctx, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("Purge: %v", err)
+	}
+	if purged != 1 {
+		t.Fatalf("Purge removed %d orders, want 1", purged)
+	}
+
+	if _, err := repo.FindAnyByID(ctx, deleted.OrderID); !errors.Is(err, order.ErrNotExist) {
+		t.Fatalf("FindAnyByID of purged order returned %v, want %v", err, order.ErrNotExist)
+	}
+
+	got := walk(t, repo, order.FindAllFilter{Deleted: order.DeletedInclude}, order.FindAllPage{Size: 10})
+	if !equalIDs(ids(got), []int64{kept.OrderID}) {
+		t.Fatalf("FindAll after purge returned ids %v, want [%d]", ids(got), kept.OrderID)
+	}
+
+	if _, err := repo.History(ctx, deleted.OrderID); !errors.Is(err, order.ErrNotExist) {
+		t.Fatalf("History of purged order returned %v, want %v", err, order.ErrNotExist)
+	}
+}
+
+func testHistory(t *testing.T, repo order.Repository) {
+	const actor = "repotest"
+	ctx := order.WithActor(context.Background(), actor)
+	want := NewOrder(1, 1)
+
+	if err := repo.Insert(ctx, want); err != nil {
+		t.Fatalf("Insert(%d): %v", want.OrderID, err)
+	}
+
+	paid := want
+	paid.Status = order.StatusPaid
+	if err := repo.Update(ctx, paid); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+
+	if err := repo.DeleteByID(ctx, want.OrderID, 0); err != nil {
+		t.Fatalf("DeleteByID: %v", err)
+	}
+
+	if err := repo.Restore(ctx, want.OrderID, 0); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+
+	history, err := repo.History(ctx, want.OrderID)
+	if err != nil {
+		t.Fatalf("History(%d): %v", want.OrderID, err)
+	}
+
+	wantTypes := []order.EventType{order.EventCreated, order.EventUpdated, order.EventDeleted, order.EventRestored}
+	if len(history) != len(wantTypes) {
+		t.Fatalf("History returned %d events, want %d", len(history), len(wantTypes))
+	}
+
+	for i, event := range history {
+		if event.Type != wantTypes[i] {
+			t.Fatalf("event %d: type = %s, want %s", i, event.Type, wantTypes[i])
+		}
+		if event.OrderID != want.OrderID {
+			t.Fatalf("event %d: order id = %d, want %d", i, event.OrderID, want.OrderID)
+		}
+		if event.Actor != actor {
+			t.Fatalf("event %d: actor = %q, want %q", i, event.Actor, actor)
+		}
+		if event.Version != int64(i)+1 {
+			t.Fatalf("event %d: version = %d, want %d", i, event.Version, i+1)
+		}
+	}
+
+	status := findChange(t, history[1], "status")
+	if status.Old == nil || *status.Old != string(order.StatusPending) || status.New == nil || *status.New != string(order.StatusPaid) {
+		t.Fatalf("update event: status change = %v -> %v, want pending -> paid", status.Old, status.New)
+	}
+	if change := findChange(t, history[2], "deleted_at"); change.Old != nil || change.New == nil {
+		t.Fatalf("delete event: deleted_at change = %v -> %v, want unset -> set", change.Old, change.New)
+	}
+	if change := findChange(t, history[3], "deleted_at"); change.Old == nil || change.New != nil {
+		t.Fatalf("restore event: deleted_at change = %v -> %v, want set -> unset", change.Old, change.New)
+	}
+}
+
+func findChange(t *testing.T, event order.Event, field string) order.Change {
+	t.Helper()
+
+	for _, change := range event.Changes {
+		if change.Field == field {
+			return change
+		}
+	}
+
+	t.Fatalf("%s event has no %s change: %+v", event.Type, field, event.Changes)
+	return order.Change{}
+}
+
+func testHistoryUnknown(t *testing.T, repo order.Repository) {
+	_, err := repo.History(context.Background(), 42)
+	if !errors.Is(err, order.ErrNotExist) {
+		t.Fatalf("History of unknown id returned %v, want %v", err, order.ErrNotExist)
+	}
+}
+
+func testDeleteUnknown(t *testing.T, repo order.Repository) {
+	err := repo.DeleteByID(context.Background(), 42, 0)
+	if !errors.Is(err, order.ErrNotExist) {
+		t.Fatalf("DeleteByID of unknown id returned %v, want %v", err, order.ErrNotExist)
+	}
+}
+
+func testUpdateStaleVersion(t *testing.T, repo order.Repository) {
+	ctx := context.Background()
+	want := NewOrder(1, 1)
+
+	mustInsert(t, repo, want)
+
+	first := want
+	first.Status = order.StatusPaid
+	if err := repo.Update(ctx, first); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+
+	// The second writer read the order before the first update.
+	second := want
+	second.Status = order.StatusCancelled
+	if err := repo.Update(ctx, second); !errors.Is(err, order.ErrConflict) {
+		t.Fatalf("Update with a stale version returned %v, want %v", err, order.ErrConflict)
+	}
+
+	got, err := repo.FindByID(ctx, want.OrderID)
+	if err != nil {
+		t.Fatalf("FindByID(%d): %v", want.OrderID, err)
+	}
+	first.Version++
+	AssertOrderEqual(t, first, got)
+}
+
+func testDeleteStaleVersion(t *testing.T, repo order.Repository) {
+	ctx := context.Background()
+	want := NewOrder(1, 2)
+
+	mustInsert(t, repo, want)
+
+	if err := repo.DeleteByID(ctx, want.OrderID, want.Version+1); !errors.Is(err, order.ErrConflict) {
+		t.Fatalf("DeleteByID with a stale version returned %v, want %v", err, order.ErrConflict)
+	}
+
+	got, err := repo.FindByID(ctx, want.OrderID)
+	if err != nil {
+		t.Fatalf("FindByID(%d) after a conflicting delete: %v", want.OrderID, err)
+	}
+	AssertOrderEqual(t, want, got)
+}
+
+func testDeleteAnyVersion(t *testing.T, repo order.Repository) {
+	ctx := context.Background()
+	want := NewOrder(1, 1)
+
+	mustInsert(t, repo, want)
+
+	if err := repo.DeleteByID(ctx, want.OrderID, 0); err != nil {
+		t.Fatalf("DeleteByID without version: %v", err)
+	}
+
+	if _, err := repo.FindByID(ctx, want.OrderID); !errors.Is(err, order.ErrNotExist) {
+		t.Fatalf("FindByID of deleted order returned %v, want %v", err, order.ErrNotExist)
+	}
+}
+
+func testFindAllEmpty(t *testing.T, repo order.Repository) {
+	res, err := repo.FindAll(context.Background(), order.FindAllFilter{}, order.FindAllPage{Size: 10})
+	if err != nil {
+		t.Fatalf("FindAll: %v", err)
+	}
+	if len(res.Orders) != 0 {
+		t.Fatalf("FindAll returned %d orders from an empty repository", len(res.Orders))
+	}
+	if res.Cursor != "" {
+		t.Fatalf("FindAll returned cursor %q from an empty repository, want an empty cursor", res.Cursor)
+	}
+}
+
+func testFindAllWalk(t *testing.T, repo order.Repository) {
+	const count = 23
+
+	want := make(map[int64]order.Order, count)
+	for i := int64(1); i <= count; i++ {
+		// Every third order has no line item, they must be listed as well.
+		created := NewOrder(i, int(i%3))
+		mustInsert(t, repo, created)
+		want[created.OrderID] = created
+	}
+
+	for _, size := range []uint{1, 5, count, 50} {
+		got := walk(t, repo, order.FindAllFilter{}, order.FindAllPage{Size: size})
+
+		if len(got) != count {
+			t.Fatalf("walk with page size %d returned %d orders, want %d", size, len(got), count)
+		}
+
+		seen := make(map[int64]bool, count)
+		for _, found := range got {
+			if seen[found.OrderID] {
+				t.Fatalf("walk with page size %d returned order %d twice", size, found.OrderID)
+			}
+			seen[found.OrderID] = true
+
+			expected, exist := want[found.OrderID]
+			if !exist {
+				t.Fatalf("walk with page size %d returned unknown order %d", size, found.OrderID)
+			}
+			AssertOrderEqual(t, expected, found)
+		}
+	}
+}
+
+func testFindAllZeroSize(t *testing.T, repo order.Repository) {
+	mustInsert(t, repo, NewOrder(1, 1))
+	mustInsert(t, repo, NewOrder(2, 1))
+
+	res, err := repo.FindAll(context.Background(), order.FindAllFilter{}, order.FindAllPage{Size: 0})
+	if err != nil {
+		t.Fatalf("FindAll: %v", err)
+	}
+	if len(res.Orders) != 0 {
+		t.Fatalf("FindAll with a page size of 0 returned %d orders, want none", len(res.Orders))
+	}
+	if res.Cursor != "" {
+		t.Fatalf("FindAll with a page size of 0 returned cursor %q, want an empty cursor", res.Cursor)
+	}
+}
+
+func testFindAllInvalidCursor(t *testing.T, repo order.Repository) {
+	mustInsert(t, repo, NewOrder(1, 1))
+
+	page := order.FindAllPage{Size: 10, Cursor: "not a cursor!"}
+	_, err := repo.FindAll(context.Background(), order.FindAllFilter{}, page)
+	if !errors.Is(err, order.ErrInvalidCursor) {
+		t.Fatalf("FindAll with an invalid cursor returned %v, want %v", err, order.ErrInvalidCursor)
+	}
+}
+
+func testFindAllFilters(t *testing.T, repo order.Repository) {
+	base := time.Now().UTC().Truncate(time.Microsecond)
+	customer := uuid.New()
+	item := uuid.New()
+
+	at := func(hours int) *time.Time {
+		ts := base.Add(time.Duration(hours) * time.Hour)
+		return &ts
+	}
+
+	pending := NewOrder(1, 1)
+	pending.CustomerID = customer
+	pending.CreatedAt = at(0)
+	pending.LineItems[0].ItemID = item
+
+	shipped := NewOrder(2, 2)
+	shipped.CreatedAt = at(1)
+	shipped.ShippedAt = at(2)
+	shipped.LineItems[1].ItemID = item
+
+	completed := NewOrder(3, 1)
+	completed.CustomerID = customer
+	completed.CreatedAt = at(3)
+	completed.ShippedAt = at(4)
+	completed.CompletedAt = at(5)
+
+	mustInsert(t, repo, pending)
+	mustInsert(t, repo, shipped)
+	mustInsert(t, repo, completed)
+
+	// Statuses are stored through Update, as the handlers do.
+	mustAdvance(t, repo, shipped, order.StatusPaid, order.StatusShipped)
+	mustAdvance(t, repo, completed, order.StatusPaid, order.StatusShipped, order.StatusCompleted)
+
+	cases := []struct {
+		name   string
+		filter order.FindAllFilter
+		want   []int64
+	}{
+		{"None", order.FindAllFilter{}, []int64{1, 2, 3}},
+		{"Customer", order.FindAllFilter{CustomerID: customer}, []int64{1, 3}},
+		{"UnknownCustomer", order.FindAllFilter{CustomerID: uuid.New()}, nil},
+		{"StatusPending", order.FindAllFilter{Status: order.StatusPending}, []int64{1}},
+		{"StatusShipped", order.FindAllFilter{Status: order.StatusShipped}, []int64{2}},
+		{"StatusCompleted", order.FindAllFilter{Status: order.StatusCompleted}, []int64{3}},
+		{"Item", order.FindAllFilter{ItemID: item}, []int64{1, 2}},
+		{"CreatedRange", order.FindAllFilter{CreatedAfter: at(1), CreatedBefore: at(3)}, []int64{2}},
+		{"CreatedAfter", order.FindAllFilter{CreatedAfter: at(1)}, []int64{2, 3}},
+		{"ShippedBefore", order.FindAllFilter{ShippedBefore: at(3)}, []int64{2}},
+		{"ShippedAfter", order.FindAllFilter{ShippedAfter: at(2)}, []int64{2, 3}},
+		{"Combined", order.FindAllFilter{CustomerID: customer, Status: order.StatusCompleted, CreatedAfter: at(0)}, []int64{3}},
+	}
+
+	for _, c := range cases {
+		got := walk(t, repo, c.filter, order.FindAllPage{Size: 1})
+		if !equalIDs(ids(got), c.want) {
+			t.Fatalf("filter %s returned orders %v, want %v", c.name, ids(got), c.want)
+		}
+	}
+}
+
+func testFindAllSort(t *testing.T, repo order.Repository) {
+	const count = 7
+	base := time.Now().UTC().Truncate(time.Microsecond)
+
+	// Creation times run against the ids so that sorting by id would be caught.
+	var ascending []int64
+	for i := int64(count); i >= 1; i-- {
+		created := NewOrder(i, 1)
+		createdAt := base.Add(time.Duration(count-i) * time.Minute)
+		created.CreatedAt = &createdAt
+		mustInsert(t, repo, created)
+		ascending = append(ascending, i)
+	}
+
+	descending := make([]int64, count)
+	for i, id := range ascending {
+		descending[count-1-i] = id
+	}
+
+	for _, size := range []uint{1, 3, count} {
+		got := ids(walk(t, repo, order.FindAllFilter{}, order.FindAllPage{Size: size}))
+		if !equalIDs(got, ascending) {
+			t.Fatalf("ascending walk with page size %d returned %v, want %v", size, got, ascending)
+		}
+
+		page := order.FindAllPage{Size: size, Sort: order.SortCreatedDesc}
+		got = ids(walk(t, repo, order.FindAllFilter{}, page))
+		if !equalIDs(got, descending) {
+			t.Fatalf("descending walk with page size %d returned %v, want %v", size, got, descending)
+		}
+	}
+}
+
+// walk follows the FindAll cursor from page until the end of the data and returns every order seen.
+func testFindAllDeleted(t *testing.T, repo order.Repository) {
+	ctx := context.Background()
+
+	for i := int64(1); i <= 4; i++ {
+		mustInsert(t, repo, NewOrder(i, 1))
+	}
+
+	for _, id := range []int64{2, 4} {
+		if err := repo.DeleteByID(ctx, id, 0); err != nil {
+			t.Fatalf("DeleteByID(%d): %v", id, err)
+		}
+	}
+
+	cases := []struct {
+		name    string
+		deleted order.DeletedFilter
+		want    []int64
+	}{
+		{"Exclude", order.DeletedExclude, []int64{1, 3}},
+		{"Include", order.DeletedInclude, []int64{1, 2, 3, 4}},
+		{"Only", order.DeletedOnly, []int64{2, 4}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// A page size of one makes the listing skip tombstones across pages.
+			got := walk(t, repo, order.FindAllFilter{Deleted: c.deleted}, order.FindAllPage{Size: 1})
+			if !equalIDs(ids(got), c.want) {
+				t.Fatalf("FindAll returned ids %v, want %v", ids(got), c.want)
+			}
+		})
+	}
+}
+
+func testFindAllCancelled(t *testing.T, repo order.Repository) {
+	inserted := make([]order.Order, 4)
+	for i := range inserted {
+		inserted[i] = NewOrder(int64(i+1), 1)
+		mustInsert(t, repo, inserted[i])
+	}
+
+	for _, id := range []int64{2, 4} {
+		cancelled := inserted[id-1]
+		cancelled.Status = order.StatusCancelled
+		cancelled.CancelReason = &order.CancelReason{Code: order.CancelOutOfStock}
+		if err := repo.Update(context.Background(), cancelled); err != nil {
+			t.Fatalf("Update(%d) to cancelled: %v", cancelled.OrderID, err)
+		}
+	}
+
+	cases := []struct {
+		name   string
+		filter order.FindAllFilter
+		want   []int64
+	}{
+		{"Exclude", order.FindAllFilter{}, []int64{1, 3}},
+		{"Include", order.FindAllFilter{Cancelled: order.CancelledInclude}, []int64{1, 2, 3, 4}},
+		{"Status", order.FindAllFilter{Status: order.StatusCancelled}, []int64{2, 4}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := walk(t, repo, c.filter, order.FindAllPage{Size: 1})
+			if !equalIDs(ids(got), c.want) {
+				t.Fatalf("FindAll returned ids %v, want %v", ids(got), c.want)
+			}
+		})
+	}
+}
+
+// inventoryOf returns the inventory of repo, skipping the scenario for the repositories without one.
+func inventoryOf(t *testing.T, repo order.Repository) order.Inventory {
+	inventory, ok := repo.(order.Inventory)
+	if !ok {
+		t.Skip("the repository keeps no inventory")
+	}
+
+	return inventory
+}
+
+func mustSetStock(t *testing.T, inventory order.Inventory, itemID uuid.UUID, available int64) {
+	t.Helper()
+
+	if err := inventory.SetStock(context.Background(), itemID, available); err != nil {
+		t.Fatalf("SetStock(%s): %v", itemID, err)
+	}
+}
+
+func assertStock(t *testing.T, inventory order.Inventory, itemID uuid.UUID, want int64) {
+	t.Helper()
+
+	got, tracked, err := inventory.Stock(context.Background(), itemID)
+	if err != nil {
+		t.Fatalf("Stock(%s): %v", itemID, err)
+	}
+
+	if !tracked || got != want {
+		t.Errorf("Stock(%s) = %d, %v, want %d, true", itemID, got, tracked, want)
+	}
+}
+
+func testInsertReservesStock(t *testing.T, repo order.Repository) {
+	inventory := inventoryOf(t, repo)
+	want := NewOrder(1, 2)
+	// The second item is not tracked, its quantity is unlimited.
+	tracked := want.LineItems[0]
+	mustSetStock(t, inventory, tracked.ItemID, 5)
+
+	mustInsert(t, repo, want)
+
+	assertStock(t, inventory, tracked.ItemID, 5-int64(tracked.Quantity))
+	if _, ok, _ := inventory.Stock(context.Background(), want.LineItems[1].ItemID); ok {
+		t.Errorf("Stock(%s) is tracked after an insert", want.LineItems[1].ItemID)
+	}
+}
+
+func testInsertInsufficientStock(t *testing.T, repo order.Repository) {
+	ctx := context.Background()
+	inventory := inventoryOf(t, repo)
+	want := NewOrder(1, 2)
+	// The first item has enough stock, the second one does not.
+	mustSetStock(t, inventory, want.LineItems[0].ItemID, 10)
+	mustSetStock(t, inventory, want.LineItems[1].ItemID, 1)
+
+	err := repo.Insert(ctx, want)
+
+	var insufficient *order.InsufficientStockError
+	if !errors.As(err, &insufficient) {
+		t.Fatalf("Insert returned %v, want an InsufficientStockError", err)
+	}
+
+	shortage := order.Shortage{ItemID: want.LineItems[1].ItemID, Requested: 2, Available: 1}
+	if len(insufficient.Shortages) != 1 || insufficient.Shortages[0] != shortage {
+		t.Errorf("Insert shortages = %+v, want [%+v]", insufficient.Shortages, shortage)
+	}
+
+	// Nothing is written when the stock runs short.
+	if _, err := repo.FindAnyByID(ctx, want.OrderID); !errors.Is(err, order.ErrNotExist) {
+		t.Errorf("FindAnyByID(%d) returned %v, want %v", want.OrderID, err, order.ErrNotExist)
+	}
+	assertStock(t, inventory, want.LineItems[0].ItemID, 10)
+	assertStock(t, inventory, want.LineItems[1].ItemID, 1)
+}
+
+func testUpdateLineItemsStock(t *testing.T, repo order.Repository) {
+	ctx := context.Background()
+	inventory := inventoryOf(t, repo)
+	want := NewOrder(1, 1)
+	itemID := want.LineItems[0].ItemID
+	mustSetStock(t, inventory, itemID, 3)
+
+	mustInsert(t, repo, want)
+	assertStock(t, inventory, itemID, 2)
+
+	// Only the additional quantity is reserved.
+	short := want
+	short.LineItems = []order.LineItem{want.LineItems[0]}
+	short.LineItems[0].Quantity = 4
+	if err := repo.Update(ctx, short); !errors.Is(err, order.ErrInsufficientStock) {
+		t.Fatalf("Update(%d) beyond the stock returned %v, want %v", want.OrderID, err, order.ErrInsufficientStock)
+	}
+	assertStock(t, inventory, itemID, 2)
+
+	short.LineItems[0].Quantity = 3
+	if err := repo.Update(ctx, short); err != nil {
+		t.Fatalf("Update(%d): %v", want.OrderID, err)
+	}
+	assertStock(t, inventory, itemID, 0)
+}
+
+func testCancelReleasesStock(t *testing.T, repo order.Repository) {
+	ctx := context.Background()
+	inventory := inventoryOf(t, repo)
+	want := NewOrder(1, 1)
+	itemID := want.LineItems[0].ItemID
+	mustSetStock(t, inventory, itemID, 1)
+
+	mustInsert(t, repo, want)
+	assertStock(t, inventory, itemID, 0)
+
+	cancelledAt := want.CreatedAt.Add(time.Hour)
+	want.Status = order.StatusCancelled
+	want.CancelledAt = &cancelledAt
+	want.CancelReason = &order.CancelReason{Code: order.CancelOutOfStock}
+	if err := repo.Update(ctx, want); err != nil {
+		t.Fatalf("Update(%d) to cancelled: %v", want.OrderID, err)
+	}
+	assertStock(t, inventory, itemID, 1)
+}
+
+func testDeleteReleasesStock(t *testing.T, repo order.Repository) {
+	ctx := context.Background()
+	inventory := inventoryOf(t, repo)
+	deleted := NewOrder(1, 1)
+	itemID := deleted.LineItems[0].ItemID
+	mustSetStock(t, inventory, itemID, 1)
+
+	mustInsert(t, repo, deleted)
+	if err := repo.DeleteByID(ctx, deleted.OrderID, 0); err != nil {
+		t.Fatalf("DeleteByID(%d): %v", deleted.OrderID, err)
+	}
+	assertStock(t, inventory, itemID, 1)
+
+	// Another order takes the released stock, the deleted one may not be restored anymore.
+	other := NewOrder(2, 0)
+	other.LineItems = deleted.LineItems
+	mustInsert(t, repo, other)
+
+	if err := repo.Restore(ctx, deleted.OrderID, 0); !errors.Is(err, order.ErrInsufficientStock) {
+		t.Fatalf("Restore(%d) returned %v, want %v", deleted.OrderID, err, order.ErrInsufficientStock)
+	}
+	assertStock(t, inventory, itemID, 0)
+}
+
+func walk(t *testing.T, repo order.Repository, filter order.FindAllFilter, page order.FindAllPage) []order.Order {
+	t.Helper()
+
+	// Guard against backends that never return the end cursor.
+	const maxPages = 1000
+
+	var orders []order.Order
+	for pages := 0; pages < maxPages; pages++ {
+		res, err := repo.FindAll(context.Background(), filter, page)
+		if err != nil {
+			t.Fatalf("FindAll(size=%d, cursor=%q): %v", page.Size, page.Cursor, err)
+		}
+
+		orders = append(orders, res.Orders...)
+
+		if res.Cursor == "" {
+			return orders
+		}
+		page.Cursor = res.Cursor
+	}
+
+	t.Fatalf("FindAll with page size %d did not reach the end after %d pages", page.Size, maxPages)
+	return nil
+}
+
+func ids(orders []order.Order) []int64 {
+	var ids []int64
+	for _, o := range orders {
+		ids = append(ids, o.OrderID)
+	}
+
+	return ids
+}
+
+func equalIDs(got, want []int64) bool {
+	if len(got) != len(want) {
+		return false
+	}
+
+	for i := range want {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func mustInsert(t *testing.T, repo order.Repository, o order.Order) {
+	t.Helper()
+
+	if err := repo.Insert(context.Background(), o); err != nil {
+		t.Fatalf("Insert(%d): %v", o.OrderID, err)
+	}
+}
+
+// mustAdvance moves o, as inserted, through statuses with one Update per status.
+func mustAdvance(t *testing.T, repo order.Repository, o order.Order, statuses ...order.Status) {
+	t.Helper()
+
+	for _, status := range statuses {
+		o.Status = status
+		if err := repo.Update(context.Background(), o); err != nil {
+			t.Fatalf("Update(%d) to %s: %v", o.OrderID, status, err)
+		}
+		o.Version++
+	}
+}
+
+// AssertOrderEqual fails the test when got does not hold the same data as want.
+// Line items are compared regardless of their order, and a nil slice equals an empty one.
+func AssertOrderEqual(t *testing.T, want, got order.Order) {
+	t.Helper()
+
+	if got.OrderID != want.OrderID {
+		t.Fatalf("order id = %d, want %d", got.OrderID, want.OrderID)
+	}
+	if got.Version != want.Version {
+		t.Fatalf("order %d: version = %d, want %d", want.OrderID, got.Version, want.Version)
+	}
+	if got.CustomerID != want.CustomerID {
+		t.Fatalf("order %d: customer id = %s, want %s", want.OrderID, got.CustomerID, want.CustomerID)
+	}
+	if got.Status != want.Status {
+		t.Fatalf("order %d: status = %s, want %s", want.OrderID, got.Status, want.Status)
+	}
+
+	if got.Subtotal != want.Subtotal || got.Total != want.Total {
+		t.Fatalf("order %d: subtotal = %+v, total = %+v, want %+v and %+v", want.OrderID, got.Subtotal, got.Total,
+			want.Subtotal, want.Total)
+	}
+
+	assertTimeEqual(t, want.OrderID, "created_at", want.CreatedAt, got.CreatedAt)
+	assertTimeEqual(t, want.OrderID, "shipped_at", want.ShippedAt, got.ShippedAt)
+	assertTimeEqual(t, want.OrderID, "completed_at", want.CompletedAt, got.CompletedAt)
+	assertTimeEqual(t, want.OrderID, "cancelled_at", want.CancelledAt, got.CancelledAt)
+	assertTimeEqual(t, want.OrderID, "deleted_at", want.DeletedAt, got.DeletedAt)
+
+	if (want.CancelReason == nil) != (got.CancelReason == nil) ||
+		want.CancelReason != nil && *got.CancelReason != *want.CancelReason {
+		t.Fatalf("order %d: cancel reason = %+v, want %+v", want.OrderID, got.CancelReason, want.CancelReason)
+	}
+
+	wantItems := sortedLineItems(want.LineItems)
+	gotItems := sortedLineItems(got.LineItems)
+	if len(gotItems) != len(wantItems) {
+		t.Fatalf("order %d: %d line items, want %d", want.OrderID, len(gotItems), len(wantItems))
+	}
+	for i := range wantItems {
+		if gotItems[i] != wantItems[i] {
+			t.Fatalf("order %d: line item = %+v, want %+v", want.OrderID, gotItems[i], wantItems[i])
+		}
+	}
+}
+
+func assertTimeEqual(t *testing.T, id int64, field string, want, got *time.Time) {
+	t.Helper()
+
+	if want == nil || got == nil {
+		if want != got {
+			t.Fatalf("order %d: %s = %v, want %v", id, field, got, want)
+		}
+		return
+	}
+
+	if !got.Equal(*want) {
+		t.Fatalf("order %d: %s = %s, want %s", id, field, got, want)
+	}
+}
+
+func sortedLineItems(items []order.LineItem) []order.LineItem {
+	sorted := make([]order.LineItem, len(items))
+	copy(sorted, items)
+
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].ItemID.String() < sorted[j].ItemID.String()
+	})
+
+	return sorted
+}
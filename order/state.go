@@ -0,0 +1,217 @@
+package order
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Statuses of the default state machine, deployments may add their own through the configuration.
+const (
+	StatusPending   Status = "pending"
+	StatusPaid      Status = "paid"
+	StatusShipped   Status = "shipped"
+	StatusCompleted Status = "completed"
+	StatusCancelled Status = "cancelled"
+	StatusRefunded  Status = "refunded"
+)
+
+// Guard vetoes a transition of order, which already holds the new status, by returning the reason.
+type Guard func(order Order) error
+
+// Guards are the guards transitions name in their table.
+var Guards = map[string]Guard{
+	"has_line_items": func(order Order) error {
+		if len(order.LineItems) == 0 {
+			return errors.New("order has no line items")
+		}
+		return nil
+	},
+	"not_shipped": func(order Order) error {
+		if order.ShippedAt != nil {
+			return errors.New("order has already been shipped")
+		}
+		return nil
+	},
+	"has_customer": func(order Order) error {
+		if order.CustomerID == uuid.Nil {
+			return errors.New("order has no customer")
+		}
+		return nil
+	},
+}
+
+// Transition allows orders to move from a status to another when all its guards pass.
+type Transition struct {
+	From   Status   `json:"from"`
+	To     Status   `json:"to"`
+	Guards []string `json:"guards,omitempty"`
+}
+
+// StateMachine is the table of the status transitions of the orders, created in the Initial status.
+type StateMachine struct {
+	Initial     Status       `json:"initial"`
+	Transitions []Transition `json:"transitions"`
+}
+
+// DefaultStateMachine returns the transitions of pending orders being paid, shipped and completed,
+// cancelled until shipped and refunded once paid.
+func DefaultStateMachine() *StateMachine {
+	return &StateMachine{
+		Initial: StatusPending,
+		Transitions: []Transition{
+			{From: StatusPending, To: StatusPaid, Guards: []string{"has_customer", "has_line_items"}},
+			{From: StatusPaid, To: StatusShipped},
+			{From: StatusShipped, To: StatusCompleted},
+			{From: StatusPending, To: StatusCancelled, Guards: []string{"not_shipped"}},
+			{From: StatusPaid, To: StatusCancelled, Guards: []string{"not_shipped"}},
+			{From: StatusPaid, To: StatusRefunded},
+			{From: StatusCompleted, To: StatusRefunded},
+		},
+	}
+}
+
+var ErrInvalidTransition = errors.New("invalid status transition")
+
+// Validate checks that the table only names known guards and that every status is reachable.
+func (m *StateMachine) Validate() error {
+	if m.Initial == "" {
+		return errors.New("state machine has no initial status")
+	}
+
+	reachable := map[Status]bool{m.Initial: true}
+	for _, transition := range m.Transitions {
+		if transition.From == "" || transition.To == "" {
+			return fmt.Errorf("transition %q -> %q has an empty status", transition.From, transition.To)
+		}
+
+		for _, name := range transition.Guards {
+			if _, exist := Guards[name]; !exist {
+				return fmt.Errorf("transition %s -> %s has an unknown guard %q", transition.From, transition.To, name)
+			}
+		}
+
+		reachable[transition.To] = true
+	}
+
+	for _, transition := range m.Transitions {
+		if !reachable[transition.From] {
+			return fmt.Errorf("status %s is never reached", transition.From)
+		}
+	}
+
+	return nil
+}
+
+// Known tells whether status is a status of the table.
+func (m *StateMachine) Known(status Status) bool {
+	if status == m.Initial {
+		return true
+	}
+
+	for _, transition := range m.Transitions {
+		if transition.From == status || transition.To == status {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Allowed returns the statuses an order may move to from status, guards aside.
+func (m *StateMachine) Allowed(from Status) []Status {
+	allowed := []Status{}
+	for _, transition := range m.Transitions {
+		if transition.From == from {
+			allowed = append(allowed, transition.To)
+		}
+	}
+
+	return allowed
+}
+
+// Check returns ErrInvalidTransition when order may not move from the status from to its current status.
+// An order keeping its status is always valid.
+func (m *StateMachine) Check(from Status, order Order) error {
+	if from == order.Status {
+		return nil
+	}
+
+	for _, transition := range m.Transitions {
+		if transition.From != from || transition.To != order.Status {
+			continue
+		}
+
+		for _, name := range transition.Guards {
+			guard, exist := Guards[name]
+			if !exist {
+				return fmt.Errorf("%w: unknown guard %q", ErrInvalidTransition, name)
+			}
+
+			if err := guard(order); err != nil {
+				return fmt.Errorf("%w from %s to %s: %v", ErrInvalidTransition, from, order.Status, err)
+			}
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("%w from %s to %s", ErrInvalidTransition, from, order.Status)
+}
+
+// Apply moves order to the status to at now, recording the shipment, completion and cancellation times.
+func (m *StateMachine) Apply(order *Order, to Status, now time.Time) error {
+	from := order.Status
+	if from == to {
+		return fmt.Errorf("%w: order is already %s", ErrInvalidTransition, to)
+	}
+
+	moved := *order
+	moved.Status = to
+
+	switch to {
+	case StatusShipped:
+		moved.ShippedAt = &now
+	case StatusCompleted:
+		moved.CompletedAt = &now
+	case StatusCancelled:
+		moved.CancelledAt = &now
+	}
+
+	if err := m.Check(from, moved); err != nil {
+		return err
+	}
+
+	*order = moved
+
+	return nil
+}
+
+// statesOr returns states, or the default state machine when nil.
+func statesOr(states *StateMachine) *StateMachine {
+	if states == nil {
+		return DefaultStateMachine()
+	}
+
+	return states
+}
+
+type transitionsSkippedKey struct{}
+
+// skipTransitions returns a context whose updates may set any status and line items, and whose writes leave
+// the stock alone, for the copies between databases which replicate orders rather than move them along their lifecycle.
+func skipTransitions(ctx context.Context) context.Context {
+	return context.WithValue(ctx, transitionsSkippedKey{}, true)
+}
+
+// checkTransition is the Check of the repositories, which skip it for the contexts of skipTransitions.
+func checkTransition(ctx context.Context, states *StateMachine, from Status, order Order) error {
+	if skipped, _ := ctx.Value(transitionsSkippedKey{}).(bool); skipped {
+		return nil
+	}
+
+	return statesOr(states).Check(from, order)
+}
@@ -0,0 +1,40 @@
+// Package paging holds the keyset pagination shared by the repositories listing records sorted by uuid.
+package paging
+
+import (
+	"errors"
+
+	"github.com/google/uuid"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// ParseCursor returns the id after which a page starts, uuid.Nil for the first page.
+func ParseCursor(cursor string) (uuid.UUID, error) {
+	if cursor == "" {
+		return uuid.Nil, nil
+	}
+
+	id, err := uuid.Parse(cursor)
+	if err != nil {
+		return uuid.Nil, ErrInvalidCursor
+	}
+
+	return id, nil
+}
+
+// Trim cuts records, fetched with one more record than the page size, to the page
+// and returns the cursor of the next page, empty on the last page.
+func Trim[T any](records []T, size uint, id func(T) uuid.UUID) ([]T, string) {
+	// An empty page has no last record to resume after.
+	if size == 0 {
+		return records[:0], ""
+	}
+
+	if uint(len(records)) <= size {
+		return records, ""
+	}
+
+	records = records[:size]
+	return records, id(records[len(records)-1]).String()
+}
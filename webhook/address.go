@@ -0,0 +1,84 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+)
+
+var ErrForbiddenAddress = errors.New("webhook address is not public")
+
+// sharedAddressSpace is the carrier-grade NAT range, which netip does not count as private.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// AddressPolicy keeps the webhooks off the internal network of the server: loopback, link-local,
+// private and other non public addresses are refused unless Allowed contains them.
+type AddressPolicy struct {
+	Allowed []netip.Prefix
+}
+
+func (p AddressPolicy) check(addr netip.Addr) error {
+	addr = addr.Unmap()
+
+	for _, prefix := range p.Allowed {
+		if prefix.Contains(addr) {
+			return nil
+		}
+	}
+
+	if addr.IsLoopback() || addr.IsPrivate() || addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() ||
+		addr.IsInterfaceLocalMulticast() || addr.IsMulticast() || addr.IsUnspecified() ||
+		sharedAddressSpace.Contains(addr) {
+		return fmt.Errorf("%s: %w", addr, ErrForbiddenAddress)
+	}
+
+	return nil
+}
+
+// checkHost checks every address host resolves to.
+func (p AddressPolicy) checkHost(ctx context.Context, host string) error {
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return p.check(addr)
+	}
+
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return fmt.Errorf("failed to resolve %s: %w", host, err)
+	}
+
+	for _, addr := range addrs {
+		if err := p.check(addr); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// NewClient returns the client of the deliveries. Its connections are checked against policy once
+// the host is resolved, so that neither a DNS change nor a redirect reaches a refused address.
+func NewClient(timeout time.Duration, policy AddressPolicy) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(_, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+
+			return policy.check(addrPort.Addr())
+		},
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	// A proxy would be dialed instead of the subscription host.
+	transport.Proxy = nil
+
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
@@ -0,0 +1,266 @@
+package webhook
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"first-little-server/order"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+type Handler struct {
+	Repo Repository
+	// Addresses are the hosts the subscription URLs may designate.
+	Addresses AddressPolicy
+}
+
+// eventTypes are the order events a subscription may receive.
+var eventTypes = map[order.DomainEventType]bool{
+	order.OrderCreated:          true,
+	order.OrderPaid:             true,
+	order.OrderShipped:          true,
+	order.OrderCompleted:        true,
+	order.OrderCancelled:        true,
+	order.OrderRefunded:         true,
+	order.OrderDeleted:          true,
+	order.OrderRestored:         true,
+	order.OrderLineItemsChanged: true,
+}
+
+func (h *Handler) Create(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		URL        string                  `json:"url"`
+		EventTypes []order.DomainEventType `json:"event_types"`
+		Secret     string                  `json:"secret"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if err := h.validateURL(r.Context(), body.URL); err != nil {
+		fmt.Println("invalid webhook url:", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if len(body.EventTypes) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	for _, eventType := range body.EventTypes {
+		if !eventTypes[eventType] {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	// The secret is generated unless the partner brings its own.
+	if body.Secret == "" {
+		secret, err := newSecret()
+		if err != nil {
+			fmt.Println("failed to generate secret:", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		body.Secret = secret
+	}
+
+	subscription := Subscription{
+		ID:         uuid.New(),
+		URL:        body.URL,
+		EventTypes: body.EventTypes,
+		Secret:     body.Secret,
+		CreatedAt:  time.Now().UTC(),
+	}
+
+	if err := h.Repo.InsertSubscription(r.Context(), subscription); err != nil {
+		fmt.Println("failed to insert subscription:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	res, err := json.Marshal(subscription)
+	if err != nil {
+		fmt.Println("failed to marshal:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	_, _ = w.Write(res)
+}
+
+func (h *Handler) validateURL(ctx context.Context, raw string) error {
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return fmt.Errorf("unsupported scheme %q", parsed.Scheme)
+	}
+
+	if parsed.Hostname() == "" {
+		return errors.New("missing host")
+	}
+
+	return h.Addresses.checkHost(ctx, parsed.Hostname())
+}
+
+func newSecret() (string, error) {
+	const secretSize = 32
+
+	secret := make([]byte, secretSize)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(secret), nil
+}
+
+func (h *Handler) List(w http.ResponseWriter, r *http.Request) {
+	subscriptions, err := h.Repo.ListSubscriptions(r.Context())
+	if err != nil {
+		fmt.Println("failed to list subscriptions:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// Secrets are only returned on creation.
+	for i := range subscriptions {
+		subscriptions[i].Secret = ""
+	}
+
+	var response struct {
+		Items []Subscription `json:"items"`
+	}
+	response.Items = subscriptions
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		fmt.Println("failed to marshal:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *Handler) GetByID(w http.ResponseWriter, r *http.Request) {
+	subscriptionID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	subscription, err := h.Repo.FindSubscription(r.Context(), subscriptionID)
+	if errors.Is(err, ErrNotExist) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		fmt.Println("failed to find subscription:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	subscription.Secret = ""
+	if err := json.NewEncoder(w).Encode(subscription); err != nil {
+		fmt.Println("failed to marshal:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *Handler) DeleteByID(w http.ResponseWriter, r *http.Request) {
+	subscriptionID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	err = h.Repo.DeleteSubscription(r.Context(), subscriptionID)
+	if errors.Is(err, ErrNotExist) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		fmt.Println("failed to delete subscription:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Deliveries lists the deliveries of a subscription with the status given by the query, pending by default.
+// The dead deliveries form the dead-letter queue.
+func (h *Handler) Deliveries(w http.ResponseWriter, r *http.Request) {
+	subscriptionID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	status := DeliveryStatus(r.URL.Query().Get("status"))
+	switch status {
+	case "":
+		status = DeliveryPending
+	case DeliveryPending, DeliveryDelivered, DeliveryDead:
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Deliveries outlive their subscription, they are listed even when it was deleted.
+	deliveries, err := h.Repo.ListDeliveries(r.Context(), subscriptionID, status)
+	if err != nil {
+		fmt.Println("failed to list deliveries:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	var response struct {
+		Items []Delivery `json:"items"`
+	}
+	response.Items = deliveries
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		fmt.Println("failed to marshal:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *Handler) Attempts(w http.ResponseWriter, r *http.Request) {
+	deliveryID, err := uuid.Parse(chi.URLParam(r, "deliveryID"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	attempts, err := h.Repo.Attempts(r.Context(), deliveryID)
+	if err != nil {
+		fmt.Println("failed to list attempts:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	var response struct {
+		Items []Attempt `json:"items"`
+	}
+	response.Items = attempts
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		fmt.Println("failed to marshal:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,45 @@
+package webhook_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"first-little-server/webhook"
+)
+
+func TestCreateRefusesInternalURLs(t *testing.T) {
+	tests := []struct {
+		url     string
+		allowed []netip.Prefix
+		want    int
+	}{
+		{url: "http://127.0.0.1:8080/hook", want: http.StatusBadRequest},
+		{url: "http://[::1]/hook", want: http.StatusBadRequest},
+		{url: "http://169.254.169.254/latest/meta-data", want: http.StatusBadRequest},
+		{url: "http://10.0.0.5/hook", want: http.StatusBadRequest},
+		{url: "http://192.168.1.10/hook", want: http.StatusBadRequest},
+		{url: "http://[::ffff:127.0.0.1]/hook", want: http.StatusBadRequest},
+		{url: "http://0.0.0.0/hook", want: http.StatusBadRequest},
+		{url: "ftp://93.184.215.14/hook", want: http.StatusBadRequest},
+		{url: "https://93.184.215.14/hook", want: http.StatusCreated},
+		{url: "http://10.0.0.5/hook", allowed: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}, want: http.StatusCreated},
+	}
+
+	for _, test := range tests {
+		handler := &webhook.Handler{
+			Repo:      &webhook.MemoryRepo{},
+			Addresses: webhook.AddressPolicy{Allowed: test.allowed},
+		}
+
+		body := `{"url":"` + test.url + `","event_types":["order.created"]}`
+		w := httptest.NewRecorder()
+		handler.Create(w, httptest.NewRequest(http.MethodPost, "/webhooks", strings.NewReader(body)))
+
+		if w.Code != test.want {
+			t.Errorf("Create(%s) with %v allowed = %d, want %d", test.url, test.allowed, w.Code, test.want)
+		}
+	}
+}
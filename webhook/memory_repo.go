@@ -0,0 +1,173 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MemoryRepo keeps subscriptions and deliveries in process memory, along with the memory order database.
+// The zero value is ready to use.
+type MemoryRepo struct {
+	mu            sync.Mutex
+	subscriptions map[uuid.UUID]Subscription
+	deliveries    map[uuid.UUID]Delivery
+	attempts      map[uuid.UUID][]Attempt
+}
+
+func (repo *MemoryRepo) InsertSubscription(_ context.Context, subscription Subscription) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if repo.subscriptions == nil {
+		repo.subscriptions = make(map[uuid.UUID]Subscription)
+	}
+
+	if _, exist := repo.subscriptions[subscription.ID]; exist {
+		return fmt.Errorf("webhook subscription %s already exists", subscription.ID)
+	}
+
+	repo.subscriptions[subscription.ID] = copySubscription(subscription)
+
+	return nil
+}
+
+func copySubscription(subscription Subscription) Subscription {
+	copied := subscription
+	copied.EventTypes = append(copied.EventTypes[:0:0], subscription.EventTypes...)
+
+	return copied
+}
+
+func (repo *MemoryRepo) FindSubscription(_ context.Context, id uuid.UUID) (Subscription, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	subscription, exist := repo.subscriptions[id]
+	if !exist {
+		return Subscription{}, ErrNotExist
+	}
+
+	return copySubscription(subscription), nil
+}
+
+func (repo *MemoryRepo) ListSubscriptions(_ context.Context) ([]Subscription, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	subscriptions := make([]Subscription, 0, len(repo.subscriptions))
+	for _, subscription := range repo.subscriptions {
+		subscriptions = append(subscriptions, copySubscription(subscription))
+	}
+
+	sort.Slice(subscriptions, func(i, j int) bool {
+		return subscriptions[i].CreatedAt.Before(subscriptions[j].CreatedAt)
+	})
+
+	return subscriptions, nil
+}
+
+func (repo *MemoryRepo) DeleteSubscription(_ context.Context, id uuid.UUID) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if _, exist := repo.subscriptions[id]; !exist {
+		return ErrNotExist
+	}
+
+	delete(repo.subscriptions, id)
+
+	return nil
+}
+
+func (repo *MemoryRepo) InsertDelivery(_ context.Context, delivery Delivery) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if repo.deliveries == nil {
+		repo.deliveries = make(map[uuid.UUID]Delivery)
+	}
+
+	if _, exist := repo.deliveries[delivery.ID]; !exist {
+		repo.deliveries[delivery.ID] = delivery
+	}
+
+	return nil
+}
+
+func (repo *MemoryRepo) ClaimDue(_ context.Context, now time.Time, lease time.Duration, limit int) ([]Delivery, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	var due []Delivery
+	for _, delivery := range repo.deliveries {
+		if delivery.Status == DeliveryPending && !delivery.NextAttemptAt.After(now) {
+			due = append(due, delivery)
+		}
+	}
+
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].NextAttemptAt.Before(due[j].NextAttemptAt)
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	for _, delivery := range due {
+		claimed := repo.deliveries[delivery.ID]
+		claimed.NextAttemptAt = now.Add(lease)
+		repo.deliveries[delivery.ID] = claimed
+	}
+
+	return due, nil
+}
+
+func (repo *MemoryRepo) RecordAttempt(_ context.Context, delivery Delivery, attempt Attempt) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if _, exist := repo.deliveries[delivery.ID]; !exist {
+		return fmt.Errorf("webhook delivery %s does not exist", delivery.ID)
+	}
+
+	if repo.attempts == nil {
+		repo.attempts = make(map[uuid.UUID][]Attempt)
+	}
+
+	repo.deliveries[delivery.ID] = delivery
+	repo.attempts[delivery.ID] = append(repo.attempts[delivery.ID], attempt)
+
+	return nil
+}
+
+func (repo *MemoryRepo) ListDeliveries(_ context.Context, subscriptionID uuid.UUID, status DeliveryStatus) ([]Delivery, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	deliveries := []Delivery{}
+	for _, delivery := range repo.deliveries {
+		if delivery.SubscriptionID == subscriptionID && delivery.Status == status {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+
+	sort.Slice(deliveries, func(i, j int) bool {
+		return deliveries[i].CreatedAt.Before(deliveries[j].CreatedAt)
+	})
+
+	return deliveries, nil
+}
+
+func (repo *MemoryRepo) Attempts(_ context.Context, deliveryID uuid.UUID) ([]Attempt, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	attempts := make([]Attempt, len(repo.attempts[deliveryID]))
+	copy(attempts, repo.attempts[deliveryID])
+
+	return attempts, nil
+}
@@ -0,0 +1,87 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"first-little-server/order"
+
+	"github.com/google/uuid"
+)
+
+// Subscription registers a partner URL called back with the order events of the given types.
+type Subscription struct {
+	ID         uuid.UUID               `json:"id"`
+	URL        string                  `json:"url"`
+	EventTypes []order.DomainEventType `json:"event_types"`
+	// Secret signs the deliveries, it is only returned when the subscription is created.
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (s Subscription) wants(eventType order.DomainEventType) bool {
+	for _, wanted := range s.EventTypes {
+		if wanted == eventType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// DeliveryStatus is the state of a delivery. Dead deliveries exhausted their attempts,
+// they form the dead-letter queue of a subscription.
+type DeliveryStatus string
+
+const (
+	DeliveryPending   DeliveryStatus = "pending"
+	DeliveryDelivered DeliveryStatus = "delivered"
+	DeliveryDead      DeliveryStatus = "dead"
+)
+
+// Delivery is an event to send to a subscription.
+type Delivery struct {
+	ID             uuid.UUID         `json:"id"`
+	SubscriptionID uuid.UUID         `json:"subscription_id"`
+	Event          order.DomainEvent `json:"event"`
+	Status         DeliveryStatus    `json:"status"`
+	Attempts       int               `json:"attempts"`
+	NextAttemptAt  time.Time         `json:"next_attempt_at"`
+	LastError      string            `json:"last_error,omitempty"`
+	CreatedAt      time.Time         `json:"created_at"`
+}
+
+// Attempt records a try of a delivery. StatusCode is zero when no response was received.
+type Attempt struct {
+	DeliveryID  uuid.UUID     `json:"delivery_id"`
+	AttemptedAt time.Time     `json:"attempted_at"`
+	StatusCode  int           `json:"status_code,omitempty"`
+	Error       string        `json:"error,omitempty"`
+	Duration    time.Duration `json:"duration_ns"`
+}
+
+var ErrNotExist = errors.New("webhook subscription does not exist")
+
+type Repository interface {
+	InsertSubscription(ctx context.Context, subscription Subscription) error
+	// FindSubscription returns ErrNotExist when no subscription has the id.
+	FindSubscription(ctx context.Context, id uuid.UUID) (Subscription, error)
+	ListSubscriptions(ctx context.Context) ([]Subscription, error)
+	// DeleteSubscription returns ErrNotExist when no subscription has the id.
+	// The deliveries of the subscription are kept for inspection.
+	DeleteSubscription(ctx context.Context, id uuid.UUID) error
+
+	// InsertDelivery queues a delivery, it does nothing when a delivery with the same id exists,
+	// so that an event published again is delivered once.
+	InsertDelivery(ctx context.Context, delivery Delivery) error
+	// ClaimDue returns at most limit pending deliveries due at now and postpones them by lease,
+	// so that no other worker claims them while they are attempted.
+	ClaimDue(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]Delivery, error)
+	// RecordAttempt stores an attempt of a claimed delivery along with the new state of the delivery.
+	RecordAttempt(ctx context.Context, delivery Delivery, attempt Attempt) error
+	// ListDeliveries returns the deliveries of a subscription with the given status, oldest first.
+	ListDeliveries(ctx context.Context, subscriptionID uuid.UUID, status DeliveryStatus) ([]Delivery, error)
+	// Attempts returns the attempts of a delivery, oldest first.
+	Attempts(ctx context.Context, deliveryID uuid.UUID) ([]Attempt, error)
+}
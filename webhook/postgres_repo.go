@@ -0,0 +1,340 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"first-little-server/order"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// PostgresRepo keeps subscriptions and deliveries in the postgres order database.
+type PostgresRepo struct {
+	Client *pgxpool.Pool
+}
+
+const (
+	subscriptionTable = "webhook_subscription"
+
+	subscriptionIdRow = "subscription_id"
+	urlRow            = "url"
+	eventTypesRow     = "event_types"
+	secretRow         = "secret"
+	createdAtRow      = "created_at"
+
+	deliveryTable = "webhook_delivery"
+
+	deliveryIdRow    = "delivery_id"
+	eventRow         = "event"
+	statusRow        = "status"
+	attemptsRow      = "attempts"
+	nextAttemptAtRow = "next_attempt_at"
+	lastErrorRow     = "last_error"
+
+	attemptTable = "webhook_attempt"
+
+	attemptedAtRow = "attempted_at"
+	statusCodeRow  = "status_code"
+	errorRow       = "error"
+	durationRow    = "duration_ns"
+)
+
+const insertSubscriptionSQL = "INSERT INTO " + subscriptionTable +
+	" (" + subscriptionIdRow + ", " + urlRow + ", " + eventTypesRow + ", " + secretRow + ", " + createdAtRow + ")" +
+	" VALUES (@id, @url, @eventTypes, @secret, @createdAt)"
+
+func (p *PostgresRepo) InsertSubscription(ctx context.Context, subscription Subscription) error {
+	args := pgx.NamedArgs{
+		"id":         subscription.ID,
+		"url":        subscription.URL,
+		"eventTypes": eventTypeStrings(subscription.EventTypes),
+		"secret":     subscription.Secret,
+		"createdAt":  subscription.CreatedAt,
+	}
+
+	if _, err := p.Client.Exec(ctx, insertSubscriptionSQL, args); err != nil {
+		return fmt.Errorf("failed to insert subscription: %w", err)
+	}
+
+	return nil
+}
+
+func eventTypeStrings(eventTypes []order.DomainEventType) []string {
+	values := make([]string, len(eventTypes))
+	for i, eventType := range eventTypes {
+		values[i] = string(eventType)
+	}
+
+	return values
+}
+
+const selectSubscriptionColumns = subscriptionIdRow + ", " + urlRow + ", " + eventTypesRow + ", " + secretRow + ", " +
+	createdAtRow
+
+const selectSubscriptionSQL = "SELECT " + selectSubscriptionColumns + " FROM " + subscriptionTable +
+	" WHERE " + subscriptionIdRow + " = @id"
+
+const selectSubscriptionsSQL = "SELECT " + selectSubscriptionColumns + " FROM " + subscriptionTable +
+	" ORDER BY " + createdAtRow
+
+func scanSubscription(row pgx.Row) (Subscription, error) {
+	var (
+		subscription Subscription
+		eventTypes   []string
+	)
+
+	err := row.Scan(&subscription.ID, &subscription.URL, &eventTypes, &subscription.Secret, &subscription.CreatedAt)
+	if err != nil {
+		return Subscription{}, fmt.Errorf("error scanning webhook_subscription row: %w", err)
+	}
+
+	subscription.CreatedAt = subscription.CreatedAt.UTC()
+	for _, eventType := range eventTypes {
+		subscription.EventTypes = append(subscription.EventTypes, order.DomainEventType(eventType))
+	}
+
+	return subscription, nil
+}
+
+func (p *PostgresRepo) FindSubscription(ctx context.Context, id uuid.UUID) (Subscription, error) {
+	subscription, err := scanSubscription(p.Client.QueryRow(ctx, selectSubscriptionSQL, pgx.NamedArgs{"id": id}))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Subscription{}, ErrNotExist
+	} else if err != nil {
+		return Subscription{}, err
+	}
+
+	return subscription, nil
+}
+
+func (p *PostgresRepo) ListSubscriptions(ctx context.Context) ([]Subscription, error) {
+	rows, err := p.Client.Query(ctx, selectSubscriptionsSQL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query subscriptions: %w", err)
+	}
+	defer rows.Close()
+
+	subscriptions := []Subscription{}
+	for rows.Next() {
+		subscription, err := scanSubscription(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		subscriptions = append(subscriptions, subscription)
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error closing rows: %w", err)
+	}
+
+	return subscriptions, nil
+}
+
+const deleteSubscriptionSQL = "DELETE FROM " + subscriptionTable + " WHERE " + subscriptionIdRow + " = @id"
+
+func (p *PostgresRepo) DeleteSubscription(ctx context.Context, id uuid.UUID) error {
+	tag, err := p.Client.Exec(ctx, deleteSubscriptionSQL, pgx.NamedArgs{"id": id})
+	if err != nil {
+		return fmt.Errorf("failed to delete subscription: %w", err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return ErrNotExist
+	}
+
+	return nil
+}
+
+const insertDeliverySQL = "INSERT INTO " + deliveryTable +
+	" (" + deliveryIdRow + ", " + subscriptionIdRow + ", " + eventRow + ", " + statusRow + ", " + attemptsRow + ", " +
+	nextAttemptAtRow + ", " + lastErrorRow + ", " + createdAtRow + ")" +
+	" VALUES (@id, @subscriptionId, @event, @status, @attempts, @nextAttemptAt, @lastError, @createdAt)" +
+	" ON CONFLICT (" + deliveryIdRow + ") DO NOTHING"
+
+func (p *PostgresRepo) InsertDelivery(ctx context.Context, delivery Delivery) error {
+	event, err := json.Marshal(delivery.Event)
+	if err != nil {
+		return fmt.Errorf("failed to encode delivery event: %w", err)
+	}
+
+	args := pgx.NamedArgs{
+		"id":             delivery.ID,
+		"subscriptionId": delivery.SubscriptionID,
+		"event":          string(event),
+		"status":         delivery.Status,
+		"attempts":       delivery.Attempts,
+		"nextAttemptAt":  delivery.NextAttemptAt,
+		"lastError":      delivery.LastError,
+		"createdAt":      delivery.CreatedAt,
+	}
+
+	if _, err := p.Client.Exec(ctx, insertDeliverySQL, args); err != nil {
+		return fmt.Errorf("failed to insert delivery: %w", err)
+	}
+
+	return nil
+}
+
+const deliveryColumns = deliveryIdRow + ", " + subscriptionIdRow + ", " + eventRow + ", " + statusRow + ", " +
+	attemptsRow + ", " + nextAttemptAtRow + ", " + lastErrorRow + ", " + createdAtRow
+
+// claimDueSQL postpones the due deliveries by the lease, the skipped locks let concurrent workers
+// claim other deliveries. The returned next attempt is the claimed one.
+const claimDueSQL = "UPDATE " + deliveryTable + " SET " + nextAttemptAtRow + " = @leaseEnd" +
+	" WHERE " + deliveryIdRow + " IN (SELECT " + deliveryIdRow + " FROM " + deliveryTable +
+	" WHERE " + statusRow + " = 'pending' AND " + nextAttemptAtRow + " <= @now" +
+	" ORDER BY " + nextAttemptAtRow + " LIMIT @limit FOR UPDATE SKIP LOCKED)" +
+	" RETURNING " + deliveryColumns
+
+func (p *PostgresRepo) ClaimDue(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]Delivery, error) {
+	args := pgx.NamedArgs{
+		"now":      now,
+		"leaseEnd": now.Add(lease),
+		"limit":    limit,
+	}
+
+	return p.queryDeliveries(ctx, claimDueSQL, args)
+}
+
+func (p *PostgresRepo) queryDeliveries(ctx context.Context, query string, args pgx.NamedArgs) ([]Delivery, error) {
+	rows, err := p.Client.Query(ctx, query, args)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	deliveries := []Delivery{}
+	for rows.Next() {
+		var (
+			delivery Delivery
+			event    []byte
+		)
+
+		err := rows.Scan(&delivery.ID, &delivery.SubscriptionID, &event, &delivery.Status, &delivery.Attempts,
+			&delivery.NextAttemptAt, &delivery.LastError, &delivery.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning webhook_delivery row: %w", err)
+		}
+
+		if err := json.Unmarshal(event, &delivery.Event); err != nil {
+			return nil, fmt.Errorf("failed to decode delivery event: %w", err)
+		}
+		delivery.NextAttemptAt = delivery.NextAttemptAt.UTC()
+		delivery.CreatedAt = delivery.CreatedAt.UTC()
+
+		deliveries = append(deliveries, delivery)
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error closing rows: %w", err)
+	}
+
+	return deliveries, nil
+}
+
+const updateDeliverySQL = "UPDATE " + deliveryTable + " SET " + statusRow + " = @status, " + attemptsRow + " = @attempts, " +
+	nextAttemptAtRow + " = @nextAttemptAt, " + lastErrorRow + " = @lastError WHERE " + deliveryIdRow + " = @id"
+
+const insertAttemptSQL = "INSERT INTO " + attemptTable +
+	" (" + deliveryIdRow + ", " + attemptedAtRow + ", " + statusCodeRow + ", " + errorRow + ", " + durationRow + ")" +
+	" VALUES (@id, @attemptedAt, @statusCode, @error, @duration)"
+
+func (p *PostgresRepo) RecordAttempt(ctx context.Context, delivery Delivery, attempt Attempt) error {
+	tx, err := p.Client.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction for attempt: %w", err)
+	}
+
+	// Rollback is a no-op once the transaction has been committed.
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	args := pgx.NamedArgs{
+		"id":            delivery.ID,
+		"status":        delivery.Status,
+		"attempts":      delivery.Attempts,
+		"nextAttemptAt": delivery.NextAttemptAt,
+		"lastError":     delivery.LastError,
+	}
+	tag, err := tx.Exec(ctx, updateDeliverySQL, args)
+	if err != nil {
+		return fmt.Errorf("failed to update delivery: %w", err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("webhook delivery %s does not exist", delivery.ID)
+	}
+
+	args = pgx.NamedArgs{
+		"id":          delivery.ID,
+		"attemptedAt": attempt.AttemptedAt,
+		"statusCode":  attempt.StatusCode,
+		"error":       attempt.Error,
+		"duration":    int64(attempt.Duration),
+	}
+	if _, err := tx.Exec(ctx, insertAttemptSQL, args); err != nil {
+		return fmt.Errorf("failed to insert attempt: %w", err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to commit attempt transaction %w", err)
+	}
+
+	return nil
+}
+
+const selectDeliveriesSQL = "SELECT " + deliveryColumns + " FROM " + deliveryTable +
+	" WHERE " + subscriptionIdRow + " = @subscriptionId AND " + statusRow + " = @status ORDER BY " + createdAtRow
+
+func (p *PostgresRepo) ListDeliveries(ctx context.Context, subscriptionID uuid.UUID, status DeliveryStatus) ([]Delivery, error) {
+	args := pgx.NamedArgs{
+		"subscriptionId": subscriptionID,
+		"status":         status,
+	}
+
+	return p.queryDeliveries(ctx, selectDeliveriesSQL, args)
+}
+
+const selectAttemptsSQL = "SELECT " + attemptedAtRow + ", " + statusCodeRow + ", " + errorRow + ", " + durationRow +
+	" FROM " + attemptTable + " WHERE " + deliveryIdRow + " = @id ORDER BY " + attemptedAtRow
+
+func (p *PostgresRepo) Attempts(ctx context.Context, deliveryID uuid.UUID) ([]Attempt, error) {
+	rows, err := p.Client.Query(ctx, selectAttemptsSQL, pgx.NamedArgs{"id": deliveryID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query attempts: %w", err)
+	}
+	defer rows.Close()
+
+	attempts := []Attempt{}
+	for rows.Next() {
+		attempt := Attempt{DeliveryID: deliveryID}
+		var duration int64
+
+		err := rows.Scan(&attempt.AttemptedAt, &attempt.StatusCode, &attempt.Error, &duration)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning webhook_attempt row: %w", err)
+		}
+		attempt.AttemptedAt = attempt.AttemptedAt.UTC()
+		attempt.Duration = time.Duration(duration)
+
+		attempts = append(attempts, attempt)
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error closing rows: %w", err)
+	}
+
+	return attempts, nil
+}
@@ -0,0 +1,320 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisRepo keeps subscriptions and deliveries in the redis order database.
+type RedisRepo struct {
+	Client *redis.Client
+}
+
+const subscriptionsKey = "webhook:subscriptions"
+
+const (
+	subscriptionKeyPrefix = "webhook:subscription:"
+	deliveryKeyPrefix     = "webhook:delivery:"
+)
+
+func subscriptionKey(id uuid.UUID) string {
+	return subscriptionKeyPrefix + id.String()
+}
+
+func deliveryKey(id uuid.UUID) string {
+	return deliveryKeyPrefix + id.String()
+}
+
+// dueKey is a sorted set of the pending deliveries scored by their next attempt in milliseconds.
+const dueKey = "webhook:deliveries:due"
+
+// subscriptionDeliveriesKey is a sorted set of the deliveries of a subscription scored by creation time.
+func subscriptionDeliveriesKey(id uuid.UUID) string {
+	return fmt.Sprintf("webhook:deliveries:%s", id)
+}
+
+func attemptsKey(id uuid.UUID) string {
+	return fmt.Sprintf("webhook:attempts:%s", id)
+}
+
+func (repo *RedisRepo) InsertSubscription(ctx context.Context, subscription Subscription) error {
+	data, err := json.Marshal(subscription)
+	if err != nil {
+		return fmt.Errorf("failed to encode subscription: %w", err)
+	}
+
+	set, err := repo.Client.SetNX(ctx, subscriptionKey(subscription.ID), string(data), 0).Result()
+	if err != nil {
+		return fmt.Errorf("failed to insert subscription: %w", err)
+	}
+
+	if !set {
+		return fmt.Errorf("webhook subscription %s already exists", subscription.ID)
+	}
+
+	if err := repo.Client.SAdd(ctx, subscriptionsKey, subscription.ID.String()).Err(); err != nil {
+		return fmt.Errorf("failed to index subscription: %w", err)
+	}
+
+	return nil
+}
+
+func (repo *RedisRepo) FindSubscription(ctx context.Context, id uuid.UUID) (Subscription, error) {
+	value, err := repo.Client.Get(ctx, subscriptionKey(id)).Result()
+	if errors.Is(err, redis.Nil) {
+		return Subscription{}, ErrNotExist
+	} else if err != nil {
+		return Subscription{}, fmt.Errorf("failed to find subscription: %w", err)
+	}
+
+	var subscription Subscription
+	if err := json.Unmarshal([]byte(value), &subscription); err != nil {
+		return Subscription{}, fmt.Errorf("failed to decode subscription json: %w", err)
+	}
+
+	return subscription, nil
+}
+
+func (repo *RedisRepo) ListSubscriptions(ctx context.Context) ([]Subscription, error) {
+	ids, err := repo.Client.SMembers(ctx, subscriptionsKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list subscriptions: %w", err)
+	}
+
+	subscriptions := []Subscription{}
+	if len(ids) == 0 {
+		return subscriptions, nil
+	}
+
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = subscriptionKeyPrefix + id
+	}
+
+	values, err := repo.Client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get subscriptions: %w", err)
+	}
+
+	for _, value := range values {
+		// The subscription may have been deleted between the list and the get.
+		value, ok := value.(string)
+		if !ok {
+			continue
+		}
+
+		var subscription Subscription
+		if err := json.Unmarshal([]byte(value), &subscription); err != nil {
+			return nil, fmt.Errorf("failed to decode subscription json: %w", err)
+		}
+
+		subscriptions = append(subscriptions, subscription)
+	}
+
+	sort.Slice(subscriptions, func(i, j int) bool {
+		return subscriptions[i].CreatedAt.Before(subscriptions[j].CreatedAt)
+	})
+
+	return subscriptions, nil
+}
+
+func (repo *RedisRepo) DeleteSubscription(ctx context.Context, id uuid.UUID) error {
+	var deleted *redis.IntCmd
+	_, err := repo.Client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		deleted = pipe.Del(ctx, subscriptionKey(id))
+		pipe.SRem(ctx, subscriptionsKey, id.String())
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete subscription: %w", err)
+	}
+
+	if deleted.Val() == 0 {
+		return ErrNotExist
+	}
+
+	return nil
+}
+
+func dueScore(t time.Time) float64 {
+	return float64(t.UnixMilli())
+}
+
+// maxWatchRetries bounds the optimistic transaction retries when the watched delivery keeps changing.
+const maxWatchRetries = 5
+
+func (repo *RedisRepo) InsertDelivery(ctx context.Context, delivery Delivery) error {
+	data, err := json.Marshal(delivery)
+	if err != nil {
+		return fmt.Errorf("failed to encode delivery: %w", err)
+	}
+
+	key := deliveryKey(delivery.ID)
+
+	// The existence check and the writes run under watch, so that a delivery is queued once.
+	insert := func(tx *redis.Tx) error {
+		exist, err := tx.Exists(ctx, key).Result()
+		if err != nil {
+			return fmt.Errorf("failed to check delivery existence: %w", err)
+		}
+
+		if exist > 0 {
+			return nil
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, key, string(data), 0)
+			pipe.ZAdd(ctx, dueKey, redis.Z{Score: dueScore(delivery.NextAttemptAt), Member: delivery.ID.String()})
+			pipe.ZAdd(ctx, subscriptionDeliveriesKey(delivery.SubscriptionID),
+				redis.Z{Score: float64(delivery.CreatedAt.UnixMicro()), Member: delivery.ID.String()})
+			return nil
+		})
+		return err
+	}
+
+	for i := 0; i < maxWatchRetries; i++ {
+		err := repo.Client.Watch(ctx, insert, key)
+		if !errors.Is(err, redis.TxFailedErr) {
+			return err
+		}
+	}
+
+	return fmt.Errorf("delivery %s kept changing during the transaction", delivery.ID)
+}
+
+// claimScript postpones the due deliveries by the lease and returns them, atomically so that
+// concurrent workers never claim the same delivery.
+var claimScript = redis.NewScript(`
+local due = redis.call('ZRANGE', KEYS[1], '-inf', ARGV[1], 'BYSCORE', 'LIMIT', 0, ARGV[3])
+for _, id in ipairs(due) do
+	redis.call('ZADD', KEYS[1], ARGV[2], id)
+end
+return due
+`)
+
+func (repo *RedisRepo) ClaimDue(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]Delivery, error) {
+	ids, err := claimScript.Run(ctx, repo.Client, []string{dueKey},
+		strconv.FormatInt(now.UnixMilli(), 10), strconv.FormatInt(now.Add(lease).UnixMilli(), 10), limit).StringSlice()
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim deliveries: %w", err)
+	}
+
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = deliveryKeyPrefix + id
+	}
+
+	return repo.getDeliveries(ctx, keys)
+}
+
+func (repo *RedisRepo) getDeliveries(ctx context.Context, keys []string) ([]Delivery, error) {
+	deliveries := []Delivery{}
+	if len(keys) == 0 {
+		return deliveries, nil
+	}
+
+	values, err := repo.Client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get deliveries: %w", err)
+	}
+
+	for _, value := range values {
+		value, ok := value.(string)
+		if !ok {
+			continue
+		}
+
+		var delivery Delivery
+		if err := json.Unmarshal([]byte(value), &delivery); err != nil {
+			return nil, fmt.Errorf("failed to decode delivery json: %w", err)
+		}
+
+		deliveries = append(deliveries, delivery)
+	}
+
+	return deliveries, nil
+}
+
+func (repo *RedisRepo) RecordAttempt(ctx context.Context, delivery Delivery, attempt Attempt) error {
+	data, err := json.Marshal(delivery)
+	if err != nil {
+		return fmt.Errorf("failed to encode delivery: %w", err)
+	}
+
+	attemptData, err := json.Marshal(attempt)
+	if err != nil {
+		return fmt.Errorf("failed to encode attempt: %w", err)
+	}
+
+	_, err = repo.Client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, deliveryKey(delivery.ID), string(data), 0)
+		pipe.RPush(ctx, attemptsKey(delivery.ID), string(attemptData))
+
+		// Delivered and dead deliveries leave the due set for good.
+		if delivery.Status == DeliveryPending {
+			pipe.ZAdd(ctx, dueKey, redis.Z{Score: dueScore(delivery.NextAttemptAt), Member: delivery.ID.String()})
+		} else {
+			pipe.ZRem(ctx, dueKey, delivery.ID.String())
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record attempt: %w", err)
+	}
+
+	return nil
+}
+
+func (repo *RedisRepo) ListDeliveries(ctx context.Context, subscriptionID uuid.UUID, status DeliveryStatus) ([]Delivery, error) {
+	ids, err := repo.Client.ZRange(ctx, subscriptionDeliveriesKey(subscriptionID), 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list deliveries: %w", err)
+	}
+
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = deliveryKeyPrefix + id
+	}
+
+	deliveries, err := repo.getDeliveries(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	filtered := []Delivery{}
+	for _, delivery := range deliveries {
+		if delivery.Status == status {
+			filtered = append(filtered, delivery)
+		}
+	}
+
+	return filtered, nil
+}
+
+func (repo *RedisRepo) Attempts(ctx context.Context, deliveryID uuid.UUID) ([]Attempt, error) {
+	values, err := repo.Client.LRange(ctx, attemptsKey(deliveryID), 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read attempts: %w", err)
+	}
+
+	attempts := make([]Attempt, 0, len(values))
+	for _, value := range values {
+		var attempt Attempt
+		if err := json.Unmarshal([]byte(value), &attempt); err != nil {
+			return nil, fmt.Errorf("failed to decode attempt json: %w", err)
+		}
+
+		attempts = append(attempts, attempt)
+	}
+
+	return attempts, nil
+}
@@ -0,0 +1,229 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"first-little-server/order"
+
+	"github.com/google/uuid"
+)
+
+// Headers of the deliveries. The signature is the hex HMAC-SHA256 of "<timestamp>.<body>"
+// keyed by the subscription secret, prefixed with "sha256=".
+const (
+	DeliveryHeader  = "X-Webhook-Delivery"
+	EventHeader     = "X-Webhook-Event"
+	TimestampHeader = "X-Webhook-Timestamp"
+	SignatureHeader = "X-Webhook-Signature"
+)
+
+// Sign returns the signature header value of a delivery body sent at timestamp, in unix seconds.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = fmt.Fprintf(mac, "%d.", timestamp)
+	_, _ = mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Dispatcher queues a delivery of every published event to the subscriptions wanting it.
+// It is the order.EventSink of the webhooks.
+type Dispatcher struct {
+	Repo Repository
+}
+
+func (d *Dispatcher) Publish(ctx context.Context, event order.DomainEvent) error {
+	subscriptions, err := d.Repo.ListSubscriptions(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list webhook subscriptions: %w", err)
+	}
+
+	now := time.Now().UTC()
+	for _, subscription := range subscriptions {
+		if !subscription.wants(event.Type) {
+			continue
+		}
+
+		delivery := Delivery{
+			ID:             deliveryID(subscription.ID, event),
+			SubscriptionID: subscription.ID,
+			Event:          event,
+			Status:         DeliveryPending,
+			NextAttemptAt:  now,
+			CreatedAt:      now,
+		}
+
+		if err := d.Repo.InsertDelivery(ctx, delivery); err != nil {
+			return fmt.Errorf("failed to queue webhook delivery: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// deliveryID derives the id of the delivery of an outbox event from the event id, so that the event
+// dispatched again by the outbox relay is not delivered twice. Other events get a random id.
+func deliveryID(subscriptionID uuid.UUID, event order.DomainEvent) uuid.UUID {
+	if event.ID == 0 {
+		return uuid.New()
+	}
+
+	return uuid.NewSHA1(subscriptionID, []byte(strconv.FormatInt(event.ID, 10)))
+}
+
+// Worker sends the due deliveries, retrying failures with an exponential backoff until
+// MaxAttempts, after which the delivery is dead.
+type Worker struct {
+	Repo   Repository
+	Client *http.Client
+	// BatchSize bounds the deliveries claimed at once.
+	BatchSize int
+	// Lease is how long a claimed delivery is hidden from other workers, it must exceed the client timeout.
+	Lease       time.Duration
+	MinBackoff  time.Duration
+	MaxBackoff  time.Duration
+	MaxAttempts int
+}
+
+// errSubscriptionDeleted kills the pending deliveries of a deleted subscription.
+var errSubscriptionDeleted = errors.New("subscription deleted")
+
+// DeliverDue attempts a batch of due deliveries and returns their number.
+func (w *Worker) DeliverDue(ctx context.Context) (int, error) {
+	due, err := w.Repo.ClaimDue(ctx, time.Now().UTC(), w.Lease, w.BatchSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to claim webhook deliveries: %w", err)
+	}
+
+	for _, delivery := range due {
+		if err := w.deliver(ctx, delivery); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(due), nil
+}
+
+func (w *Worker) deliver(ctx context.Context, delivery Delivery) error {
+	var attempt Attempt
+	subscription, err := w.Repo.FindSubscription(ctx, delivery.SubscriptionID)
+	if errors.Is(err, ErrNotExist) {
+		attempt = Attempt{DeliveryID: delivery.ID, AttemptedAt: time.Now().UTC(), Error: errSubscriptionDeleted.Error()}
+		delivery.Attempts = w.MaxAttempts
+	} else if err != nil {
+		return fmt.Errorf("failed to find webhook subscription: %w", err)
+	} else {
+		attempt = w.send(ctx, subscription, delivery)
+		if ctx.Err() != nil {
+			// The lease expires and another attempt is made after the restart.
+			return ctx.Err()
+		}
+		delivery.Attempts++
+	}
+
+	switch {
+	case attempt.Error == "":
+		delivery.Status = DeliveryDelivered
+		delivery.LastError = ""
+	case delivery.Attempts >= w.MaxAttempts:
+		delivery.Status = DeliveryDead
+		delivery.LastError = attempt.Error
+	default:
+		delivery.NextAttemptAt = attempt.AttemptedAt.Add(w.backoff(delivery.Attempts - 1))
+		delivery.LastError = attempt.Error
+	}
+
+	if err := w.Repo.RecordAttempt(ctx, delivery, attempt); err != nil {
+		return fmt.Errorf("failed to record webhook attempt: %w", err)
+	}
+
+	return nil
+}
+
+// send posts the signed event to the subscription URL, any response but a 2xx is a failure.
+func (w *Worker) send(ctx context.Context, subscription Subscription, delivery Delivery) Attempt {
+	attempt := Attempt{DeliveryID: delivery.ID, AttemptedAt: time.Now().UTC()}
+
+	body, err := json.Marshal(delivery.Event)
+	if err != nil {
+		attempt.Error = fmt.Sprintf("failed to encode event: %v", err)
+		return attempt
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, subscription.URL, bytes.NewReader(body))
+	if err != nil {
+		attempt.Error = fmt.Sprintf("failed to create request: %v", err)
+		return attempt
+	}
+
+	timestamp := attempt.AttemptedAt.Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(DeliveryHeader, delivery.ID.String())
+	req.Header.Set(EventHeader, string(delivery.Event.Type))
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(SignatureHeader, Sign(subscription.Secret, timestamp, body))
+
+	res, err := w.Client.Do(req)
+	attempt.Duration = time.Since(attempt.AttemptedAt)
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	defer res.Body.Close()
+
+	// The body is drained so that the connection is reused.
+	const maxDrained = 64 << 10
+	_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, maxDrained))
+
+	attempt.StatusCode = res.StatusCode
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		attempt.Error = fmt.Sprintf("unexpected status %d", res.StatusCode)
+	}
+
+	return attempt
+}
+
+// backoff is the delay before the next attempt of a delivery already retried retries times.
+func (w *Worker) backoff(retries int) time.Duration {
+	delay := w.MinBackoff
+	for i := 0; i < retries && delay < w.MaxBackoff; i++ {
+		delay *= 2
+	}
+
+	return min(delay, w.MaxBackoff)
+}
+
+// Run delivers the due deliveries every interval and right away while batches come full, until ctx is done.
+func (w *Worker) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			attempted, err := w.DeliverDue(ctx)
+			if err != nil && !errors.Is(err, context.Canceled) {
+				fmt.Println("failed to deliver webhooks:", err)
+			}
+			if err != nil || attempted < w.BatchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
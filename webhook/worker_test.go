@@ -0,0 +1,225 @@
+package webhook_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"first-little-server/order"
+	"first-little-server/webhook"
+
+	"github.com/google/uuid"
+)
+
+// loopback lets the deliveries reach the httptest receivers.
+var loopback = webhook.AddressPolicy{Allowed: []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}}
+
+// receiver serves the deliveries with the statuses, the last one repeated.
+func receiver(t *testing.T, check func(r *http.Request, body []byte), statuses ...int) (*httptest.Server, *atomic.Int32) {
+	var calls atomic.Int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("failed to read delivery: %v", err)
+		}
+
+		if check != nil {
+			check(r, body)
+		}
+
+		call := int(calls.Add(1)) - 1
+		w.WriteHeader(statuses[min(call, len(statuses)-1)])
+	}))
+	t.Cleanup(server.Close)
+
+	return server, &calls
+}
+
+// subscribe registers url for the created orders and publishes one, which queues a delivery.
+func subscribe(t *testing.T, repo webhook.Repository, url string) webhook.Subscription {
+	ctx := context.Background()
+	subscription := webhook.Subscription{
+		ID:         uuid.New(),
+		URL:        url,
+		EventTypes: []order.DomainEventType{order.OrderCreated},
+		Secret:     "secret",
+		CreatedAt:  time.Now().UTC(),
+	}
+
+	if err := repo.InsertSubscription(ctx, subscription); err != nil {
+		t.Fatalf("InsertSubscription: %v", err)
+	}
+
+	event := order.DomainEvent{ID: 1, Type: order.OrderCreated, OrderID: 1, OccurredAt: time.Now().UTC()}
+	if err := (&webhook.Dispatcher{Repo: repo}).Publish(ctx, event); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+
+	return subscription
+}
+
+func newWorker(repo webhook.Repository, policy webhook.AddressPolicy) *webhook.Worker {
+	return &webhook.Worker{
+		Repo:        repo,
+		Client:      webhook.NewClient(time.Second, policy),
+		BatchSize:   10,
+		Lease:       time.Minute,
+		MinBackoff:  20 * time.Millisecond,
+		MaxBackoff:  40 * time.Millisecond,
+		MaxAttempts: 4,
+	}
+}
+
+// deliveryOf returns the only delivery of subscription with status.
+func deliveryOf(t *testing.T, repo webhook.Repository, subscription webhook.Subscription, status webhook.DeliveryStatus) webhook.Delivery {
+	t.Helper()
+
+	deliveries, err := repo.ListDeliveries(context.Background(), subscription.ID, status)
+	if err != nil {
+		t.Fatalf("ListDeliveries: %v", err)
+	}
+
+	if len(deliveries) != 1 {
+		t.Fatalf("ListDeliveries(%s) returned %d deliveries, want 1", status, len(deliveries))
+	}
+
+	return deliveries[0]
+}
+
+func deliverDue(t *testing.T, worker *webhook.Worker, want int) {
+	t.Helper()
+
+	attempted, err := worker.DeliverDue(context.Background())
+	if err != nil {
+		t.Fatalf("DeliverDue: %v", err)
+	}
+
+	if attempted != want {
+		t.Fatalf("DeliverDue attempted %d deliveries, want %d", attempted, want)
+	}
+}
+
+func TestWorkerSignsDeliveries(t *testing.T) {
+	repo := &webhook.MemoryRepo{}
+	var subscription webhook.Subscription
+
+	server, calls := receiver(t, func(r *http.Request, body []byte) {
+		timestamp, err := strconv.ParseInt(r.Header.Get(webhook.TimestampHeader), 10, 64)
+		if err != nil {
+			t.Errorf("invalid timestamp header: %v", err)
+		}
+
+		if got, want := r.Header.Get(webhook.SignatureHeader), webhook.Sign("secret", timestamp, body); got != want {
+			t.Errorf("signature = %q, want %q", got, want)
+		}
+
+		if got := r.Header.Get(webhook.EventHeader); got != string(order.OrderCreated) {
+			t.Errorf("event header = %q, want %q", got, order.OrderCreated)
+		}
+
+		if r.Header.Get(webhook.DeliveryHeader) == "" {
+			t.Error("missing delivery header")
+		}
+	}, http.StatusNoContent)
+
+	subscription = subscribe(t, repo, server.URL)
+	deliverDue(t, newWorker(repo, loopback), 1)
+
+	if calls.Load() != 1 {
+		t.Errorf("receiver called %d times, want 1", calls.Load())
+	}
+
+	delivered := deliveryOf(t, repo, subscription, webhook.DeliveryDelivered)
+	if delivered.Attempts != 1 {
+		t.Errorf("delivery attempts = %d, want 1", delivered.Attempts)
+	}
+}
+
+func TestWorkerRetriesWithBackoffThenDeadLetters(t *testing.T) {
+	ctx := context.Background()
+	repo := &webhook.MemoryRepo{}
+	server, calls := receiver(t, nil, http.StatusInternalServerError)
+	subscription := subscribe(t, repo, server.URL)
+	worker := newWorker(repo, loopback)
+
+	// The delay doubles from MinBackoff and is capped at MaxBackoff.
+	for _, backoff := range []time.Duration{20 * time.Millisecond, 40 * time.Millisecond, 40 * time.Millisecond} {
+		deliverDue(t, worker, 1)
+
+		pending := deliveryOf(t, repo, subscription, webhook.DeliveryPending)
+		attempts, err := repo.Attempts(ctx, pending.ID)
+		if err != nil {
+			t.Fatalf("Attempts: %v", err)
+		}
+
+		last := attempts[len(attempts)-1]
+		if got := pending.NextAttemptAt.Sub(last.AttemptedAt); got != backoff {
+			t.Errorf("backoff after attempt %d = %s, want %s", len(attempts), got, backoff)
+		}
+
+		if last.StatusCode != http.StatusInternalServerError || pending.LastError == "" {
+			t.Errorf("attempt %d = %+v with last error %q, want a recorded 500", len(attempts), last, pending.LastError)
+		}
+
+		// Nothing is due before the backoff elapses.
+		deliverDue(t, worker, 0)
+		time.Sleep(time.Until(pending.NextAttemptAt))
+	}
+
+	// The last attempt exhausts MaxAttempts, the delivery goes to the dead-letter queue.
+	deliverDue(t, worker, 1)
+
+	dead := deliveryOf(t, repo, subscription, webhook.DeliveryDead)
+	if dead.Attempts != 4 || calls.Load() != 4 {
+		t.Errorf("dead delivery after %d attempts and %d calls, want 4", dead.Attempts, calls.Load())
+	}
+
+	deliverDue(t, worker, 0)
+}
+
+func TestWorkerRetrySucceeds(t *testing.T) {
+	repo := &webhook.MemoryRepo{}
+	server, _ := receiver(t, nil, http.StatusBadGateway, http.StatusOK)
+	subscription := subscribe(t, repo, server.URL)
+	worker := newWorker(repo, loopback)
+
+	deliverDue(t, worker, 1)
+	time.Sleep(time.Until(deliveryOf(t, repo, subscription, webhook.DeliveryPending).NextAttemptAt))
+	deliverDue(t, worker, 1)
+
+	delivered := deliveryOf(t, repo, subscription, webhook.DeliveryDelivered)
+	if delivered.Attempts != 2 || delivered.LastError != "" {
+		t.Errorf("delivered after %d attempts with last error %q, want 2 and none", delivered.Attempts, delivered.LastError)
+	}
+}
+
+func TestWorkerRefusesInternalAddresses(t *testing.T) {
+	ctx := context.Background()
+	repo := &webhook.MemoryRepo{}
+	server, calls := receiver(t, nil, http.StatusOK)
+	subscription := subscribe(t, repo, server.URL)
+
+	deliverDue(t, newWorker(repo, webhook.AddressPolicy{}), 1)
+
+	if calls.Load() != 0 {
+		t.Errorf("receiver on loopback called %d times, want 0", calls.Load())
+	}
+
+	pending := deliveryOf(t, repo, subscription, webhook.DeliveryPending)
+	if !strings.Contains(pending.LastError, webhook.ErrForbiddenAddress.Error()) {
+		t.Errorf("last error = %q, want %q", pending.LastError, webhook.ErrForbiddenAddress)
+	}
+
+	attempts, err := repo.Attempts(ctx, pending.ID)
+	if err != nil || len(attempts) != 1 || attempts[0].StatusCode != 0 {
+		t.Errorf("Attempts = %+v, %v, want one attempt without response", attempts, err)
+	}
+}